@@ -0,0 +1,73 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/utils/logger"
+)
+
+//Alert raised for the operators(logged, and added to the alert stream when configured)
+type Alert struct {
+	Kind      string                 `json:"kind"`
+	ServerIDs []string               `json:"serverids"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RaisedOn  time.Time              `json:"raisedon"`
+}
+
+//same alert is suppressed within this window, the sync cycles would raise it on every cycle otherwise
+const suppressWindow = 10 * time.Minute
+
+var (
+	streamKey    string
+	streamMaxLen int64
+
+	mu         sync.Mutex
+	lastRaised = make(map[string]time.Time)
+)
+
+//Init redis stream of the alerts(empty => alerts are only logged)
+func Init(redisKey string, maxLen int64) {
+	streamKey, streamMaxLen = redisKey, maxLen
+}
+
+//Raise logging/streaming the alert, unless the same alert was raised within the suppress window
+func Raise(a Alert) {
+	sort.Strings(a.ServerIDs)
+	key := fmt.Sprintf("%s|%s|%s", a.Kind, strings.Join(a.ServerIDs, ","), a.Message)
+
+	mu.Lock()
+	if t, ok := lastRaised[key]; ok && time.Since(t) < suppressWindow {
+		mu.Unlock()
+		return
+	}
+	lastRaised[key] = time.Now()
+	mu.Unlock()
+
+	a.RaisedOn = time.Now().UTC()
+	logger.Log().Error(fmt.Sprintf("ALERT %v servers: %v, %v", a.Kind, a.ServerIDs, a.Message))
+
+	if streamKey == "" {
+		return
+	}
+
+	byteRes, err := json.Marshal(a)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("ALERT (Marshal) %v Error : %v", a.Kind, err.Error()))
+		return
+	}
+
+	_, err = config.XAdd(streamKey, streamMaxLen, map[string]interface{}{
+		"kind": a.Kind,
+		"data": string(byteRes),
+	})
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("ALERT (Redis) %v Error : %v", a.Kind, err.Error()))
+	}
+}
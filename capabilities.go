@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"data-sync-agent/config"
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+//refreshServerCapabilities reading the feature flags of the servers from the server hash(<serverid>:capabilities),
+//the last known flags are kept on redis/parse errors
+func refreshServerCapabilities() {
+	if redisKeyForSQLServers == "" || len(sqlConnectionListData) == 0 {
+		return
+	}
+
+	fields := make([]string, 0, len(sqlConnectionListData))
+	for _, task := range sqlConnectionListData {
+		fields = append(fields, sourcedriver.CapabilitiesField(task.ServerID))
+	}
+
+	values, err := config.HMGet(redisKeyForSQLServers, fields)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("refreshServerCapabilities Redis Error : %v", err.Error()))
+		return
+	}
+
+	for i, task := range sqlConnectionListData {
+		value := ""
+		if i < len(values) {
+			if v, ok := values[i].(string); ok {
+				value = v
+			}
+		}
+
+		capabilities, err := sourcedriver.ParseCapabilities(value)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("refreshServerCapabilities Server=%v Error : %v", task.ServerID, err.Error()))
+			continue
+		}
+
+		if previous := task.Capabilities(); previous != capabilities {
+			logger.Log().Info(fmt.Sprintf("Server=%v capabilities changed %+v => %+v", task.ServerID, previous, capabilities))
+			task.SetCapabilities(capabilities)
+		}
+	}
+}
+
+//withoutDiversions dropping the diversion details of the servers not supporting the diversion
+func withoutDiversions(devices []*model.RegisteredDeviceData) {
+	for _, device := range devices {
+		device.DiversionDetails = nil
+	}
+}
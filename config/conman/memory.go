@@ -0,0 +1,454 @@
+package conman
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+//ErrUnsupportedOperation indicates a command not handled by the memory provider
+var ErrUnsupportedOperation = errors.New("operation not supported by the memory provider")
+
+var _ Provider = (*MemoryProvider)(nil)
+
+//MemoryProvider -> in-memory key/value store(used for testing without redis)
+//values are stored as strings like redis, missing keys/fields return redis.Nil
+type MemoryProvider struct {
+	mu        sync.RWMutex
+	values    map[string]string
+	hashes    map[string]map[string]string
+	sets      map[string]map[string]bool
+	expiry    map[string]time.Time
+	published map[string][]string
+	streams   map[string][]map[string]interface{}
+	scripts   map[string]ScriptFunc
+}
+
+//ScriptFunc go implementation of a lua script, for the tests of its callers
+type ScriptFunc func(keys []string, args ...interface{}) (interface{}, error)
+
+//NewMemoryProvider ...
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		values:    make(map[string]string),
+		hashes:    make(map[string]map[string]string),
+		sets:      make(map[string]map[string]bool),
+		expiry:    make(map[string]time.Time),
+		published: make(map[string][]string),
+		streams:   make(map[string][]map[string]interface{}),
+		scripts:   make(map[string]ScriptFunc),
+	}
+}
+
+//Get ...
+func (mp *MemoryProvider) Get(key string) (string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	value, ok := mp.values[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return value, nil
+}
+
+//MGet ...
+func (mp *MemoryProvider) MGet(keys []string) ([]interface{}, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		mp.expire(key)
+		if value, ok := mp.values[key]; ok {
+			values = append(values, value)
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
+
+//Set ...
+func (mp *MemoryProvider) Set(key string, value interface{}) error {
+	return mp.SetWithExpiry(key, value, 0)
+}
+
+//MSet ...
+func (mp *MemoryProvider) MSet(kvPairs map[string]interface{}) error {
+	for key, value := range kvPairs {
+		if err := mp.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SetWithExpiry ...
+func (mp *MemoryProvider) SetWithExpiry(key string, value interface{}, expiry time.Duration) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.values[key] = toString(value)
+	delete(mp.expiry, key)
+	if expiry > 0 {
+		mp.expiry[key] = time.Now().Add(expiry)
+	}
+	return nil
+}
+
+//Del ...
+func (mp *MemoryProvider) Del(keys []string) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	count := int64(0)
+	for _, key := range keys {
+		mp.expire(key)
+		if mp.exists(key) {
+			count = count + 1
+		}
+		mp.remove(key)
+	}
+	return count, nil
+}
+
+//Scan -> get keys with prefix
+func (mp *MemoryProvider) Scan(key string) ([]string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	result := make([]string, 0)
+	for _, k := range mp.keys() {
+		mp.expire(k)
+		if strings.HasPrefix(k, key) && mp.exists(k) {
+			result = append(result, k)
+		}
+	}
+	return result, nil
+}
+
+//HGet ...
+func (mp *MemoryProvider) HGet(key string, field string) (string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	value, ok := mp.hashes[key][field]
+	if !ok {
+		return "", redis.Nil
+	}
+	return value, nil
+}
+
+//HMGet ...
+func (mp *MemoryProvider) HMGet(key string, fields []string) ([]interface{}, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	values := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		if value, ok := mp.hashes[key][field]; ok {
+			values = append(values, value)
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
+
+//HGetAll ...
+func (mp *MemoryProvider) HGetAll(key string) (map[string]string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	values := make(map[string]string, len(mp.hashes[key]))
+	for field, value := range mp.hashes[key] {
+		values[field] = value
+	}
+	return values, nil
+}
+
+//HSet ...
+func (mp *MemoryProvider) HSet(key string, field string, value interface{}) error {
+	return mp.HMSet(key, map[string]interface{}{field: value})
+}
+
+//HMSet ...
+func (mp *MemoryProvider) HMSet(key string, fieldValPair map[string]interface{}) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	hash, ok := mp.hashes[key]
+	if !ok {
+		hash = make(map[string]string, len(fieldValPair))
+		mp.hashes[key] = hash
+	}
+	for field, value := range fieldValPair {
+		hash[field] = toString(value)
+	}
+	return nil
+}
+
+//HDel ...
+func (mp *MemoryProvider) HDel(key string, fields []string) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	count := int64(0)
+	for _, field := range fields {
+		if _, ok := mp.hashes[key][field]; ok {
+			delete(mp.hashes[key], field)
+			count = count + 1
+		}
+	}
+	if len(mp.hashes[key]) == 0 {
+		delete(mp.hashes, key)
+	}
+	return count, nil
+}
+
+//HLen ...
+func (mp *MemoryProvider) HLen(key string) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	return int64(len(mp.hashes[key])), nil
+}
+
+//Expire ...
+func (mp *MemoryProvider) Expire(key string, expiration time.Duration) error {
+	return mp.ExpireAt(key, time.Now().Add(expiration))
+}
+
+//ExpireAt ...
+func (mp *MemoryProvider) ExpireAt(key string, tm time.Time) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	if mp.exists(key) {
+		mp.expiry[key] = tm
+	}
+	return nil
+}
+
+//TTL -> -2 for missing keys and -1 for keys without expiry, like redis
+func (mp *MemoryProvider) TTL(key string) (time.Duration, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	if !mp.exists(key) {
+		return -2, nil
+	}
+	if tm, ok := mp.expiry[key]; ok {
+		return time.Until(tm), nil
+	}
+	return -1, nil
+}
+
+//Exists ...
+func (mp *MemoryProvider) Exists(key string) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	if mp.exists(key) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+//SAdd -> adds the members to the set(not part of the Provider, used to seed the sets)
+func (mp *MemoryProvider) SAdd(key string, members ...string) int64 {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	set, ok := mp.sets[key]
+	if !ok {
+		set = make(map[string]bool, len(members))
+		mp.sets[key] = set
+	}
+
+	count := int64(0)
+	for _, m := range members {
+		if !set[m] {
+			set[m] = true
+			count = count + 1
+		}
+	}
+	return count
+}
+
+//SMembers -> members of the set, sorted
+func (mp *MemoryProvider) SMembers(key string) ([]string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	members := make([]string, 0, len(mp.sets[key]))
+	for m := range mp.sets[key] {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+//SRem -> removes the members from the set
+func (mp *MemoryProvider) SRem(key string, members []string) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire(key)
+	count := int64(0)
+	for _, m := range members {
+		if mp.sets[key][m] {
+			delete(mp.sets[key], m)
+			count = count + 1
+		}
+	}
+	if len(mp.sets[key]) == 0 {
+		delete(mp.sets, key)
+	}
+	return count, nil
+}
+
+//Eval -> runs the go implementation of the script(SetScript), other lua scripts are not supported
+func (mp *MemoryProvider) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	mp.mu.RLock()
+	fn, ok := mp.scripts[script]
+	mp.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnsupportedOperation
+	}
+	return fn(keys, args...)
+}
+
+//SetScript -> registers the go implementation of the script, it may call the other provider methods
+func (mp *MemoryProvider) SetScript(script string, fn ScriptFunc) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.scripts[script] = fn
+}
+
+//Publish -> records the msg of the channel, considered as received by a single subscriber
+func (mp *MemoryProvider) Publish(channelName string, msg interface{}) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.published[channelName] = append(mp.published[channelName], toString(msg))
+	return 1, nil
+}
+
+//Published -> msgs published to the channel, in published order
+func (mp *MemoryProvider) Published(channelName string) []string {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return append([]string{}, mp.published[channelName]...)
+}
+
+//XAdd -> appends the entry to the stream, trimmed to maxLen(when > 0)
+func (mp *MemoryProvider) XAdd(streamName string, maxLen int64, values map[string]interface{}) (string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		entry[k] = toString(v)
+	}
+
+	stream := append(mp.streams[streamName], entry)
+	if maxLen > 0 && int64(len(stream)) > maxLen {
+		stream = stream[int64(len(stream))-maxLen:]
+	}
+	mp.streams[streamName] = stream
+
+	return fmt.Sprintf("%d-0", len(stream)), nil
+}
+
+//Stream -> entries of the stream, in added order
+func (mp *MemoryProvider) Stream(streamName string) []map[string]interface{} {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return append([]map[string]interface{}{}, mp.streams[streamName]...)
+}
+
+//Close ...
+func (mp *MemoryProvider) Close() error {
+	return nil
+}
+
+//expire removes the key once expired(lock must be held)
+func (mp *MemoryProvider) expire(key string) {
+	if tm, ok := mp.expiry[key]; ok && !time.Now().Before(tm) {
+		mp.remove(key)
+	}
+}
+
+func (mp *MemoryProvider) remove(key string) {
+	delete(mp.values, key)
+	delete(mp.hashes, key)
+	delete(mp.sets, key)
+	delete(mp.streams, key)
+	delete(mp.expiry, key)
+}
+
+func (mp *MemoryProvider) exists(key string) bool {
+	_, value := mp.values[key]
+	_, hash := mp.hashes[key]
+	_, set := mp.sets[key]
+	_, stream := mp.streams[key]
+	return value || hash || set || stream
+}
+
+//keys all the keys, sorted
+func (mp *MemoryProvider) keys() []string {
+	unique := make(map[string]bool)
+	for k := range mp.values {
+		unique[k] = true
+	}
+	for k := range mp.hashes {
+		unique[k] = true
+	}
+	for k := range mp.sets {
+		unique[k] = true
+	}
+	for k := range mp.streams {
+		unique[k] = true
+	}
+
+	keys := make([]string, 0, len(unique))
+	for k := range unique {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//toString redis string value of the given value
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
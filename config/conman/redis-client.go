@@ -187,11 +187,30 @@ func (rp RadisProviderClient) SMembers(key string) ([]string, error) {
 	return rp.client.SMembers(key).Result()
 }
 
+//SRem -> removes the members from the set
+func (rp RadisProviderClient) SRem(key string, members []string) (int64, error) {
+	values := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		values = append(values, m)
+	}
+	return rp.client.SRem(key, values...).Result()
+}
+
+//Eval -> executes the lua script(atomic), keys of a cluster must be on the same slot
+func (rp RadisProviderClient) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return rp.client.Eval(script, keys, args...).Result()
+}
+
 //Publish -> publisg msg to channel...
 func (rp RadisProviderClient) Publish(channelName string, msg interface{}) (int64, error) {
 	return rp.client.Publish(channelName, msg).Result()
 }
 
+//XAdd -> append entry to the stream, trimmed approx. to maxLen(when > 0)
+func (rp RadisProviderClient) XAdd(streamName string, maxLen int64, values map[string]interface{}) (string, error) {
+	return rp.client.XAdd(&redis.XAddArgs{Stream: streamName, MaxLenApprox: maxLen, Values: values}).Result()
+}
+
 //Close -> cloes the redis cluster conn...
 func (rp RadisProviderClient) Close() error {
 	return rp.client.Close()
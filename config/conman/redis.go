@@ -36,8 +36,12 @@ type (
 		Exists(key string) (int64, error)
 
 		SMembers(key string) ([]string, error)
+		SRem(key string, members []string) (int64, error)
+
+		Eval(script string, keys []string, args ...interface{}) (interface{}, error)
 
 		Publish(channelName string, msg interface{}) (int64, error)
+		XAdd(streamName string, maxLen int64, values map[string]interface{}) (string, error)
 
 		Close() error
 	}
@@ -239,11 +243,30 @@ func (rp *RadisProvider) SMembers(key string) ([]string, error) {
 	return rp.client.SMembers(key).Result()
 }
 
+//SRem -> removes the members from the set
+func (rp *RadisProvider) SRem(key string, members []string) (int64, error) {
+	values := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		values = append(values, m)
+	}
+	return rp.client.SRem(key, values...).Result()
+}
+
+//Eval -> executes the lua script(atomic), keys of a cluster must be on the same slot
+func (rp *RadisProvider) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return rp.client.Eval(script, keys, args...).Result()
+}
+
 //Publish -> publisg msg to channel...
 func (rp *RadisProvider) Publish(channelName string, msg interface{}) (int64, error) {
 	return rp.client.Publish(channelName, msg).Result()
 }
 
+//XAdd -> append entry to the stream, trimmed approx. to maxLen(when > 0)
+func (rp *RadisProvider) XAdd(streamName string, maxLen int64, values map[string]interface{}) (string, error) {
+	return rp.client.XAdd(&redis.XAddArgs{Stream: streamName, MaxLenApprox: maxLen, Values: values}).Result()
+}
+
 //Close -> cloes the redis cluster conn...
 func (rp *RadisProvider) Close() error {
 	return rp.client.Close()
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,8 +14,13 @@ import (
 
 var configProvider cm.Provider
 
-func init() {
-	connectAsRedisClusterMode := helper.GetEnv(helper.ConnectAsRedisClusterMode)
+//ErrConfigSecret indicates missing config server(redis) connection details
+var ErrConfigSecret = errors.New("redis connection secret error")
+
+//InitializeConfigProvider initializing the config provider from env....(called by the app on startup)
+func InitializeConfigProvider() (cm.Provider, error) {
+
+	connectAsCluster := helper.GetEnv(helper.ConnectAsRedisClusterMode)
 
 	configServerEndPoint := helper.GetEnv(helper.ConfigServerEndPoint)
 
@@ -22,20 +28,11 @@ func init() {
 
 	configServerPassword := helper.GetEnv(helper.ConfigServerPassword)
 
-	
-	InitializeConfigProvider(configServerEndPoint, configServerUserName, configServerPassword, connectAsRedisClusterMode)
-}
-
-//InitializeConfigProvider initializing the config provider....
-func InitializeConfigProvider(configServerEndPoint, configServerUserName, configServerPassword, connectAsCluster string) cm.Provider {
-
 	var confProvider cm.Provider
 	var err error
 
-	if nOK := helper.HasEmpty(configServerEndPoint,configServerUserName,configServerPassword,connectAsCluster); nOK {
-
-		logger.Log().Fatal("Redis Connection Secret Error")
-		return nil
+	if nOK := helper.HasEmpty(configServerEndPoint, configServerUserName, configServerPassword, connectAsCluster); nOK {
+		return nil, ErrConfigSecret
 	}
 
 	if connectAsCluster == "1" {
@@ -47,14 +44,19 @@ func InitializeConfigProvider(configServerEndPoint, configServerUserName, config
 	if err != nil {
 		logger.Log().With(zap.Error(err)).Error(fmt.Sprintf("Config Server Connection Error EndPoint %s", configServerEndPoint))
 
-		return nil
+		return nil, err
 	}
 
 	logger.Log().Info(fmt.Sprintf("Config Server started with EndPoint %s", configServerEndPoint))
 
 	//assigning to global variable(must)
 	configProvider = confProvider
-	return confProvider
+	return confProvider, nil
+}
+
+//SetConfigProvider replacing the config provider(e.g. conman.NewMemoryProvider() without redis)
+func SetConfigProvider(provider cm.Provider) {
+	configProvider = provider
 }
 
 //Get ...
@@ -185,11 +187,26 @@ func SMembers(key string) ([]string, error) {
 	return configProvider.SMembers(key)
 }
 
+//SRem ...
+func SRem(key string, members []string) (int64, error) {
+	return configProvider.SRem(key, members)
+}
+
+//Eval -> executes the lua script...
+func Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return configProvider.Eval(script, keys, args...)
+}
+
 //Publish -> publisg msg to channel...
 func Publish(channelName string, msg interface{}) (int64, error) {
 	return configProvider.Publish(channelName, msg)
 }
 
+//XAdd -> append entry to the stream...
+func XAdd(streamName string, maxLen int64, values map[string]interface{}) (string, error) {
+	return configProvider.XAdd(streamName, maxLen, values)
+}
+
 //Close ...
 func Close() error {
 	return configProvider.Close()
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"data-sync-agent/dataservice/spatialentity"
 	"data-sync-agent/helper"
 
 	"github.com/jackc/pgconn"
@@ -18,6 +20,9 @@ import (
 
 var dbc *pgx.Conn
 
+//dbc is a single connection, so the queries(sync job, janitor) are serialized
+var dbMu sync.Mutex
+
 //InitPostgreConnection ...
 func InitPostgreConnection(cp *model.DBCredentialProvider) error {
 
@@ -61,137 +66,381 @@ func InitPostgreConnection(cp *model.DBCredentialProvider) error {
 	return nil
 }
 
+//queuedSpatialData holds the spatial object for each queued batch statement, in queued order
+type queuedSpatialData struct {
+	descriptor *model.SpatialEntityDescriptor
+	data       *model.SpatialData
+}
+
 //SaveSpatialData ...
-func SaveSpatialData(ctx context.Context, spatialDataRequestData *model.SpatialRequestData) (int64, error) {
+//returns the change events of the spatial objects, once the changes are committed
+func SaveSpatialData(ctx context.Context, spatialDataRequestData *model.SpatialRequestData) (int64, []*model.SpatialChangeEvent, error) {
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
 
 	recordAffected := int64(0)
+	changeEvents := make([]*model.SpatialChangeEvent, 0)
 
 	currentTime := time.Now().UTC()
 	//creating trans
 	tx, _ := dbc.Begin(ctx)
 	batch := &pgx.Batch{}
+	queued := make([]queuedSpatialData, 0)
 
-	//constructing geofence data...
-	for _, data := range spatialDataRequestData.GeofenceData {
+	//constructing data for all the registered entities...
+	for _, d := range spatialentity.All() {
+		upsertQuery, deactivateQuery, historyQuery := upsertSpatialQuery(d), deactivateSpatialQuery(d), historySpatialQuery(d)
 
-		if len(strings.TrimSpace(data.OgrGeometry)) > 0 {
-			batch.Queue(`INSERT INTO tblmstgeofence (tenantuid,tenantgroupuid,geofenceuid,geofencenameen, geofencenameol,geofencetypeid,isapproved,active,lastmodifieddate, ogr_geometry, ogr_geography, createdon)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, ST_GeomFromText($10,4326), ST_GeographyFromText($10),$11) ON CONFLICT ON CONSTRAINT tblmstgeofence_pkey DO UPDATE SET geofencenameen = excluded.geofencenameen, geofencenameol = excluded.geofencenameol,geofencetypeid = excluded.geofencetypeid,isapproved = excluded.isapproved,active = excluded.active, lastmodifieddate = excluded.lastmodifieddate, ogr_geometry = excluded.ogr_geometry, ogr_geography=excluded.ogr_geography, modifiedon=excluded.createdon`, data.TenantUID, data.TenantGroupUID, data.GeofenceUID, data.GeofenceNameEN, data.GeofenceNameOL, data.GeofenceTypeID, data.IsApproved, data.Active, data.LastModifiedDate, data.OgrGeometry, currentTime)
-		} else {
-			batch.Queue(`UPDATE tblmstgeofence SET active = $1, lastmodifieddate = $2, ogr_geometry = NULL, ogr_geography= NULL, modifiedon= $6 WHERE tenantuid = $3 AND tenantgroupuid = $4 AND geofenceuid = $5`, data.Active, data.LastModifiedDate, data.TenantUID, data.TenantGroupUID, data.GeofenceUID, currentTime)
-		}
+		for _, data := range spatialDataRequestData.Data[d.Name] {
+
+			if len(strings.TrimSpace(data.OgrGeometry)) > 0 {
+				batch.Queue(upsertQuery, upsertSpatialArgs(d, data, currentTime)...)
+			} else {
+				batch.Queue(deactivateQuery, deactivateSpatialArgs(d, data, currentTime)...)
+			}
+
+			//versioned mode, history row queued right after the change
+			if d.HistoryTable != "" {
+				batch.Queue(historyQuery, historySpatialArgs(d, data, currentTime)...)
+			}
 
+			queued = append(queued, queuedSpatialData{descriptor: d, data: data})
+		}
 	}
 
-	//constructing area data...
-	for _, data := range spatialDataRequestData.AreaData {
+	//sending batch....
+	batchResult := dbc.SendBatch(ctx, batch)
+
+	//executing all the queries, each query returns the bounding box of the affected row....
+	for _, q := range queued {
+		rows, err := batchResult.Query()
+		if err == nil {
+			for rows.Next() {
+				event, scanErr := scanSpatialChangeEvent(rows, q, currentTime)
+				if scanErr != nil {
+					err = scanErr
+					break
+				}
+				changeEvents = append(changeEvents, event)
+				recordAffected = recordAffected + 1
+			}
+			rows.Close()
+
+			if err == nil {
+				err = rows.Err()
+			}
+		}
 
-		if len(strings.TrimSpace(data.OgrGeometry)) > 0 {
-			batch.Queue(`INSERT INTO tblmstarea (tenantuid,tenantgroupuid,areauid,active,lastmodifieddate, ogr_geometry, ogr_geography, createdon)
-		VALUES ($1, $2, $3, $4, $5, ST_GeomFromText($6,4326), ST_GeographyFromText($6), $7) ON CONFLICT ON CONSTRAINT tblmstarea_pkey DO UPDATE SET active = excluded.active,lastmodifieddate = excluded.lastmodifieddate, ogr_geometry = excluded.ogr_geometry, ogr_geography=excluded.ogr_geography, modifiedon=excluded.createdon`, data.TenantUID, data.TenantGroupUID, data.AreaUID, data.Active, data.LastModifiedDate, data.OgrGeometry, currentTime)
-		} else {
-			batch.Queue(`UPDATE tblmstarea SET active = $1, lastmodifieddate = $2, ogr_geometry = NULL, ogr_geography= NULL, modifiedon= $6 WHERE tenantuid = $3 AND tenantgroupuid = $4 AND areauid = $5`, data.Active, data.LastModifiedDate, data.TenantUID, data.TenantGroupUID, data.AreaUID, currentTime)
+		if err == nil && q.descriptor.HistoryTable != "" {
+			_, err = batchResult.Exec()
 		}
 
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("batchResult %s UID: %s Error : %v", q.descriptor.Name, spatialentity.UID(q.descriptor, q.data), err.Error()))
+
+			batchResult.Close()
+			tx.Rollback(ctx)
+			return 0, nil, err
+		}
 	}
 
-	//constructing zone data...
-	for _, data := range spatialDataRequestData.ZoneData {
+	//closing current batch result..
+	batchResult.Close()
 
-		if len(strings.TrimSpace(data.OgrGeometry)) > 0 {
-			batch.Queue(`INSERT INTO tblmstzone (tenantuid,tenantgroupuid,zoneuid,active,lastmodifieddate, ogr_geometry, ogr_geography, createdon)
-		VALUES ($1, $2, $3, $4, $5, ST_GeomFromText($6,4326), ST_GeographyFromText($6), $7) ON CONFLICT ON CONSTRAINT tblmstzone_pkey DO UPDATE SET active = excluded.active,lastmodifieddate = excluded.lastmodifieddate, ogr_geometry = excluded.ogr_geometry, ogr_geography=excluded.ogr_geography, modifiedon=excluded.createdon`, data.TenantUID, data.TenantGroupUID, data.ZoneUID, data.Active, data.LastModifiedDate, data.OgrGeometry, currentTime)
-		} else {
-			batch.Queue(`UPDATE tblmstzone SET active = $1, lastmodifieddate = $2, ogr_geometry = NULL, ogr_geography= NULL , modifiedon= $6 WHERE tenantuid = $3 AND tenantgroupuid = $4 AND zoneuid = $5`, data.Active, data.LastModifiedDate, data.TenantUID, data.TenantGroupUID, data.ZoneUID, currentTime)
+	//committing trans
+	if recordAffected > 0 {
+		if err := tx.Commit(ctx); err != nil {
+			logger.Log().Error(fmt.Sprintf("SaveSpatialData Commit Error : %v", err.Error()))
+			return 0, nil, err
 		}
+	} else {
+		tx.Rollback(ctx)
+	}
+
+	return recordAffected, changeEvents, nil
+}
 
+//scanSpatialChangeEvent constructing the change event from the returned bounding box
+func scanSpatialChangeEvent(rows pgx.Rows, q queuedSpatialData, currentTime time.Time) (*model.SpatialChangeEvent, error) {
+	var minX, minY, maxX, maxY *float64
+	if err := rows.Scan(&minX, &minY, &maxX, &maxY); err != nil {
+		return nil, err
 	}
 
-	//constructing nogo-area data...
-	for _, data := range spatialDataRequestData.NoGoAreaData {
+	event := &model.SpatialChangeEvent{
+		TenantUID:  spatialentity.TenantUID(q.descriptor, q.data),
+		EntityType: q.descriptor.Name,
+		UID:        spatialentity.UID(q.descriptor, q.data),
+		Action:     model.SpatialUpsert,
+		ChangedOn:  currentTime,
+	}
 
-		if len(strings.TrimSpace(data.OgrGeometry)) > 0 {
-			batch.Queue(`INSERT INTO tblmstvehiclenogoarea (tenantuid,tenantgroupuid,nogoareageofenceuid,active,lastmodifieddate, ogr_geometry, ogr_geography,createdon)
-		VALUES ($1, $2, $3, $4, $5, ST_GeomFromText($6,4326), ST_GeographyFromText($6), $7) ON CONFLICT ON CONSTRAINT tblmstvehiclenogoarea_pkey DO UPDATE SET active = excluded.active,lastmodifieddate = excluded.lastmodifieddate, ogr_geometry = excluded.ogr_geometry, ogr_geography=excluded.ogr_geography, modifiedon=excluded.createdon`, data.TenantUID, data.TenantGroupUID, data.NoGoAreaGeofenceUID, data.Active, data.LastModifiedDate, data.OgrGeometry, currentTime)
-		} else {
-			batch.Queue(`UPDATE tblmstvehiclenogoarea SET active = $1, lastmodifieddate = $2, ogr_geometry = NULL, ogr_geography= NULL , modifiedon= $6 WHERE tenantuid = $3 AND tenantgroupuid = $4 AND nogoareageofenceuid = $5`, data.Active, data.LastModifiedDate, data.TenantUID, data.TenantGroupUID, data.NoGoAreaGeofenceUID, currentTime)
-		}
+	if len(strings.TrimSpace(q.data.OgrGeometry)) == 0 {
+		event.Action = model.SpatialDeactivate
+	}
 
+	//deactivated object without previous geometry has no bounding box
+	if minX != nil && minY != nil && maxX != nil && maxY != nil {
+		event.BoundingBox = &model.SpatialBoundingBox{MinX: *minX, MinY: *minY, MaxX: *maxX, MaxY: *maxY}
 	}
 
-	//sending batch....
-	batchResult := dbc.SendBatch(ctx, batch)
+	return event, nil
+}
 
-	//executing all geofence based queries....
-	for _, data := range spatialDataRequestData.GeofenceData {
-		commandTag, err := batchResult.Exec()
-		if err != nil {
-			logger.Log().Error(fmt.Sprintf("batchResult geofenceUID: %s Error : %v", data.GeofenceUID, err.Error()))
+//upsertSpatialQuery insert/update query of the entity, older changes(out-of-order) don't overwrite the row..
+//args => key columns, attribute columns, active, lastmodifieddate, geometry(wkt), createdon, source srid
+func upsertSpatialQuery(d *model.SpatialEntityDescriptor) string {
+	columns := append(append(append([]string{}, d.KeyColumns...), d.AttributeColumns...), "active", "lastmodifieddate")
 
-			tx.Rollback(ctx)
-			return 0, err
-		}
+	values := make([]string, 0, len(columns))
+	for i := range columns {
+		values = append(values, fmt.Sprintf("$%d", i+1))
+	}
+	geometry, createdOn, srid := len(columns)+1, len(columns)+2, len(columns)+3
 
-		if commandTag.RowsAffected() > 0 {
-			recordAffected = recordAffected + commandTag.RowsAffected()
-		}
+	updates := make([]string, 0)
+	for _, c := range append(append([]string{}, d.AttributeColumns...), "active", "lastmodifieddate", "ogr_geometry", "ogr_geography", "sourcesrid") {
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", c, c))
 	}
+	updates = append(updates, "modifiedon = excluded.createdon")
+
+	return fmt.Sprintf(`INSERT INTO %s (%s, ogr_geometry, ogr_geography, sourcesrid, createdon)
+		VALUES (%s, ST_Transform(ST_GeomFromText($%d,$%d),4326), ST_Transform(ST_GeomFromText($%d,$%d),4326)::geography, $%d, $%d) ON CONFLICT ON CONSTRAINT %s DO UPDATE SET %s
+		WHERE %s`,
+		d.TargetTable, strings.Join(columns, ","),
+		strings.Join(values, ", "), geometry, srid, geometry, srid, srid, createdOn,
+		d.ConflictConstraint, strings.Join(updates, ", "),
+		inOrderCondition(d.TargetTable, "excluded.lastmodifieddate")) + boundingBoxReturning(d.TargetTable)
+}
 
-	//executing all area based queries....
-	for _, data := range spatialDataRequestData.AreaData {
-		commandTag, err := batchResult.Exec()
-		if err != nil {
-			logger.Log().Error(fmt.Sprintf("batchResult AreaUID: %s Error : %v", data.AreaUID, err.Error()))
+func upsertSpatialArgs(d *model.SpatialEntityDescriptor, data *model.SpatialData, currentTime time.Time) []interface{} {
+	args := make([]interface{}, 0, len(d.KeyColumns)+len(d.AttributeColumns)+5)
+	for _, c := range d.KeyColumns {
+		args = append(args, data.Keys[c])
+	}
+	for _, c := range d.AttributeColumns {
+		args = append(args, data.Attributes[c])
+	}
 
-			tx.Rollback(ctx)
-			return 0, err
-		}
-		
+	return append(args, data.Active, data.LastModifiedDate, data.OgrGeometry, currentTime, sourceSRID(data.SourceSRID))
+}
 
-		if commandTag.RowsAffected() > 0 {
-			recordAffected = recordAffected + commandTag.RowsAffected()
-		}
+//deactivateSpatialQuery clearing the geometry of the entity, older deactivations(out-of-order) are skipped..
+//args => active, lastmodifieddate, modifiedon, key columns
+func deactivateSpatialQuery(d *model.SpatialEntityDescriptor) string {
+	conditions := make([]string, 0, len(d.KeyColumns)+1)
+	for i, c := range d.KeyColumns {
+		conditions = append(conditions, fmt.Sprintf("%s.%s = $%d AND prev.%s = %s.%s", d.TargetTable, c, i+4, c, d.TargetTable, c))
 	}
+	conditions = append(conditions, "("+inOrderCondition(d.TargetTable, "$2")+")")
 
-	//executing all zone based queries....
-	for _, data := range spatialDataRequestData.ZoneData {
-		commandTag, err := batchResult.Exec()
-		if err != nil {
-			logger.Log().Error(fmt.Sprintf("batchResult zoneuid: %s Error : %v", data.ZoneUID, err.Error()))
+	//self-joined with the previous row version, to return the bounding box of the removed geometry
+	return fmt.Sprintf(`UPDATE %s SET active = $1, lastmodifieddate = $2, ogr_geometry = NULL, ogr_geography= NULL, modifiedon= $3 FROM %s AS prev WHERE %s`,
+		d.TargetTable, d.TargetTable, strings.Join(conditions, " AND ")) + boundingBoxReturning("prev")
+}
 
-			tx.Rollback(ctx)
-			return 0, err
-		}
+//inOrderCondition the change is not older than the saved row(rows without modification date are always changed)
+func inOrderCondition(table, lastModifiedDate string) string {
+	return fmt.Sprintf("%s.lastmodifieddate IS NULL OR %s.lastmodifieddate <= %s", table, table, lastModifiedDate)
+}
 
-		if commandTag.RowsAffected() > 0 {
-			recordAffected = recordAffected + commandTag.RowsAffected()
-		}
+//historySpatialQuery closing the open version and inserting the current row of the object as the new version on the history table..
+//runs right after the change, so a version is written only when the object exists and its values/geometry differ from the open version,
+//out-of-order changes(older than the open version) are not versioned
+//args => key columns, valid from
+func historySpatialQuery(d *model.SpatialEntityDescriptor) string {
+	columns := append(append(append([]string{}, d.KeyColumns...), d.AttributeColumns...), "active", "lastmodifieddate", "ogr_geometry", "sourcesrid")
+	validFrom := len(d.KeyColumns) + 1
+
+	historyConditions := make([]string, 0, len(d.KeyColumns))
+	targetConditions := make([]string, 0, len(d.KeyColumns))
+	for i, c := range d.KeyColumns {
+		historyConditions = append(historyConditions, fmt.Sprintf("%s = $%d", c, i+1))
+		targetConditions = append(targetConditions, fmt.Sprintf("%s.%s = $%d", d.TargetTable, c, i+1))
 	}
 
-	//executing all Nogo-Area based queries....
-	for _, data := range spatialDataRequestData.NoGoAreaData {
-		commandTag, err := batchResult.Exec()
-		if err != nil {
-			logger.Log().Error(fmt.Sprintf("batchResult NoGoAreaUID: %s Error : %v", data.NoGoAreaGeofenceUID, err.Error()))
+	//compared values(lastmodifieddate alone is not a change)
+	compared := append(append([]string{}, d.AttributeColumns...), "active", "sourcesrid")
+	latestValues := make([]string, 0, len(compared))
+	targetValues := make([]string, 0, len(compared))
+	for _, c := range compared {
+		latestValues = append(latestValues, "latest."+c)
+		targetValues = append(targetValues, d.TargetTable+"."+c)
+	}
 
-			tx.Rollback(ctx)
-			return 0, err
+	return fmt.Sprintf(`WITH latest AS (SELECT validfrom, %s, ogr_geometry FROM %s WHERE %s AND validto IS NULL ORDER BY validfrom DESC LIMIT 1),
+		changed AS (SELECT %s FROM %s WHERE %s AND NOT EXISTS (SELECT 1 FROM latest WHERE latest.validfrom > $%d
+			OR ((%s) IS NOT DISTINCT FROM (%s) AND COALESCE(ST_OrderingEquals(latest.ogr_geometry, %s.ogr_geometry), latest.ogr_geometry IS NULL AND %s.ogr_geometry IS NULL)))),
+		closed AS (UPDATE %s SET validto = $%d WHERE %s AND validto IS NULL AND validfrom <= $%d AND EXISTS (SELECT 1 FROM changed))
+		INSERT INTO %s (%s, validfrom) SELECT %s, $%d FROM changed`,
+		strings.Join(compared, ", "), d.HistoryTable, strings.Join(historyConditions, " AND "),
+		strings.Join(columns, ", "), d.TargetTable, strings.Join(targetConditions, " AND "), validFrom,
+		strings.Join(latestValues, ", "), strings.Join(targetValues, ", "), d.TargetTable, d.TargetTable,
+		d.HistoryTable, validFrom, strings.Join(historyConditions, " AND "), validFrom,
+		d.HistoryTable, strings.Join(columns, ","), strings.Join(columns, ", "), validFrom)
+}
+
+func historySpatialArgs(d *model.SpatialEntityDescriptor, data *model.SpatialData, currentTime time.Time) []interface{} {
+	args := make([]interface{}, 0, len(d.KeyColumns)+1)
+	for _, c := range d.KeyColumns {
+		args = append(args, data.Keys[c])
+	}
+
+	//version is valid from the source modification date
+	validFrom := data.LastModifiedDate
+	if validFrom.IsZero() {
+		validFrom = currentTime
+	}
+
+	return append(args, validFrom)
+}
+
+//GetSpatialDataAsOf returns the version of the spatial object valid on the given time, from the history table
+//geometry is returned as WKT(WGS 84)
+func GetSpatialDataAsOf(ctx context.Context, d *model.SpatialEntityDescriptor, keys map[string]interface{}, asOf time.Time) (*model.SpatialData, error) {
+	if d.HistoryTable == "" {
+		return nil, fmt.Errorf("spatial entity %v is not versioned", d.Name)
+	}
+
+	query, args := spatialAsOfQuery(d), spatialAsOfArgs(d, keys, asOf)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	rows, err := dbc.Query(ctx, query, args...)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("GetSpatialDataAsOf %v Error : %v", d.Name, err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
 		}
+		return nil, pgx.ErrNoRows
+	}
 
-		if commandTag.RowsAffected() > 0 {
-			recordAffected = recordAffected + commandTag.RowsAffected()
+	values, err := rows.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &model.SpatialData{
+		Keys:       make(map[string]interface{}, len(d.KeyColumns)),
+		Attributes: make(map[string]interface{}, len(d.AttributeColumns)),
+		SourceSRID: model.DefaultSRID,
+	}
+	for _, c := range d.KeyColumns {
+		data.Keys[c] = keys[c]
+	}
+	for i, c := range d.AttributeColumns {
+		data.Attributes[c] = values[i]
+	}
+
+	n := len(d.AttributeColumns)
+	switch active := values[n].(type) {
+	case int16:
+		data.Active = int(active)
+	case int32:
+		data.Active = int(active)
+	case int64:
+		data.Active = int(active)
+	case bool:
+		if active {
+			data.Active = 1
 		}
 	}
+	if lastModifiedDate, ok := values[n+1].(time.Time); ok {
+		data.LastModifiedDate = lastModifiedDate
+	}
+	if geometry, ok := values[n+2].(string); ok {
+		data.OgrGeometry = geometry
+	}
 
-	//closing current batch result..
-	batchResult.Close()
+	return data, nil
+}
 
-	//committing trans
-	if recordAffected > 0 {
-		tx.Commit(ctx)
+//spatialAsOfQuery version of the object valid on the time(valid from inclusive, valid to exclusive)
+//args => key columns, as of
+func spatialAsOfQuery(d *model.SpatialEntityDescriptor) string {
+	conditions := make([]string, 0, len(d.KeyColumns))
+	for i, c := range d.KeyColumns {
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", c, i+1))
+	}
+	asOfParam := len(d.KeyColumns) + 1
+
+	columns := append(append([]string{}, d.AttributeColumns...), "active", "lastmodifieddate", "COALESCE(ST_AsText(ogr_geometry), '')")
+
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE %s AND validfrom <= $%d AND (validto IS NULL OR validto > $%d) ORDER BY validfrom DESC LIMIT 1`,
+		strings.Join(columns, ", "), d.HistoryTable, strings.Join(conditions, " AND "), asOfParam, asOfParam)
+}
+
+func spatialAsOfArgs(d *model.SpatialEntityDescriptor, keys map[string]interface{}, asOf time.Time) []interface{} {
+	args := make([]interface{}, 0, len(d.KeyColumns)+1)
+	for _, c := range d.KeyColumns {
+		args = append(args, keys[c])
+	}
+	return append(args, asOf)
+}
+
+//boundingBoxReturning returning clause for the bounding box of the geometry
+func boundingBoxReturning(alias string) string {
+	return fmt.Sprintf(" RETURNING ST_XMin(%s.ogr_geometry), ST_YMin(%s.ogr_geometry), ST_XMax(%s.ogr_geometry), ST_YMax(%s.ogr_geometry)", alias, alias, alias, alias)
+}
+
+func deactivateSpatialArgs(d *model.SpatialEntityDescriptor, data *model.SpatialData, currentTime time.Time) []interface{} {
+	args := []interface{}{data.Active, data.LastModifiedDate, currentTime}
+	for _, c := range d.KeyColumns {
+		args = append(args, data.Keys[c])
+	}
+	return args
+}
+
+//PurgeSpatialTombstones deleting/archiving the deactivated spatial objects, older than the retention days of the entity lifecycle policy
+func PurgeSpatialTombstones(ctx context.Context, d *model.SpatialEntityDescriptor) (int64, error) {
+	var query string
+
+	switch d.Lifecycle.Mode {
+	case model.SpatialLifecycleDelete:
+		query = fmt.Sprintf(`DELETE FROM %s WHERE ogr_geometry IS NULL AND COALESCE(modifiedon, createdon) < $1`, d.TargetTable)
+	case model.SpatialLifecycleArchive:
+		//archive table => same columns as the entity table, followed by archivedon
+		query = fmt.Sprintf(`WITH moved AS (DELETE FROM %s WHERE ogr_geometry IS NULL AND COALESCE(modifiedon, createdon) < $1 RETURNING *)
+		INSERT INTO %s SELECT moved.*, $2 FROM moved`, d.TargetTable, d.Lifecycle.ArchiveTable)
+	default:
+		//tombstones are kept
+		return 0, nil
 	}
 
-	return recordAffected, nil
+	currentTime := time.Now().UTC()
+	purgeBefore := currentTime.AddDate(0, 0, -d.Lifecycle.RetentionDays)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var commandTag pgconn.CommandTag
+	var err error
+	if d.Lifecycle.Mode == model.SpatialLifecycleArchive {
+		commandTag, err = dbc.Exec(ctx, query, purgeBefore, currentTime)
+	} else {
+		commandTag, err = dbc.Exec(ctx, query, purgeBefore)
+	}
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("PurgeSpatialTombstones %v (%v) Error : %v", d.Name, d.Lifecycle.Mode, err.Error()))
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
+//sourceSRID returns the SRID of the incoming geometry, defaulted to WGS 84
+func sourceSRID(srid int) int {
+	if srid <= 0 {
+		return model.DefaultSRID
+	}
+	return srid
 }
 
 //Close conn ...
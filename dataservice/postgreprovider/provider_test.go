@@ -0,0 +1,132 @@
+package postgreprovider
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	model "data-sync-agent/model"
+)
+
+func testDescriptor() *model.SpatialEntityDescriptor {
+	return &model.SpatialEntityDescriptor{
+		Name:               "zone",
+		KeyColumns:         []string{"tenantuid", "zoneuid"},
+		AttributeColumns:   []string{"name"},
+		TargetTable:        "tblzone",
+		ConflictConstraint: "pk_zone",
+		HistoryTable:       "tblzonehistory",
+	}
+}
+
+var paramPattern = regexp.MustCompile(`\$(\d+)`)
+
+//maxParam highest placeholder of the query
+func maxParam(query string) int {
+	max := 0
+	for _, m := range paramPattern.FindAllStringSubmatch(query, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func assertContains(t *testing.T, name, query string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(query, part) {
+			t.Errorf("%v query misses %q\n%v", name, part, query)
+		}
+	}
+}
+
+func TestSpatialQueriesMatchTheirArgs(t *testing.T) {
+	d := testDescriptor()
+	data := &model.SpatialData{
+		Keys:             map[string]interface{}{"tenantuid": "t1", "zoneuid": "z1"},
+		Attributes:       map[string]interface{}{"name": "zone 1"},
+		Active:           1,
+		LastModifiedDate: time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC),
+		OgrGeometry:      "POINT(1 1)",
+	}
+	currentTime := time.Now().UTC()
+	asOf := time.Date(2020, 7, 14, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		query string
+		args  []interface{}
+	}{
+		"upsert":     {upsertSpatialQuery(d), upsertSpatialArgs(d, data, currentTime)},
+		"deactivate": {deactivateSpatialQuery(d), deactivateSpatialArgs(d, data, currentTime)},
+		"history":    {historySpatialQuery(d), historySpatialArgs(d, data, currentTime)},
+		"as of":      {spatialAsOfQuery(d), spatialAsOfArgs(d, data.Keys, asOf)},
+	}
+	for name, c := range cases {
+		if got := maxParam(c.query); got != len(c.args) {
+			t.Errorf("%v query params = %v, args = %v", name, got, len(c.args))
+		}
+	}
+}
+
+func TestSpatialChangesRejectOutOfOrderWrites(t *testing.T) {
+	d := testDescriptor()
+
+	assertContains(t, "upsert", upsertSpatialQuery(d),
+		"DO UPDATE SET name = excluded.name",
+		"WHERE tblzone.lastmodifieddate IS NULL OR tblzone.lastmodifieddate <= excluded.lastmodifieddate RETURNING")
+	assertContains(t, "deactivate", deactivateSpatialQuery(d),
+		"tblzone.zoneuid = $5",
+		"AND (tblzone.lastmodifieddate IS NULL OR tblzone.lastmodifieddate <= $2) RETURNING")
+}
+
+func TestHistorySpatialQuery(t *testing.T) {
+	query := historySpatialQuery(testDescriptor())
+
+	assertContains(t, "history", query,
+		//open version of the object
+		"FROM tblzonehistory WHERE tenantuid = $1 AND zoneuid = $2 AND validto IS NULL ORDER BY validfrom DESC LIMIT 1",
+		//current row, unless the open version is newer or has the same values/geometry
+		"FROM tblzone WHERE tblzone.tenantuid = $1 AND tblzone.zoneuid = $2 AND NOT EXISTS",
+		"latest.validfrom > $3",
+		"(latest.name, latest.active, latest.sourcesrid) IS NOT DISTINCT FROM (tblzone.name, tblzone.active, tblzone.sourcesrid)",
+		"ST_OrderingEquals(latest.ogr_geometry, tblzone.ogr_geometry)",
+		//open version closed only when a new one is inserted
+		"UPDATE tblzonehistory SET validto = $3 WHERE tenantuid = $1 AND zoneuid = $2 AND validto IS NULL AND validfrom <= $3 AND EXISTS (SELECT 1 FROM changed)",
+		"INSERT INTO tblzonehistory (tenantuid,zoneuid,name,active,lastmodifieddate,ogr_geometry,sourcesrid, validfrom) SELECT tenantuid, zoneuid, name, active, lastmodifieddate, ogr_geometry, sourcesrid, $3 FROM changed")
+}
+
+func TestHistorySpatialArgsValidFrom(t *testing.T) {
+	d := testDescriptor()
+	currentTime := time.Date(2020, 7, 14, 0, 0, 0, 0, time.UTC)
+	modifiedOn := time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC)
+	keys := map[string]interface{}{"tenantuid": "t1", "zoneuid": "z1"}
+
+	args := historySpatialArgs(d, &model.SpatialData{Keys: keys, LastModifiedDate: modifiedOn}, currentTime)
+	if want := []interface{}{"t1", "z1", modifiedOn}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	//without the source modification date, valid from the sync time
+	args = historySpatialArgs(d, &model.SpatialData{Keys: keys}, currentTime)
+	if want := []interface{}{"t1", "z1", currentTime}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestSpatialAsOfQuery(t *testing.T) {
+	d := testDescriptor()
+	asOf := time.Date(2020, 7, 14, 0, 0, 0, 0, time.UTC)
+
+	assertContains(t, "as of", spatialAsOfQuery(d),
+		"SELECT name, active, lastmodifieddate, COALESCE(ST_AsText(ogr_geometry), '') FROM tblzonehistory",
+		"WHERE tenantuid = $1 AND zoneuid = $2 AND validfrom <= $3 AND (validto IS NULL OR validto > $3) ORDER BY validfrom DESC LIMIT 1")
+
+	args := spatialAsOfArgs(d, map[string]interface{}{"zoneuid": "z1", "tenantuid": "t1"}, asOf)
+	if want := []interface{}{"t1", "z1", asOf}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
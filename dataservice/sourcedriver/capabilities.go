@@ -0,0 +1,33 @@
+package sourcedriver
+
+import (
+	"encoding/json"
+
+	model "data-sync-agent/model"
+)
+
+//capabilitiesFieldSuffix server hash field holding the feature flags of a server(<serverid>:capabilities)
+const capabilitiesFieldSuffix = ":capabilities"
+
+//CapabilitiesField hash field of the server feature flags, kept in plain json so operators can toggle them
+func CapabilitiesField(serverID string) string {
+	return serverID + capabilitiesFieldSuffix
+}
+
+//DefaultCapabilities flags of a server without a capabilities field
+func DefaultCapabilities() model.ServerCapabilities {
+	return model.DefaultServerCapabilities()
+}
+
+//ParseCapabilities flags not present on the value are left to the server defaults
+func ParseCapabilities(value string) (model.ServerCapabilities, error) {
+	capabilities := DefaultCapabilities()
+	if value == "" {
+		return capabilities, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &capabilities); err != nil {
+		return DefaultCapabilities(), err
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,290 @@
+package sourcedriver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"data-sync-agent/crypto"
+	"data-sync-agent/dataservice/pgsourceprovider"
+	"data-sync-agent/dataservice/sqldataprovider"
+	"data-sync-agent/helper"
+	model "data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+//ErrUnsupportedDialect dialect without a registered source driver
+var ErrUnsupportedDialect = errors.New("unsupported source dialect")
+
+//Driver reads the tenant data of an onboarded server, the dialect is chosen per server
+type Driver interface {
+	Dialect() string
+	Open(sqlCredentialProvider *model.SQLCredentialProvider, readOnly bool) (*sql.DB, error)
+	//ValidateSchema checks the procedures/functions of the server schema version exist
+	ValidateSchema(ctx context.Context, connData *model.SQLConnectionData) error
+	GetRegisteredDeviceData(ctx context.Context, connData *model.SQLConnectionData) *model.RegisteredDeviceRequestData
+	GetSpatialData(ctx context.Context, connData *model.SQLConnectionData) *model.SpatialRequestData
+	UpdateDataSyncFetchDate(connData *model.SQLConnectionData, canUpdateDeviceDate bool, deviceDate time.Time, canUpdateSpatialDate bool, spatialDate time.Time) (bool, error)
+	//an error means the lookup/update failed, not that the devices are not found
+	GetUnAuthDeviceDetails(connData *model.SQLConnectionData, deviceList []string) ([]model.UnAuthDeviceResponse, error)
+	UpdUnAuthDeviceDetails(connData *model.SQLConnectionData, applicationServerID int, deviceList []model.UnAuthDeviceResponse) (int64, error)
+}
+
+//drivers by dialect
+var drivers = struct {
+	sync.RWMutex
+	byDialect map[string]Driver
+}{
+	byDialect: map[string]Driver{
+		model.SourceDialectSQLServer: sqldataprovider.Driver{},
+		model.SourceDialectPostgres:  pgsourceprovider.Driver{},
+	},
+}
+
+//Register registering(replacing) the driver of its dialect
+func Register(driver Driver) {
+	drivers.Lock()
+	defer drivers.Unlock()
+	drivers.byDialect[driver.Dialect()] = driver
+}
+
+//ForDialect empty => sqlserver
+func ForDialect(dialect string) (Driver, error) {
+	dialect = strings.ToLower(strings.TrimSpace(dialect))
+	if dialect == "" {
+		dialect = model.SourceDialectSQLServer
+	}
+
+	drivers.RLock()
+	driver, ok := drivers.byDialect[dialect]
+	drivers.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: '%v'", ErrUnsupportedDialect, dialect)
+	}
+	return driver, nil
+}
+
+//For driver of the server(the dialect is validated on InitConnection)
+func For(connData *model.SQLConnectionData) Driver {
+	driver, err := ForDialect(connData.Dialect)
+	if err != nil {
+		return sqldataprovider.Driver{}
+	}
+	return driver
+}
+
+//readIntentLagMargin replica lag tolerated on the readable secondaries(default 60 sec)
+func readIntentLagMargin() time.Duration {
+	lagMarginInSec, err := strconv.ParseInt(helper.GetEnv(helper.ReadIntentLagMarginInSec), 10, 64)
+	if err != nil || lagMarginInSec < 0 {
+		lagMarginInSec = 60
+	}
+	return time.Duration(lagMarginInSec) * time.Second
+}
+
+//InitConnection ...
+func InitConnection(values map[string]string) []*model.SQLConnectionData {
+
+	sqlConnectionList := make([]*model.SQLConnectionData, 0)
+
+	readLagMargin := readIntentLagMargin()
+
+	for key, value := range values {
+		//deserializing data
+		sqlCredentialProvider := &model.SQLCredentialProvider{}
+		connStringHexData, err := crypto.Decrypt(value, crypto.W1EncType)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection Deserialization[connStringHexData] Error : %v", err.Error()))
+			continue
+		}
+		connStringData, err := hex.DecodeString(connStringHexData)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection Deserialization[connStringData] Error : %v", err.Error()))
+			continue
+		}
+
+		err = json.Unmarshal(connStringData, sqlCredentialProvider)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection Deserialization Error : %v", err.Error()))
+			continue
+		}
+
+		sqlCredentialProvider.ApplyDefaults()
+
+		driver, err := ForDialect(sqlCredentialProvider.Dialect)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection Server=%v Error : %v", key, err.Error()))
+			continue
+		}
+
+		db, err := driver.Open(sqlCredentialProvider, false)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection Open Server=%v Error : %v", key, err.Error()))
+			continue
+		}
+
+		//fetches are routed to a readable secondary
+		var readDB *sql.DB
+		var readDBLagMargin time.Duration
+		if sqlCredentialProvider.ReadIntent {
+			readDB, err = driver.Open(sqlCredentialProvider, true)
+			if err != nil {
+				logger.Log().Error(fmt.Sprintf("InitConnection Open(ReadOnly) Server=%v Error : %v", key, err.Error()))
+				db.Close()
+				continue
+			}
+			readDBLagMargin = readLagMargin
+		}
+
+		//spatial data without configured SRID is considered as WGS 84
+		srid := sqlCredentialProvider.SRID
+		if srid <= 0 {
+			srid = model.DefaultSRID
+		}
+
+		connData := &model.SQLConnectionData{
+			ServerID:          key,
+			Dialect:           driver.Dialect(),
+			SchemaVersion:     sqlCredentialProvider.SchemaVersion,
+			DB:                db,
+			ReadDB:            readDB,
+			ReadLagMargin:     readDBLagMargin,
+			SRID:              srid,
+			MainPageIDs:       mainPageIDs(sqlCredentialProvider),
+			ConnectionTimeout: time.Duration(sqlCredentialProvider.ConnectionTimeoutInSec) * time.Second,
+		}
+		//refreshed from the server hash on every cycle
+		connData.SetCapabilities(DefaultCapabilities())
+
+		if len(connData.MainPageIDs) == 0 {
+			logger.Log().Warn(fmt.Sprintf("InitConnection Server=%v without main page ids, device data is not synced", key))
+		}
+
+		//adding list value
+		sqlConnectionList = append(sqlConnectionList, connData)
+	}
+
+	//dead servers are kept as unhealthy(re-pinged on the next cycles), instead of failing the fetches
+	var wg sync.WaitGroup
+	for _, connData := range sqlConnectionList {
+		wg.Add(1)
+		go func(c *model.SQLConnectionData) {
+			defer wg.Done()
+			PingServer(context.Background(), c)
+		}(connData)
+	}
+	wg.Wait()
+
+	//servers on an unknown/incomplete schema are refused, the procedures of the unreachable ones are validated on the first successful ping
+	validConnectionList := make([]*model.SQLConnectionData, 0, len(sqlConnectionList))
+	for _, connData := range sqlConnectionList {
+		if err := validateSchemaVersion(connData); err != nil {
+			logger.Log().Error(fmt.Sprintf("InitConnection SchemaVersion Server=%v Error : %v", connData.ServerID, err.Error()))
+			closeConnection(connData)
+			continue
+		}
+
+		if connData.Healthy() {
+			if err := EnsureSchemaValidated(context.Background(), connData); err != nil {
+				logger.Log().Error(fmt.Sprintf("InitConnection ValidateSchema Server=%v Error : %v", connData.ServerID, err.Error()))
+				closeConnection(connData)
+				continue
+			}
+		} else {
+			logger.Log().Warn(fmt.Sprintf("InitConnection Server=%v unreachable, procedures not validated yet", connData.ServerID))
+		}
+		validConnectionList = append(validConnectionList, connData)
+	}
+
+	//return status
+	return validConnectionList
+}
+
+//mainPageIDs distinct main page ids of the server
+func mainPageIDs(sqlCredentialProvider *model.SQLCredentialProvider) []string {
+	ids := make([]string, 0, len(sqlCredentialProvider.MainPageIDs)+1)
+	for _, value := range append([]string{sqlCredentialProvider.MainPageID}, sqlCredentialProvider.MainPageIDs...) {
+		for _, id := range strings.Split(value, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" || containsString(ids, id) {
+				continue
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//validateSchemaVersion the query profile of the sql server schema version is registered(checked without connecting)
+func validateSchemaVersion(connData *model.SQLConnectionData) error {
+	if connData.Dialect != model.SourceDialectSQLServer {
+		return nil
+	}
+	_, err := sqldataprovider.QueryProfileFor(connData.SchemaVersion)
+	return err
+}
+
+//EnsureSchemaValidated validating the procedures of the server once, failed validations are retried on the next call
+func EnsureSchemaValidated(parentCtx context.Context, connData *model.SQLConnectionData) error {
+	if connData.SchemaValidated() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, connData.ConnectionTimeout)
+	defer cancel()
+	if err := For(connData).ValidateSchema(ctx, connData); err != nil {
+		return err
+	}
+
+	connData.SetSchemaValidated(true)
+	return nil
+}
+
+func closeConnection(connData *model.SQLConnectionData) {
+	connData.DB.Close()
+	if connData.ReadDB != nil {
+		connData.ReadDB.Close()
+	}
+}
+
+//PingServer pinging the server within its connection timeout, updates the health of the server
+func PingServer(parentCtx context.Context, connData *model.SQLConnectionData) bool {
+	ctx, cancel := context.WithTimeout(parentCtx, connData.ConnectionTimeout)
+	defer cancel()
+
+	err := connData.DB.PingContext(ctx)
+	if err == nil && connData.ReadDB != nil {
+		err = connData.ReadDB.PingContext(ctx)
+	}
+	healthy := err == nil
+
+	if healthy != connData.Healthy() {
+		if healthy {
+			logger.Log().Info(fmt.Sprintf("PingServer Server=%v is healthy", connData.ServerID))
+		} else {
+			logger.Log().Error(fmt.Sprintf("PingServer Server=%v is unhealthy Error : %v", connData.ServerID, err.Error()))
+		}
+	} else if !healthy {
+		logger.Log().Debug(fmt.Sprintf("PingServer Server=%v still unhealthy Error : %v", connData.ServerID, err.Error()))
+	}
+
+	connData.SetHealthy(healthy)
+	return healthy
+}
@@ -0,0 +1,317 @@
+package spatialentity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"data-sync-agent/config"
+	model "data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+var (
+	//ErrInvalidDescriptor indicates a descriptor with missing/invalid values
+	ErrInvalidDescriptor = errors.New("invalid spatial entity descriptor")
+
+	//identifiers are used to build the PostGIS queries, so only plain names are allowed
+	identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+	mu          sync.RWMutex
+	descriptors = make(map[string]*model.SpatialEntityDescriptor)
+)
+
+func init() {
+	//built-in spatial layers...
+	for _, d := range defaultDescriptors() {
+		if err := Register(d); err != nil {
+			logger.Log().Error(fmt.Sprintf("spatialentity default descriptor %v Error : %v", d.Name, err.Error()))
+		}
+	}
+}
+
+func defaultDescriptors() []*model.SpatialEntityDescriptor {
+	return []*model.SpatialEntityDescriptor{
+		{
+			Name:               "geofence",
+			ResultSetIndex:     0,
+			KeyColumns:         []string{"tenantuid", "tenantgroupuid", "geofenceuid"},
+			UIDColumn:          "geofenceuid",
+			AttributeColumns:   []string{"geofencenameen", "geofencenameol", "geofencetypeid", "isapproved"},
+			TargetTable:        "tblmstgeofence",
+			ConflictConstraint: "tblmstgeofence_pkey",
+		},
+		{
+			Name:               "area",
+			ResultSetIndex:     1,
+			KeyColumns:         []string{"tenantuid", "tenantgroupuid", "areauid"},
+			UIDColumn:          "areauid",
+			TargetTable:        "tblmstarea",
+			ConflictConstraint: "tblmstarea_pkey",
+		},
+		{
+			Name:               "zone",
+			ResultSetIndex:     2,
+			KeyColumns:         []string{"tenantuid", "tenantgroupuid", "zoneuid"},
+			UIDColumn:          "zoneuid",
+			TargetTable:        "tblmstzone",
+			ConflictConstraint: "tblmstzone_pkey",
+		},
+		{
+			Name:               "nogoarea",
+			ResultSetIndex:     3,
+			KeyColumns:         []string{"tenantuid", "tenantgroupuid", "nogoareageofenceuid"},
+			UIDColumn:          "nogoareageofenceuid",
+			TargetTable:        "tblmstvehiclenogoarea",
+			ConflictConstraint: "tblmstvehiclenogoarea_pkey",
+		},
+	}
+}
+
+//Register adds or replaces a spatial entity descriptor
+func Register(d *model.SpatialEntityDescriptor) error {
+	if err := normalize(d); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	//result-set index must be unique across the entities
+	for name, v := range descriptors {
+		if name != d.Name && v.ResultSetIndex == d.ResultSetIndex {
+			return fmt.Errorf("%w: result-set index %v already used by %v", ErrInvalidDescriptor, d.ResultSetIndex, name)
+		}
+	}
+
+	descriptors[d.Name] = d
+	return nil
+}
+
+//Get returns the descriptor registered with the name
+func Get(name string) (*model.SpatialEntityDescriptor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	d, ok := descriptors[name]
+	return d, ok
+}
+
+//All returns the registered descriptors ordered by result-set index
+func All() []*model.SpatialEntityDescriptor {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	resp := make([]*model.SpatialEntityDescriptor, 0, len(descriptors))
+	for _, d := range descriptors {
+		resp = append(resp, d)
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].ResultSetIndex < resp[j].ResultSetIndex
+	})
+
+	return resp
+}
+
+//LoadFromConfig registers the descriptors stored on the redis hash(name => descriptor json), over the built-in ones
+func LoadFromConfig(redisKey string) error {
+	values, err := config.HGetAll(redisKey)
+	if err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		d := &model.SpatialEntityDescriptor{}
+		if err := json.Unmarshal([]byte(v), d); err != nil {
+			logger.Log().Error(fmt.Sprintf("spatialentity LoadFromConfig %v Unmarshal Error : %v", name, err.Error()))
+			continue
+		}
+
+		if len(strings.TrimSpace(d.Name)) == 0 {
+			d.Name = name
+		}
+
+		if err := Register(d); err != nil {
+			logger.Log().Error(fmt.Sprintf("spatialentity LoadFromConfig %v Error : %v", name, err.Error()))
+			continue
+		}
+
+		logger.Log().Info(fmt.Sprintf("Spatial entity registered : %v => %v", d.Name, d.TargetTable))
+	}
+
+	return nil
+}
+
+//LoadLifecyclePolicies applies the lifecycle policies stored on the redis hash(entity name => policy json)
+func LoadLifecyclePolicies(redisKey string) error {
+	values, err := config.HGetAll(redisKey)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for name, v := range values {
+		d, ok := descriptors[name]
+		if !ok {
+			logger.Log().Warn(fmt.Sprintf("spatialentity LoadLifecyclePolicies %v is not registered", name))
+			continue
+		}
+
+		policy := model.SpatialLifecyclePolicy{}
+		if err := json.Unmarshal([]byte(v), &policy); err != nil {
+			logger.Log().Error(fmt.Sprintf("spatialentity LoadLifecyclePolicies %v Unmarshal Error : %v", name, err.Error()))
+			continue
+		}
+
+		if err := normalizeLifecycle(&policy); err != nil {
+			logger.Log().Error(fmt.Sprintf("spatialentity LoadLifecyclePolicies %v Error : %v", name, err.Error()))
+			continue
+		}
+
+		//descriptors are shared, so replacing with the updated copy
+		updated := *d
+		updated.Lifecycle = policy
+		descriptors[name] = &updated
+
+		logger.Log().Info(fmt.Sprintf("Spatial lifecycle policy : %v => %v (%v days)", name, policy.Mode, policy.RetentionDays))
+	}
+
+	return nil
+}
+
+//LoadHistoryTables enables the versioned mode for the entities stored on the redis hash(entity name => history table)
+func LoadHistoryTables(redisKey string) error {
+	values, err := config.HGetAll(redisKey)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for name, v := range values {
+		d, ok := descriptors[name]
+		if !ok {
+			logger.Log().Warn(fmt.Sprintf("spatialentity LoadHistoryTables %v is not registered", name))
+			continue
+		}
+
+		historyTable := strings.ToLower(strings.TrimSpace(v))
+		if historyTable != "" && !identifierPattern.MatchString(historyTable) {
+			logger.Log().Error(fmt.Sprintf("spatialentity LoadHistoryTables %v invalid history table '%v'", name, v))
+			continue
+		}
+
+		//descriptors are shared, so replacing with the updated copy
+		updated := *d
+		updated.HistoryTable = historyTable
+		descriptors[name] = &updated
+
+		logger.Log().Info(fmt.Sprintf("Spatial history table : %v => %v", name, historyTable))
+	}
+
+	return nil
+}
+
+//UID returns the unique id of the spatial object
+func UID(d *model.SpatialEntityDescriptor, data *model.SpatialData) string {
+	return fmt.Sprintf("%v", data.Keys[d.UIDColumn])
+}
+
+//TenantUID returns the tenant uid of the spatial object
+func TenantUID(d *model.SpatialEntityDescriptor, data *model.SpatialData) string {
+	if v, ok := data.Keys[d.TenantColumn]; ok {
+		return fmt.Sprintf("%v", v)
+	}
+	if v, ok := data.Attributes[d.TenantColumn]; ok {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+//normalize lower-cases the column names and validates the descriptor
+func normalize(d *model.SpatialEntityDescriptor) error {
+	if d == nil {
+		return ErrInvalidDescriptor
+	}
+
+	d.Name = strings.TrimSpace(d.Name)
+	d.TargetTable = strings.ToLower(strings.TrimSpace(d.TargetTable))
+	d.ConflictConstraint = strings.ToLower(strings.TrimSpace(d.ConflictConstraint))
+	d.UIDColumn = strings.ToLower(strings.TrimSpace(d.UIDColumn))
+	d.TenantColumn = strings.ToLower(strings.TrimSpace(d.TenantColumn))
+	d.KeyColumns = normalizeColumns(d.KeyColumns)
+	d.AttributeColumns = normalizeColumns(d.AttributeColumns)
+
+	if d.Name == "" || len(d.KeyColumns) == 0 || d.ResultSetIndex < 0 {
+		return ErrInvalidDescriptor
+	}
+
+	//by default last key column is the object uid
+	if d.UIDColumn == "" {
+		d.UIDColumn = d.KeyColumns[len(d.KeyColumns)-1]
+	}
+
+	if d.TenantColumn == "" {
+		d.TenantColumn = "tenantuid"
+	}
+
+	d.HistoryTable = strings.ToLower(strings.TrimSpace(d.HistoryTable))
+	if d.HistoryTable != "" && !identifierPattern.MatchString(d.HistoryTable) {
+		return fmt.Errorf("%w: %v => history table '%v'", ErrInvalidDescriptor, d.Name, d.HistoryTable)
+	}
+
+	if err := normalizeLifecycle(&d.Lifecycle); err != nil {
+		return fmt.Errorf("%w: %v => %v", ErrInvalidDescriptor, d.Name, err.Error())
+	}
+
+	identifiers := append([]string{d.TargetTable, d.ConflictConstraint, d.UIDColumn, d.TenantColumn}, d.KeyColumns...)
+	identifiers = append(identifiers, d.AttributeColumns...)
+	for _, v := range identifiers {
+		if !identifierPattern.MatchString(v) {
+			return fmt.Errorf("%w: %v => '%v'", ErrInvalidDescriptor, d.Name, v)
+		}
+	}
+
+	return nil
+}
+
+//normalizeLifecycle defaults to keep tombstones and validates the policy
+func normalizeLifecycle(p *model.SpatialLifecyclePolicy) error {
+	p.Mode = model.SpatialLifecycleMode(strings.ToLower(strings.TrimSpace(string(p.Mode))))
+	p.ArchiveTable = strings.ToLower(strings.TrimSpace(p.ArchiveTable))
+
+	switch p.Mode {
+	case "", model.SpatialLifecycleKeep:
+		p.Mode = model.SpatialLifecycleKeep
+		return nil
+	case model.SpatialLifecycleDelete, model.SpatialLifecycleArchive:
+	default:
+		return fmt.Errorf("unknown lifecycle mode '%v'", p.Mode)
+	}
+
+	if p.RetentionDays <= 0 {
+		return fmt.Errorf("lifecycle %v requires retention days", p.Mode)
+	}
+
+	if p.Mode == model.SpatialLifecycleArchive && !identifierPattern.MatchString(p.ArchiveTable) {
+		return fmt.Errorf("lifecycle archive table '%v' is invalid", p.ArchiveTable)
+	}
+
+	return nil
+}
+
+func normalizeColumns(columns []string) []string {
+	resp := make([]string, 0, len(columns))
+	for _, c := range columns {
+		resp = append(resp, strings.ToLower(strings.TrimSpace(c)))
+	}
+	return resp
+}
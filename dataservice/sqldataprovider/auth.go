@@ -0,0 +1,146 @@
+package sqldataprovider
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	model "data-sync-agent/model"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/azuread"
+	//registers the krb5 integrated authenticator
+	_ "github.com/microsoft/go-mssqldb/integratedauth/krb5"
+)
+
+//ErrUnsupportedAuthMode auth mode without a registered connector factory
+var ErrUnsupportedAuthMode = errors.New("unsupported sql server auth mode")
+
+//ConnectorFactory creates the driver connector of a server for an auth mode(replaced with fakes on tests)
+type ConnectorFactory interface {
+	NewConnector(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error)
+}
+
+//ConnectorFactoryFunc ...
+type ConnectorFactoryFunc func(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error)
+
+//NewConnector ...
+func (f ConnectorFactoryFunc) NewConnector(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+	return f(sqlCredentialProvider, dsn)
+}
+
+//ErrIncompleteAuthSettings settings required by the auth mode are not configured
+var ErrIncompleteAuthSettings = errors.New("incomplete sql server auth settings")
+
+//loginConnectorFactory sql login, also windows(ntlm) login when the user name is DOMAIN\user
+var loginConnectorFactory = ConnectorFactoryFunc(func(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+	return mssql.NewConnector(dsn)
+})
+
+//kerberosConnectorFactory keytab login of the user name(principal), the SPN is resolved by the driver when not configured
+var kerberosConnectorFactory = ConnectorFactoryFunc(func(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+	if sqlCredentialProvider.UserName == "" || sqlCredentialProvider.KeytabFile == "" {
+		return nil, fmt.Errorf("%w: kerberos requires username and keytabfile", ErrIncompleteAuthSettings)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	//keytab takes precedence over the password
+	u.User = url.User(sqlCredentialProvider.UserName)
+
+	query := u.Query()
+	query.Set("authenticator", "krb5")
+	query.Set("krb5-keytabfile", sqlCredentialProvider.KeytabFile)
+	if sqlCredentialProvider.Realm != "" {
+		query.Set("krb5-realm", sqlCredentialProvider.Realm)
+	}
+	if sqlCredentialProvider.Krb5ConfigFile != "" {
+		query.Set("krb5-configfile", sqlCredentialProvider.Krb5ConfigFile)
+	}
+	if sqlCredentialProvider.SPN != "" {
+		query.Set("ServerSPN", sqlCredentialProvider.SPN)
+	}
+	u.RawQuery = query.Encode()
+
+	return mssql.NewConnector(u.String())
+})
+
+//azureADConnectorFactory pre-issued access token, otherwise the service principal(client secret) of the tenant
+var azureADConnectorFactory = ConnectorFactoryFunc(func(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if accessToken := sqlCredentialProvider.AccessToken; accessToken != "" {
+		u.User = nil
+		return mssql.NewAccessTokenConnector(u.String(), func() (string, error) {
+			return accessToken, nil
+		})
+	}
+
+	if sqlCredentialProvider.ClientID == "" || sqlCredentialProvider.ClientSecret == "" {
+		return nil, fmt.Errorf("%w: azuread requires accesstoken or clientid and clientsecret", ErrIncompleteAuthSettings)
+	}
+
+	//client id@tenant id, the tenant of the server is used when not configured
+	clientID := sqlCredentialProvider.ClientID
+	if sqlCredentialProvider.TenantID != "" {
+		clientID = clientID + "@" + sqlCredentialProvider.TenantID
+	}
+	u.User = url.UserPassword(clientID, sqlCredentialProvider.ClientSecret)
+
+	query := u.Query()
+	query.Set("fedauth", azuread.ActiveDirectoryServicePrincipal)
+	u.RawQuery = query.Encode()
+
+	return azuread.NewConnector(u.String())
+})
+
+//connector factories by auth mode
+var connectorFactories = struct {
+	sync.RWMutex
+	factories map[string]ConnectorFactory
+}{
+	factories: map[string]ConnectorFactory{
+		model.SQLAuthModeSQLLogin: loginConnectorFactory,
+		model.SQLAuthModeNTLM:     loginConnectorFactory,
+		model.SQLAuthModeKerberos: kerberosConnectorFactory,
+		model.SQLAuthModeAzureAD:  azureADConnectorFactory,
+	},
+}
+
+//RegisterConnectorFactory registering(replacing) the connector factory of an auth mode
+func RegisterConnectorFactory(authMode string, factory ConnectorFactory) {
+	connectorFactories.Lock()
+	defer connectorFactories.Unlock()
+	connectorFactories.factories[normalizeAuthMode(authMode)] = factory
+}
+
+//newConnector creating the connector of the server auth mode
+func newConnector(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+	authMode := normalizeAuthMode(sqlCredentialProvider.AuthMode)
+
+	connectorFactories.RLock()
+	factory, ok := connectorFactories.factories[authMode]
+	connectorFactories.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: '%v'", ErrUnsupportedAuthMode, authMode)
+	}
+	return factory.NewConnector(sqlCredentialProvider, dsn)
+}
+
+//normalizeAuthMode empty => sql login
+func normalizeAuthMode(authMode string) string {
+	authMode = strings.ToLower(strings.TrimSpace(authMode))
+	if authMode == "" {
+		return model.SQLAuthModeSQLLogin
+	}
+	return authMode
+}
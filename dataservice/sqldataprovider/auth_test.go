@@ -0,0 +1,91 @@
+package sqldataprovider
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	model "data-sync-agent/model"
+)
+
+//fakeConnector connector recording the auth mode of its factory
+type fakeConnector struct {
+	driver.Connector
+	authMode string
+}
+
+//registerFakeFactories replacing the factories of all the auth modes, restored when the test ends
+func registerFakeFactories(t *testing.T) {
+	t.Helper()
+
+	connectorFactories.RLock()
+	saved := make(map[string]ConnectorFactory, len(connectorFactories.factories))
+	for authMode, factory := range connectorFactories.factories {
+		saved[authMode] = factory
+	}
+	connectorFactories.RUnlock()
+
+	t.Cleanup(func() {
+		connectorFactories.Lock()
+		connectorFactories.factories = saved
+		connectorFactories.Unlock()
+	})
+
+	for authMode := range saved {
+		authMode := authMode
+		RegisterConnectorFactory(authMode, ConnectorFactoryFunc(func(sqlCredentialProvider *model.SQLCredentialProvider, dsn string) (driver.Connector, error) {
+			return &fakeConnector{authMode: authMode}, nil
+		}))
+	}
+}
+
+func TestNewConnectorSelectsFactoryOfAuthMode(t *testing.T) {
+	registerFakeFactories(t)
+
+	cases := map[string]string{
+		"":          model.SQLAuthModeSQLLogin,
+		"  ":        model.SQLAuthModeSQLLogin,
+		"sqllogin":  model.SQLAuthModeSQLLogin,
+		"NTLM":      model.SQLAuthModeNTLM,
+		"kerberos":  model.SQLAuthModeKerberos,
+		" AzureAD ": model.SQLAuthModeAzureAD,
+	}
+	for authMode, want := range cases {
+		connector, err := newConnector(&model.SQLCredentialProvider{AuthMode: authMode}, "sqlserver://host")
+		if err != nil {
+			t.Errorf("auth mode '%v' error : %v", authMode, err)
+			continue
+		}
+		if got := connector.(*fakeConnector).authMode; got != want {
+			t.Errorf("auth mode '%v' connector = %v, want %v", authMode, got, want)
+		}
+	}
+}
+
+func TestNewConnectorUnknownAuthMode(t *testing.T) {
+	registerFakeFactories(t)
+
+	_, err := newConnector(&model.SQLCredentialProvider{AuthMode: "ldap"}, "sqlserver://host")
+	if !errors.Is(err, ErrUnsupportedAuthMode) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupportedAuthMode)
+	}
+}
+
+func TestNewConnectorIncompleteAuthSettings(t *testing.T) {
+	cases := map[string]*model.SQLCredentialProvider{
+		"kerberos without keytab": {AuthMode: model.SQLAuthModeKerberos, UserName: "svc-sync"},
+		"azuread without secret":  {AuthMode: model.SQLAuthModeAzureAD, TenantID: "tenant", ClientID: "client"},
+	}
+	for name, sqlCredentialProvider := range cases {
+		if _, err := newConnector(sqlCredentialProvider, "sqlserver://host"); !errors.Is(err, ErrIncompleteAuthSettings) {
+			t.Errorf("%v error = %v, want %v", name, err, ErrIncompleteAuthSettings)
+		}
+	}
+}
+
+func TestNewConnectorAzureADAccessToken(t *testing.T) {
+	sqlCredentialProvider := &model.SQLCredentialProvider{AuthMode: model.SQLAuthModeAzureAD, AccessToken: "token"}
+	if _, err := newConnector(sqlCredentialProvider, sqlServerURL(sqlCredentialProvider, false)); err != nil {
+		t.Errorf("access token connector error : %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package sqldataprovider
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	model "data-sync-agent/model"
+)
+
+//Driver SQL Server source, the data is read through the GetIOT* procedures
+type Driver struct{}
+
+//Dialect ...
+func (Driver) Dialect() string {
+	return model.SourceDialectSQLServer
+}
+
+//Open ...
+func (Driver) Open(sqlCredentialProvider *model.SQLCredentialProvider, readOnly bool) (*sql.DB, error) {
+	//unknown schema versions are refused, even when the server can't be validated yet
+	if _, err := QueryProfileFor(sqlCredentialProvider.SchemaVersion); err != nil {
+		return nil, err
+	}
+
+	return OpenConnection(sqlCredentialProvider, readOnly)
+}
+
+//ValidateSchema ...
+func (Driver) ValidateSchema(ctx context.Context, connData *model.SQLConnectionData) error {
+	return ValidateSchema(ctx, connData)
+}
+
+//GetRegisteredDeviceData ...
+func (Driver) GetRegisteredDeviceData(ctx context.Context, connData *model.SQLConnectionData) *model.RegisteredDeviceRequestData {
+	return GetRegisteredDeviceData(ctx, connData)
+}
+
+//GetSpatialData ...
+func (Driver) GetSpatialData(ctx context.Context, connData *model.SQLConnectionData) *model.SpatialRequestData {
+	return GetSpatialData(ctx, connData)
+}
+
+//UpdateDataSyncFetchDate ...
+func (Driver) UpdateDataSyncFetchDate(connData *model.SQLConnectionData, canUpdateDeviceDate bool, deviceDate time.Time, canUpdateSpatialDate bool, spatialDate time.Time) (bool, error) {
+	return UpdateDataSyncFetchDate(connData, canUpdateDeviceDate, deviceDate, canUpdateSpatialDate, spatialDate)
+}
+
+//GetUnAuthDeviceDetails ...
+func (Driver) GetUnAuthDeviceDetails(connData *model.SQLConnectionData, deviceList []string) ([]model.UnAuthDeviceResponse, error) {
+	return GetUnAuthDeviceDetails(connData, deviceList)
+}
+
+//UpdUnAuthDeviceDetails ...
+func (Driver) UpdUnAuthDeviceDetails(connData *model.SQLConnectionData, applicationServerID int, deviceList []model.UnAuthDeviceResponse) (int64, error) {
+	return UpdUnAuthDeviceDetails(connData, applicationServerID, deviceList)
+}
@@ -0,0 +1,183 @@
+package sqldataprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"data-sync-agent/config"
+	model "data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+var (
+	//ErrInvalidQueryProfile indicates a profile with invalid procedure/parameter names
+	ErrInvalidQueryProfile = errors.New("invalid query profile")
+	//ErrUnknownSchemaVersion schema version without a registered query profile
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+
+	//procedure names are executed as RPC, so only(optionally schema qualified) plain names are allowed
+	procedurePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	parameterPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	profileMu     sync.RWMutex
+	queryProfiles = map[string]*model.QueryProfile{"": defaultQueryProfile()}
+)
+
+//defaultQueryProfile current schema version
+func defaultQueryProfile() *model.QueryProfile {
+	return &model.QueryProfile{
+		RegisteredDeviceProcedure: "GetIOTRegisteredDeviceData",
+		MainPageIDParam:           "ApplicationMainPageID",
+		DataFetchedOnParam:        "DataFetchedOn",
+
+		SpatialProcedure:  "GetIOTSpatialData",
+		SpatialResultSets: make(map[string]int),
+
+		UpdateFetchDateProcedure:  "UpdIOTDataSyncFetchDate",
+		CanUpdateDeviceDateParam:  "CanUpdateDeviceDate",
+		DeviceDateParam:           "DeviceDate",
+		CanUpdateSpatialDateParam: "CanUpdateSpatialDate",
+		SpatialDateParam:          "SpatialDate",
+		StatusParam:               "Status",
+
+		UnAuthDeviceProcedure:    "GetIOTUnAuthDeviceDetails",
+		UnAuthDeviceListParam:    "DeviceList",
+		UpdUnAuthDeviceProcedure: "UpdIOTUnAuthDeviceDetails",
+		UpdUnAuthServerIDParam:   "Inserted_ApplicationServerID",
+		UpdUnAuthDeviceListParam: "DeviceList",
+	}
+}
+
+//RegisterQueryProfile registering(replacing) the profile of its schema version, empty names are taken from the default profile
+func RegisterQueryProfile(p *model.QueryProfile) error {
+	p.SchemaVersion = strings.TrimSpace(p.SchemaVersion)
+	if p.SchemaVersion == "" {
+		return fmt.Errorf("%w: schema version is required", ErrInvalidQueryProfile)
+	}
+
+	d := defaultQueryProfile()
+	procedures := []*string{&p.RegisteredDeviceProcedure, &p.SpatialProcedure, &p.UpdateFetchDateProcedure, &p.UnAuthDeviceProcedure, &p.UpdUnAuthDeviceProcedure}
+	defaultProcedures := []string{d.RegisteredDeviceProcedure, d.SpatialProcedure, d.UpdateFetchDateProcedure, d.UnAuthDeviceProcedure, d.UpdUnAuthDeviceProcedure}
+	for i, v := range procedures {
+		if err := mergeName(v, defaultProcedures[i], procedurePattern); err != nil {
+			return err
+		}
+	}
+
+	params := []*string{&p.MainPageIDParam, &p.DataFetchedOnParam, &p.CanUpdateDeviceDateParam, &p.DeviceDateParam, &p.CanUpdateSpatialDateParam,
+		&p.SpatialDateParam, &p.StatusParam, &p.UnAuthDeviceListParam, &p.UpdUnAuthServerIDParam, &p.UpdUnAuthDeviceListParam}
+	defaultParams := []string{d.MainPageIDParam, d.DataFetchedOnParam, d.CanUpdateDeviceDateParam, d.DeviceDateParam, d.CanUpdateSpatialDateParam,
+		d.SpatialDateParam, d.StatusParam, d.UnAuthDeviceListParam, d.UpdUnAuthServerIDParam, d.UpdUnAuthDeviceListParam}
+	for i, v := range params {
+		if err := mergeName(v, defaultParams[i], parameterPattern); err != nil {
+			return err
+		}
+	}
+
+	if p.SpatialResultSets == nil {
+		p.SpatialResultSets = make(map[string]int)
+	}
+	for name, index := range p.SpatialResultSets {
+		if index < 0 {
+			return fmt.Errorf("%w: result-set index %v of %v", ErrInvalidQueryProfile, index, name)
+		}
+	}
+
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	queryProfiles[p.SchemaVersion] = p
+
+	return nil
+}
+
+func mergeName(v *string, defaultValue string, pattern *regexp.Regexp) error {
+	*v = strings.TrimSpace(*v)
+	if *v == "" {
+		*v = defaultValue
+		return nil
+	}
+	if !pattern.MatchString(*v) {
+		return fmt.Errorf("%w: '%v'", ErrInvalidQueryProfile, *v)
+	}
+	return nil
+}
+
+//LoadQueryProfiles registers the profiles stored on the redis hash(schema version => profile json)
+func LoadQueryProfiles(redisKey string) error {
+	values, err := config.HGetAll(redisKey)
+	if err != nil {
+		return err
+	}
+
+	for version, v := range values {
+		p := &model.QueryProfile{}
+		if err := json.Unmarshal([]byte(v), p); err != nil {
+			logger.Log().Error(fmt.Sprintf("LoadQueryProfiles %v Unmarshal Error : %v", version, err.Error()))
+			continue
+		}
+
+		if len(strings.TrimSpace(p.SchemaVersion)) == 0 {
+			p.SchemaVersion = version
+		}
+
+		if err := RegisterQueryProfile(p); err != nil {
+			logger.Log().Error(fmt.Sprintf("LoadQueryProfiles %v Error : %v", version, err.Error()))
+			continue
+		}
+
+		logger.Log().Info(fmt.Sprintf("Query profile registered : %v", p.SchemaVersion))
+	}
+
+	return nil
+}
+
+//QueryProfileFor profile of the schema version(empty => default)
+func QueryProfileFor(schemaVersion string) (*model.QueryProfile, error) {
+	profileMu.RLock()
+	defer profileMu.RUnlock()
+
+	p, ok := queryProfiles[strings.TrimSpace(schemaVersion)]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%v'", ErrUnknownSchemaVersion, schemaVersion)
+	}
+	return p, nil
+}
+
+//profileOf profile of the server, unknown versions are refused at startup(InitConnection)
+func profileOf(connData *model.SQLConnectionData) *model.QueryProfile {
+	p, err := QueryProfileFor(connData.SchemaVersion)
+	if err != nil {
+		return defaultQueryProfile()
+	}
+	return p
+}
+
+//ValidateSchema checking the procedures of the server profile exist
+func ValidateSchema(ctx context.Context, connData *model.SQLConnectionData) error {
+	p, err := QueryProfileFor(connData.SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	for _, procedure := range []string{p.RegisteredDeviceProcedure, p.SpatialProcedure, p.UpdateFetchDateProcedure, p.UnAuthDeviceProcedure, p.UpdUnAuthDeviceProcedure} {
+		exists := 0
+		err := connData.DB.QueryRowContext(ctx, "SELECT CASE WHEN OBJECT_ID(@p1, 'P') IS NULL THEN 0 ELSE 1 END", procedure).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			missing = append(missing, procedure)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("schema version '%v', missing procedures : %v", p.SchemaVersion, missing)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sqldataprovider
+
+import (
+	"testing"
+
+	model "data-sync-agent/model"
+
+	"github.com/microsoft/go-mssqldb/msdsn"
+)
+
+//parseURL connection settings of the url, as seen by the driver
+func parseURL(t *testing.T, sqlCredentialProvider *model.SQLCredentialProvider, readOnly bool) msdsn.Config {
+	t.Helper()
+	dsn := sqlServerURL(sqlCredentialProvider, readOnly)
+	config, err := msdsn.Parse(dsn)
+	if err != nil {
+		t.Fatalf("Parse(%v) error : %v", dsn, err)
+	}
+	return config
+}
+
+func TestSQLServerURL(t *testing.T) {
+	config := parseURL(t, &model.SQLCredentialProvider{
+		HostName:               "sql-1.local",
+		UserName:               "sync",
+		Password:               "p@ss/word",
+		DBName:                 "iot",
+		AppName:                "data-sync-agent",
+		ConnectionTimeoutInSec: 30,
+	}, false)
+
+	if config.Host != "sql-1.local" || config.Instance != "" || config.Port != 0 {
+		t.Errorf("host = %v instance = %v port = %v, want sql-1.local without instance/port", config.Host, config.Instance, config.Port)
+	}
+	if config.User != "sync" || config.Password != "p@ss/word" || config.Database != "iot" || config.AppName != "data-sync-agent" {
+		t.Errorf("user = %v password = %v database = %v app = %v", config.User, config.Password, config.Database, config.AppName)
+	}
+	if config.ReadOnlyIntent || config.MultiSubnetFailover || config.FailOverPartner != "" {
+		t.Errorf("read intent = %v multi subnet = %v partner = %v, want all off", config.ReadOnlyIntent, config.MultiSubnetFailover, config.FailOverPartner)
+	}
+}
+
+func TestSQLServerURLInstanceAndPort(t *testing.T) {
+	config := parseURL(t, &model.SQLCredentialProvider{HostName: "sql-1.local", InstanceName: "IOT"}, false)
+	if config.Host != "sql-1.local" || config.Instance != "IOT" || config.Port != 0 {
+		t.Errorf("host = %v instance = %v port = %v, want sql-1.local IOT(browser resolved port)", config.Host, config.Instance, config.Port)
+	}
+
+	config = parseURL(t, &model.SQLCredentialProvider{HostName: "sql-1.local", InstanceName: "IOT", Port: 14330}, false)
+	if config.Host != "sql-1.local" || config.Instance != "IOT" || config.Port != 14330 {
+		t.Errorf("host = %v instance = %v port = %v, want sql-1.local IOT 14330", config.Host, config.Instance, config.Port)
+	}
+}
+
+func TestSQLServerURLFailoverPartner(t *testing.T) {
+	config := parseURL(t, &model.SQLCredentialProvider{HostName: "sql-1.local", FailoverPartner: "sql-2.local", FailoverPort: 14331}, false)
+	if config.FailOverPartner != "sql-2.local" || config.FailOverPort != 14331 {
+		t.Errorf("partner = %v port = %v, want sql-2.local 14331", config.FailOverPartner, config.FailOverPort)
+	}
+
+	//partner port without a partner is ignored
+	config = parseURL(t, &model.SQLCredentialProvider{HostName: "sql-1.local", FailoverPort: 14331}, false)
+	if config.FailOverPartner != "" || config.FailOverPort != 0 {
+		t.Errorf("partner = %v port = %v, want none", config.FailOverPartner, config.FailOverPort)
+	}
+}
+
+func TestSQLServerURLAvailabilityGroup(t *testing.T) {
+	sqlCredentialProvider := &model.SQLCredentialProvider{HostName: "ag-listener.local", DBName: "iot", MultiSubnetFailover: true, ReadIntent: true}
+
+	//fetches go to a readable secondary(database required by the read intent), the fetch date updates to the primary
+	for readOnly, wantReadIntent := range map[bool]bool{true: true, false: false} {
+		config := parseURL(t, sqlCredentialProvider, readOnly)
+		if config.ReadOnlyIntent != wantReadIntent {
+			t.Errorf("read only %v: read intent = %v, want %v", readOnly, config.ReadOnlyIntent, wantReadIntent)
+		}
+		if !config.MultiSubnetFailover {
+			t.Errorf("read only %v: multi subnet failover = false, want true", readOnly)
+		}
+	}
+}
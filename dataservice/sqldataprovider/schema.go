@@ -0,0 +1,214 @@
+package sqldataprovider
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"data-sync-agent/alert"
+	model "data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+//ErrSchemaDrift the result-set doesn't match the expected columns/types
+var ErrSchemaDrift = errors.New("schema drift")
+
+//AlertSchemaDrift alert kind
+const AlertSchemaDrift = "schemadrift"
+
+//ColumnKind portable type of a result column(database type names differ by dialect)
+type ColumnKind string
+
+//column kinds
+const (
+	ColumnString  ColumnKind = "string"
+	ColumnInt     ColumnKind = "int"
+	ColumnFloat   ColumnKind = "float"
+	ColumnBool    ColumnKind = "bool"
+	ColumnTime    ColumnKind = "time"
+	ColumnBinary  ColumnKind = "binary"
+	ColumnUnknown ColumnKind = "unknown"
+)
+
+//ExpectedColumn column of a result-set, any kind is accepted when Kinds is empty(optional columns are only type checked)
+type ExpectedColumn struct {
+	Name     string
+	Kinds    []ColumnKind
+	Optional bool
+}
+
+//ResultSchema expected columns of a source query
+type ResultSchema struct {
+	Name    string
+	Columns []ExpectedColumn
+}
+
+var (
+	stringKinds = []ColumnKind{ColumnString, ColumnBinary}
+	intKinds    = []ColumnKind{ColumnInt}
+
+	//registeredDeviceSchema columns mapped into model.RegisteredDeviceData
+	registeredDeviceSchema = &ResultSchema{
+		Name: "registereddevice",
+		Columns: []ExpectedColumn{
+			{"deviceid", stringKinds, false}, {"tenantgroupuid", stringKinds, false}, {"tenantuid", stringKinds, false},
+			{"communicationgroupid", intKinds, false}, {"active", intKinds, false},
+			{"vehicleid", stringKinds, true}, {"batchprocessgroupid", intKinds, true}, {"providertenantuids", stringKinds, true},
+			{"parserid", intKinds, true}, {"devicemasterstatusuno", intKinds, true}, {"devicetypeid", intKinds, true},
+			{"tenantname", stringKinds, true}, {"diversiondetails", []ColumnKind{ColumnString}, true},
+			{"lastmodifieddate", []ColumnKind{ColumnTime}, true},
+		},
+	}
+
+	//unAuthDeviceSchema columns mapped into model.UnAuthDeviceResponse
+	unAuthDeviceSchema = &ResultSchema{
+		Name: "unauthdevice",
+		Columns: []ExpectedColumn{
+			{"deviceid", stringKinds, false}, {"applicationserverid", intKinds, false}, {"active", intKinds, false},
+			{"devicecount", intKinds, false}, {"trnstatus", intKinds, true}, {"devicetypename", stringKinds, true},
+			{"companyname", stringKinds, true}, {"vehicleid", stringKinds, true}, {"isdiverted", intKinds, true},
+		},
+	}
+
+	//extra columns already reported(schema|server|columns)
+	reportedExtraColumns sync.Map
+)
+
+//spatialSchema columns read for the spatial entity
+func spatialSchema(d *model.SpatialEntityDescriptor) *ResultSchema {
+	s := &ResultSchema{
+		Name: "spatial:" + d.Name,
+		Columns: []ExpectedColumn{
+			{"active", []ColumnKind{ColumnInt, ColumnBool}, false},
+			{"lastmodifieddate", []ColumnKind{ColumnTime, ColumnString}, false},
+			{"ogrgeometry", stringKinds, false},
+		},
+	}
+	for _, c := range d.KeyColumns {
+		s.Columns = append(s.Columns, ExpectedColumn{Name: c})
+	}
+	for _, c := range d.AttributeColumns {
+		s.Columns = append(s.Columns, ExpectedColumn{Name: c, Optional: true})
+	}
+	return s
+}
+
+//columnKind kind of the database type name(sql server and postgres names)
+func columnKind(databaseTypeName string) ColumnKind {
+	switch strings.ToUpper(databaseTypeName) {
+	case "CHAR", "VARCHAR", "NCHAR", "NVARCHAR", "TEXT", "NTEXT", "XML", "BPCHAR", "NAME", "UUID", "JSON", "JSONB", "CITEXT":
+		return ColumnString
+	case "TINYINT", "SMALLINT", "INT", "BIGINT", "INT2", "INT4", "INT8":
+		return ColumnInt
+	case "REAL", "FLOAT", "FLOAT4", "FLOAT8", "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		return ColumnFloat
+	case "BIT", "BOOL":
+		return ColumnBool
+	case "DATE", "DATETIME", "DATETIME2", "SMALLDATETIME", "DATETIMEOFFSET", "TIMESTAMP", "TIMESTAMPTZ":
+		return ColumnTime
+	case "BINARY", "VARBINARY", "IMAGE", "UNIQUEIDENTIFIER", "BYTEA":
+		return ColumnBinary
+	default:
+		return ColumnUnknown
+	}
+}
+
+//resultColumn name and database type of a result column(*sql.ColumnType, fakes on tests)
+type resultColumn interface {
+	Name() string
+	DatabaseTypeName() string
+}
+
+//checkColumns comparing the result columns with the expected ones, returns the lower case column names and kinds.
+//missing/changed columns are refused with an alert, extra columns are logged once.
+func checkColumns(rows *sql.Rows, schema *ResultSchema, serverID string) ([]string, []ColumnKind, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resultColumns := make([]resultColumn, len(columnTypes))
+	for i, ct := range columnTypes {
+		resultColumns[i] = ct
+	}
+	return checkResultColumns(resultColumns, schema, serverID)
+}
+
+//checkResultColumns ...
+func checkResultColumns(resultColumns []resultColumn, schema *ResultSchema, serverID string) ([]string, []ColumnKind, error) {
+	columns := make([]string, len(resultColumns))
+	kinds := make([]ColumnKind, len(resultColumns))
+	kindByColumn := make(map[string]ColumnKind, len(resultColumns))
+	for i, ct := range resultColumns {
+		columns[i] = strings.ToLower(ct.Name())
+		kinds[i] = columnKind(ct.DatabaseTypeName())
+		kindByColumn[columns[i]] = kinds[i]
+	}
+
+	expected := make(map[string]bool, len(schema.Columns))
+	missing := make([]string, 0)
+	changed := make([]string, 0)
+	for _, c := range schema.Columns {
+		name := strings.ToLower(c.Name)
+		expected[name] = true
+
+		kind, ok := kindByColumn[name]
+		if !ok {
+			if !c.Optional {
+				missing = append(missing, name)
+			}
+			continue
+		}
+		//unknown database types can't be compared
+		if len(c.Kinds) > 0 && kind != ColumnUnknown && !containsKind(c.Kinds, kind) {
+			changed = append(changed, fmt.Sprintf("%v(%v, expected %v)", name, kind, c.Kinds))
+		}
+	}
+
+	if len(missing) > 0 || len(changed) > 0 {
+		message := fmt.Sprintf("%v result-set, missing columns: %v, changed types: %v", schema.Name, missing, changed)
+		alert.Raise(alert.Alert{
+			Kind:      AlertSchemaDrift,
+			ServerIDs: []string{serverID},
+			Message:   message,
+			Details:   map[string]interface{}{"resultset": schema.Name, "missing": missing, "changed": changed},
+		})
+		return columns, kinds, fmt.Errorf("%w: %v", ErrSchemaDrift, message)
+	}
+
+	extra := make([]string, 0)
+	for _, c := range columns {
+		if !expected[c] {
+			extra = append(extra, c)
+		}
+	}
+	if len(extra) > 0 {
+		reportExtraColumns(schema.Name, serverID, extra)
+	}
+
+	return columns, kinds, nil
+}
+
+//reportExtraColumns logging the extra columns of the server once, false when already reported
+func reportExtraColumns(schemaName string, serverID string, extra []string) bool {
+	sort.Strings(extra)
+	key := fmt.Sprintf("%s|%s|%s", schemaName, serverID, strings.Join(extra, ","))
+	if _, reported := reportedExtraColumns.LoadOrStore(key, true); reported {
+		return false
+	}
+
+	logger.Log().Info(fmt.Sprintf("Schema Server=%v %v result-set, extra columns(not processed): %v", serverID, schemaName, extra))
+	return true
+}
+
+func containsKind(kinds []ColumnKind, kind ColumnKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package sqldataprovider
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//fakeColumn result column with the database type name of the driver
+type fakeColumn struct {
+	name             string
+	databaseTypeName string
+}
+
+func (c fakeColumn) Name() string {
+	return c.name
+}
+
+func (c fakeColumn) DatabaseTypeName() string {
+	return c.databaseTypeName
+}
+
+//fakeColumns name/type pairs
+func fakeColumns(nameTypes ...string) []resultColumn {
+	columns := make([]resultColumn, 0, len(nameTypes)/2)
+	for i := 0; i+1 < len(nameTypes); i += 2 {
+		columns = append(columns, fakeColumn{nameTypes[i], nameTypes[i+1]})
+	}
+	return columns
+}
+
+var testSchema = &ResultSchema{
+	Name: "test",
+	Columns: []ExpectedColumn{
+		{"deviceid", stringKinds, false},
+		{"active", intKinds, false},
+		{"lastmodifieddate", []ColumnKind{ColumnTime}, true},
+		{"anykind", nil, false},
+	},
+}
+
+//resetReportedExtraColumns forgetting the reported extra columns(kept for the process lifetime)
+func resetReportedExtraColumns() {
+	reportedExtraColumns.Range(func(key, value interface{}) bool {
+		reportedExtraColumns.Delete(key)
+		return true
+	})
+}
+
+func TestColumnKind(t *testing.T) {
+	cases := map[string]ColumnKind{
+		"NVARCHAR":         ColumnString,
+		"varchar":          ColumnString,
+		"JSONB":            ColumnString,
+		"INT":              ColumnInt,
+		"int8":             ColumnInt,
+		"DECIMAL":          ColumnFloat,
+		"FLOAT8":           ColumnFloat,
+		"BIT":              ColumnBool,
+		"BOOL":             ColumnBool,
+		"DATETIME2":        ColumnTime,
+		"TIMESTAMPTZ":      ColumnTime,
+		"VARBINARY":        ColumnBinary,
+		"UNIQUEIDENTIFIER": ColumnBinary,
+		"BYTEA":            ColumnBinary,
+		"SQL_VARIANT":      ColumnUnknown,
+		"":                 ColumnUnknown,
+	}
+	for databaseTypeName, want := range cases {
+		if got := columnKind(databaseTypeName); got != want {
+			t.Errorf("columnKind(%v) = %v, want %v", databaseTypeName, got, want)
+		}
+	}
+}
+
+func TestCheckResultColumns(t *testing.T) {
+	//optional columns may be missing, names are lower cased
+	columns, kinds, err := checkResultColumns(fakeColumns("DeviceID", "NVARCHAR", "Active", "INT", "AnyKind", "BIT"), testSchema, "1")
+	if err != nil {
+		t.Fatalf("error : %v", err)
+	}
+	if want := []string{"deviceid", "active", "anykind"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+	if want := []ColumnKind{ColumnString, ColumnInt, ColumnBool}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("kinds = %v, want %v", kinds, want)
+	}
+}
+
+func TestCheckResultColumnsDrift(t *testing.T) {
+	cases := map[string]struct {
+		columns []resultColumn
+		want    string
+	}{
+		"missing required column": {
+			fakeColumns("deviceid", "NVARCHAR", "anykind", "INT"),
+			"missing columns: [active]",
+		},
+		"changed type": {
+			fakeColumns("deviceid", "INT", "active", "INT", "anykind", "INT"),
+			"changed types: [deviceid(int, expected [string binary])]",
+		},
+		"changed type of an optional column": {
+			fakeColumns("deviceid", "NVARCHAR", "active", "INT", "anykind", "INT", "lastmodifieddate", "NVARCHAR"),
+			"changed types: [lastmodifieddate(string, expected [time])]",
+		},
+	}
+	for name, c := range cases {
+		_, _, err := checkResultColumns(c.columns, testSchema, "1")
+		if !errors.Is(err, ErrSchemaDrift) {
+			t.Errorf("%v error = %v, want %v", name, err, ErrSchemaDrift)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v error = %v, want %v", name, err, c.want)
+		}
+	}
+}
+
+func TestCheckResultColumnsIgnoresUnknownTypes(t *testing.T) {
+	//unknown database types can't be compared, so they are accepted
+	_, kinds, err := checkResultColumns(fakeColumns("deviceid", "SQL_VARIANT", "active", "INT", "anykind", "INT"), testSchema, "1")
+	if err != nil {
+		t.Fatalf("error : %v", err)
+	}
+	if kinds[0] != ColumnUnknown {
+		t.Errorf("kind = %v, want %v", kinds[0], ColumnUnknown)
+	}
+}
+
+func TestExtraColumnsReportedOnce(t *testing.T) {
+	resetReportedExtraColumns()
+
+	_, _, err := checkResultColumns(fakeColumns("deviceid", "NVARCHAR", "active", "INT", "anykind", "INT", "Zextra", "INT", "aextra", "INT"), testSchema, "1")
+	if err != nil {
+		t.Fatalf("extra columns error : %v", err)
+	}
+
+	//already reported by the check(in any order)
+	if reportExtraColumns(testSchema.Name, "1", []string{"zextra", "aextra"}) {
+		t.Errorf("extra columns reported twice")
+	}
+	//other servers/columns are reported on their own
+	if !reportExtraColumns(testSchema.Name, "2", []string{"aextra", "zextra"}) {
+		t.Errorf("extra columns of server 2 not reported")
+	}
+	if !reportExtraColumns(testSchema.Name, "1", []string{"aextra"}) {
+		t.Errorf("changed extra columns not reported")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/entity"
+	sm "data-sync-agent/entity/storeman"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//agent initiated deletes are not reconciled by the watcher, entries expire after agentDeleteExpiry
+const agentDeleteExpiry = 10 * time.Minute
+
+var agentDeletes = struct {
+	sync.Mutex
+	ids map[string]time.Time
+}{ids: make(map[string]time.Time)}
+
+//markAgentDeletes registering the device ids deleted by the agent itself
+func markAgentDeletes(deviceIDs []string) {
+	agentDeletes.Lock()
+	defer agentDeletes.Unlock()
+
+	currentTime := time.Now()
+	for id, markedOn := range agentDeletes.ids {
+		if currentTime.Sub(markedOn) > agentDeleteExpiry {
+			delete(agentDeletes.ids, id)
+		}
+	}
+
+	for _, id := range deviceIDs {
+		agentDeletes.ids[id] = currentTime
+	}
+}
+
+//isAgentDelete checks and consumes the agent delete mark of the device
+func isAgentDelete(deviceID string) bool {
+	agentDeletes.Lock()
+	defer agentDeletes.Unlock()
+
+	markedOn, ok := agentDeletes.ids[deviceID]
+	if ok {
+		delete(agentDeletes.ids, deviceID)
+	}
+	return ok && time.Since(markedOn) <= agentDeleteExpiry
+}
+
+//deviceChangeEvent mongo change event of the device document
+type deviceChangeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	FullDocument bson.M `bson:"fullDocument"`
+}
+
+//deviceChangeWatcher watches the device projection collection, restores the documents deleted/modified externally
+//from the registered device data and records the listener state of the devices
+type deviceChangeWatcher struct {
+	projection    *model.MongoProjection
+	stateInterval time.Duration
+	retryInterval time.Duration
+
+	//dependencies, replaced with fakes on tests
+	openStream func(ctx context.Context, resumeToken bson.Raw) (sm.ChangeStream, error)
+	getDevice  func(deviceID string) (*model.RegisteredDeviceData, error)
+	restore    func(devices []model.MongoDeviceData) error
+	saveState  func(state *model.DeviceListenerState) error
+	now        func() time.Time
+
+	resumeToken    bson.Raw
+	lastStateSaved map[string]time.Time
+	lastEvictedOn  time.Time
+}
+
+//newDeviceChangeWatcher watcher wired with mongo and redis
+func newDeviceChangeWatcher(projection *model.MongoProjection, redisKeyForListenerState string) *deviceChangeWatcher {
+	pipeline := bson.A{bson.M{"$match": bson.M{"operationType": bson.M{"$in": bson.A{"delete", "update", "replace"}}}}}
+
+	return &deviceChangeWatcher{
+		projection:     projection,
+		stateInterval:  5 * time.Minute,
+		retryInterval:  10 * time.Second,
+		lastStateSaved: make(map[string]time.Time),
+		now:            time.Now,
+
+		openStream: func(ctx context.Context, resumeToken bson.Raw) (sm.ChangeStream, error) {
+			return entity.Watch(ctx, projection.Collection, pipeline, resumeToken)
+		},
+		getDevice: func(deviceID string) (*model.RegisteredDeviceData, error) {
+			jsonData, err := config.HGet(redisKeyForRegisteredDevice, deviceID)
+			if err != nil || jsonData == "" {
+				//not registered
+				return nil, nil
+			}
+
+			device := &model.RegisteredDeviceData{}
+			if err := json.Unmarshal([]byte(jsonData), device); err != nil {
+				return nil, err
+			}
+			return device, nil
+		},
+		restore: func(devices []model.MongoDeviceData) error {
+			//standby replicas only record the listener state
+			if !isLeader() {
+				return nil
+			}
+
+			_, _, err := entity.BulkWrite(projection, devices, nil)
+			return err
+		},
+		saveState: func(state *model.DeviceListenerState) error {
+			if redisKeyForListenerState == "" {
+				return nil
+			}
+
+			jsonData, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			return config.HSet(redisKeyForListenerState, state.DeviceID, string(jsonData))
+		},
+	}
+}
+
+//startDeviceChangeWatcher starting the watcher on the configured projection(optional)
+func startDeviceChangeWatcher() {
+	if helper.GetEnv(helper.MongoChangeWatcherEnabled) != "1" {
+		return
+	}
+
+	projectionName := helper.GetEnv(helper.MongoChangeWatcherProjection)
+	if projectionName == "" {
+		projectionName = "recentupdates"
+	}
+
+	for _, p := range entity.Projections() {
+		if p.Name == projectionName {
+			//the collection stream can not cover the per-tenant collections
+			if entity.IsPerTenant(p) {
+				logger.Log().Error(fmt.Sprintf("Device Change Watcher projection %v is per-tenant(%v), not supported", projectionName, p.Collection))
+				return
+			}
+
+			logger.Log().Info(fmt.Sprintf("Device Change Watcher Starting!! collection : %v", p.Collection))
+			go newDeviceChangeWatcher(p, helper.GetEnv(helper.RedisKeyForDeviceListenerState)).run(context.Background())
+			return
+		}
+	}
+
+	logger.Log().Error(fmt.Sprintf("Device Change Watcher projection %v not found", projectionName))
+}
+
+//run consumes the change stream until the app stops, re-opening(resuming) the stream on errors
+func (w *deviceChangeWatcher) run(ctx context.Context) {
+	for isAppAlive && ctx.Err() == nil {
+		stream, err := w.openStream(ctx, w.resumeToken)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("Device Change Watcher (Watch) Error : %v", err.Error()))
+		} else {
+			err = w.consume(ctx, stream)
+			if err != nil {
+				logger.Log().Error(fmt.Sprintf("Device Change Watcher (Stream) Error : %v", err.Error()))
+			}
+		}
+
+		time.Sleep(w.retryInterval)
+	}
+}
+
+//consume handles all the events of the stream
+func (w *deviceChangeWatcher) consume(ctx context.Context, stream sm.ChangeStream) error {
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		event := &deviceChangeEvent{}
+		if err := stream.Decode(event); err != nil {
+			logger.Log().Error(fmt.Sprintf("Device Change Watcher (Decode) Error : %v", err.Error()))
+		} else {
+			w.handle(event)
+		}
+
+		w.resumeToken = stream.ResumeToken()
+
+		if !isAppAlive {
+			return nil
+		}
+	}
+
+	return stream.Err()
+}
+
+//handle reconciles the device of the event
+func (w *deviceChangeWatcher) handle(event *deviceChangeEvent) {
+	deviceID := event.DocumentKey.ID
+	if deviceID == "" {
+		return
+	}
+
+	switch event.OperationType {
+	case "delete":
+		if isAgentDelete(deviceID) {
+			return
+		}
+
+		device := w.getInstalledDevice(deviceID)
+		if device == nil {
+			return
+		}
+
+		logger.Log().Warn(fmt.Sprintf("Device Change Watcher DeviceID: %v deleted externally, restoring", deviceID))
+		w.restoreDevice(device)
+
+	case "update", "replace":
+		changedFields := event.UpdateDescription.UpdatedFields
+		if event.OperationType == "replace" {
+			changedFields = event.FullDocument
+		}
+
+		agentFieldsChanged, listenerFieldsChanged := w.classifyFields(changedFields, event.UpdateDescription.RemovedFields)
+
+		if listenerFieldsChanged {
+			w.recordListenerState(deviceID, event.FullDocument)
+		}
+
+		if !agentFieldsChanged {
+			return
+		}
+
+		device := w.getInstalledDevice(deviceID)
+		if device == nil {
+			return
+		}
+
+		if w.hasDrift(device, changedFields, event.UpdateDescription.RemovedFields) {
+			logger.Log().Warn(fmt.Sprintf("Device Change Watcher DeviceID: %v modified externally, restoring", deviceID))
+			w.restoreDevice(device)
+		}
+	}
+}
+
+//classifyFields whether the changed fields are owned by the agent(projection fields) or by the listener
+func (w *deviceChangeWatcher) classifyFields(changedFields bson.M, removedFields []string) (bool, bool) {
+	agentFieldsChanged, listenerFieldsChanged := false, false
+
+	for field := range changedFields {
+		if field == "_id" {
+			continue
+		}
+		if _, ok := w.projection.Fields[field]; ok {
+			agentFieldsChanged = true
+		} else {
+			listenerFieldsChanged = true
+		}
+	}
+
+	for _, field := range removedFields {
+		if _, ok := w.projection.Fields[field]; ok {
+			agentFieldsChanged = true
+		}
+	}
+
+	return agentFieldsChanged, listenerFieldsChanged
+}
+
+//hasDrift compares the changed agent fields with the registered device data(scalar fields only)
+func (w *deviceChangeWatcher) hasDrift(device *model.RegisteredDeviceData, changedFields bson.M, removedFields []string) bool {
+	for _, field := range removedFields {
+		if _, ok := w.projection.Fields[field]; ok {
+			return true
+		}
+	}
+
+	expectedFields := entity.ProjectedFields(w.projection, toMongoDeviceData(device))
+	for field, expected := range expectedFields {
+		actual, ok := changedFields[field]
+		if !ok {
+			continue
+		}
+
+		kind := reflect.ValueOf(expected).Kind()
+		if kind == reflect.Slice || kind == reflect.Map || kind == reflect.Ptr {
+			continue
+		}
+
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", expected) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//getInstalledDevice returns the registered device, only when the device should be on mongo(active + installed)
+func (w *deviceChangeWatcher) getInstalledDevice(deviceID string) *model.RegisteredDeviceData {
+	device, err := w.getDevice(deviceID)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("Device Change Watcher (Registered Device) DeviceID: %v Error : %v", deviceID, err.Error()))
+		return nil
+	}
+
+	if device == nil || device.Active != 1 || device.DeviceMasterStatusUno != 5 {
+		return nil
+	}
+	return device
+}
+
+func (w *deviceChangeWatcher) restoreDevice(device *model.RegisteredDeviceData) {
+	if err := w.restore([]model.MongoDeviceData{toMongoDeviceData(device)}); err != nil {
+		logger.Log().Error(fmt.Sprintf("Device Change Watcher (Restore) DeviceID: %v Error : %v", device.DeviceID, err.Error()))
+	}
+}
+
+//recordListenerState saving the listener state of the device, throttled by the state interval
+func (w *deviceChangeWatcher) recordListenerState(deviceID string, fullDocument bson.M) {
+	currentTime := w.now().UTC()
+	w.evictListenerStates(currentTime)
+
+	if lastSaved, ok := w.lastStateSaved[deviceID]; ok && currentTime.Sub(lastSaved) < w.stateInterval {
+		return
+	}
+
+	state := &model.DeviceListenerState{
+		DeviceID:      deviceID,
+		EverConnected: true,
+		LastSeenOn:    currentTime,
+	}
+	if deviceDateTime, ok := fullDocument["devicedatetime"]; ok {
+		state.LastDeviceDateTime = toTime(deviceDateTime)
+	}
+
+	if err := w.saveState(state); err != nil {
+		logger.Log().Error(fmt.Sprintf("Device Change Watcher (Listener State) DeviceID: %v Error : %v", deviceID, err.Error()))
+		return
+	}
+	w.lastStateSaved[deviceID] = currentTime
+}
+
+//evictListenerStates removing the devices saved before the state interval(no longer throttled), once per interval
+func (w *deviceChangeWatcher) evictListenerStates(currentTime time.Time) {
+	if currentTime.Sub(w.lastEvictedOn) < w.stateInterval {
+		return
+	}
+
+	for deviceID, lastSaved := range w.lastStateSaved {
+		if currentTime.Sub(lastSaved) >= w.stateInterval {
+			delete(w.lastStateSaved, deviceID)
+		}
+	}
+	w.lastEvictedOn = currentTime
+}
+
+func toTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC()
+	case primitive.DateTime:
+		return t.Time().UTC()
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	sm "data-sync-agent/entity/storeman"
+	"data-sync-agent/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//fakeWatcherDeps registered devices and the restored/saved calls of the watcher
+type fakeWatcherDeps struct {
+	mu       sync.Mutex
+	devices  map[string]*model.RegisteredDeviceData
+	restored chan string
+	states   []*model.DeviceListenerState
+}
+
+func newTestWatcher(streams ...*sm.FakeChangeStream) (*deviceChangeWatcher, *fakeWatcherDeps, chan bson.Raw) {
+	//marks left by the store tests would hide the deletes of the events
+	agentDeletes.Lock()
+	agentDeletes.ids = make(map[string]time.Time)
+	agentDeletes.Unlock()
+
+	deps := &fakeWatcherDeps{
+		devices:  map[string]*model.RegisteredDeviceData{"d1": installedDevice("d1", "1"), "d2": installedDevice("d2", "1")},
+		restored: make(chan string, 10),
+	}
+	opened := make(chan bson.Raw, len(streams)+1)
+
+	w := &deviceChangeWatcher{
+		projection: &model.MongoProjection{
+			Name:       "recentupdates",
+			Collection: "tblvehiclerecentupdates",
+			Fields:     map[string]string{"tenantuid": "tenantuid", "devicetypeid": "devicetypeid"},
+		},
+		stateInterval:  time.Minute,
+		retryInterval:  time.Millisecond,
+		lastStateSaved: make(map[string]time.Time),
+		now:            time.Now,
+
+		openStream: func(ctx context.Context, resumeToken bson.Raw) (sm.ChangeStream, error) {
+			index := len(opened)
+			opened <- resumeToken
+			if index >= len(streams) {
+				//no more streams, waiting until the test stops the watcher
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return streams[index], nil
+		},
+		getDevice: func(deviceID string) (*model.RegisteredDeviceData, error) {
+			deps.mu.Lock()
+			defer deps.mu.Unlock()
+			return deps.devices[deviceID], nil
+		},
+		restore: func(devices []model.MongoDeviceData) error {
+			for _, d := range devices {
+				deps.restored <- d.ID
+			}
+			return nil
+		},
+		saveState: func(state *model.DeviceListenerState) error {
+			deps.mu.Lock()
+			defer deps.mu.Unlock()
+			deps.states = append(deps.states, state)
+			return nil
+		},
+	}
+
+	return w, deps, opened
+}
+
+func changeEvent(token, operationType, deviceID string, updatedFields bson.M) bson.M {
+	return bson.M{
+		"_id":               token,
+		"operationType":     operationType,
+		"documentKey":       bson.M{"_id": deviceID},
+		"updateDescription": bson.M{"updatedFields": updatedFields, "removedFields": bson.A{}},
+		"fullDocument":      bson.M{"_id": deviceID},
+	}
+}
+
+func waitFor(t *testing.T, ch chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %v", want)
+	}
+}
+
+func waitForToken(t *testing.T, opened chan bson.Raw) bson.Raw {
+	t.Helper()
+	select {
+	case token := <-opened:
+		return token
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the stream to open")
+	}
+	return nil
+}
+
+func TestDeviceChangeWatcherResumesAfterReconnect(t *testing.T) {
+	first, second := sm.NewFakeChangeStream(10), sm.NewFakeChangeStream(10)
+	w, deps, opened := newTestWatcher(first, second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+
+	if token := waitForToken(t, opened); token != nil {
+		t.Errorf("first resume token = %v, want none", token)
+	}
+
+	first.Push(changeEvent("t1", "delete", "d1", nil))
+	waitFor(t, deps.restored, "d1")
+
+	//stream dropped, re-opened after the last handled event
+	first.Close(context.Background())
+	token := waitForToken(t, opened)
+	if data, _ := token.Lookup("_data").StringValueOK(); data != "t1" {
+		t.Errorf("resume token = %v, want t1", token)
+	}
+
+	second.Push(changeEvent("t2", "update", "d2", bson.M{"tenantuid": "another-tenant"}))
+	waitFor(t, deps.restored, "d2")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("watcher not stopped")
+	}
+}
+
+func TestDeviceChangeWatcherSkipsAgentDeletesAndMatchingUpdates(t *testing.T) {
+	w, deps, _ := newTestWatcher()
+
+	markAgentDeletes([]string{"d1"})
+	w.handle(decodeEvent(t, changeEvent("t1", "delete", "d1", nil)))
+	//update matching the registered device is not a drift
+	w.handle(decodeEvent(t, changeEvent("t2", "update", "d2", bson.M{"tenantuid": "tenant-1"})))
+	//not registered/installed devices are not restored
+	w.handle(decodeEvent(t, changeEvent("t3", "delete", "d9", nil)))
+
+	select {
+	case id := <-deps.restored:
+		t.Errorf("device %v restored, want none", id)
+	default:
+	}
+
+	//mark is consumed, so the next external delete is restored
+	w.handle(decodeEvent(t, changeEvent("t4", "delete", "d1", nil)))
+	waitFor(t, deps.restored, "d1")
+}
+
+func TestDeviceChangeWatcherThrottlesListenerState(t *testing.T) {
+	w, deps, _ := newTestWatcher()
+
+	currentTime := time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return currentTime }
+
+	listenerUpdate := func(token, deviceID string) {
+		w.handle(decodeEvent(t, changeEvent(token, "update", deviceID, bson.M{"speed": 10})))
+	}
+
+	listenerUpdate("t1", "d1")
+	listenerUpdate("t2", "d1")
+	currentTime = currentTime.Add(30 * time.Second)
+	listenerUpdate("t3", "d1")
+	listenerUpdate("t4", "d2")
+	if len(deps.states) != 2 {
+		t.Fatalf("saved states = %v, want 2(d1, d2)", len(deps.states))
+	}
+
+	currentTime = currentTime.Add(31 * time.Second)
+	listenerUpdate("t5", "d1")
+	if len(deps.states) != 3 || deps.states[2].DeviceID != "d1" {
+		t.Fatalf("saved states = %v, want d1 saved again after the interval", len(deps.states))
+	}
+
+	//d2 is not updated anymore, so evicted once the interval is over
+	currentTime = currentTime.Add(2 * time.Minute)
+	listenerUpdate("t6", "d1")
+	if _, ok := w.lastStateSaved["d2"]; ok || len(w.lastStateSaved) != 1 {
+		t.Errorf("throttled devices = %v, want only d1", w.lastStateSaved)
+	}
+}
+
+func decodeEvent(t *testing.T, event bson.M) *deviceChangeEvent {
+	t.Helper()
+
+	raw, err := bson.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+
+	decoded := &deviceChangeEvent{}
+	if err := bson.Unmarshal(raw, decoded); err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	return decoded
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"data-sync-agent/alert"
+	"data-sync-agent/config"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+	"data-sync-agent/validation"
+)
+
+//resolution policies of the devices present on more than one server
+const (
+	duplicatePolicyLatestModified = "latestmodified"
+	duplicatePolicyServerPriority = "serverpriority"
+	duplicatePolicyQuarantine     = "quarantine"
+)
+
+const alertDuplicateDevice = "duplicatedevice"
+
+//claims the device owners(ARGV: deviceid, expected value, new value, ...; empty => no owner), a claim is applied
+//only when the saved owner is still the expected one, so the replicas can't both own a device. returns the lost claims
+const claimDeviceOwnersScript = `
+local lost = {}
+for i = 1, #ARGV, 3 do
+	local current = redis.call('HGET', KEYS[1], ARGV[i]) or ''
+	if current ~= ARGV[i + 1] then
+		table.insert(lost, ARGV[i])
+	elseif ARGV[i + 2] == '' then
+		redis.call('HDEL', KEYS[1], ARGV[i])
+	elseif ARGV[i + 2] ~= current then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 2])
+	end
+end
+return lost`
+
+//DeviceOwner server owning the device, saved on the device owners hash(deviceid => owner json),
+//a quarantined device is saved with its claiming servers, so the later single claims stay blocked
+type DeviceOwner struct {
+	ServerID         string     `json:"serverid"`
+	TenantUID        string     `json:"tenantuid"`
+	LastModifiedDate *time.Time `json:"lastmodifieddate,omitempty"`
+	Quarantined      bool       `json:"quarantined,omitempty"`
+	ServerIDs        []string   `json:"serverids,omitempty"`
+}
+
+//deviceClaim active row of this cycle, or the saved owner(device nil)
+type deviceClaim struct {
+	owner  DeviceOwner
+	device *model.RegisteredDeviceData
+}
+
+//checkDuplicateDeviceSettings the fetches are incremental, so the duplicates are mostly across the cycles,
+//which are detected through the device owners hash only
+func checkDuplicateDeviceSettings() {
+	if helper.GetEnv(helper.DuplicateDevicePolicy) != "" && helper.GetEnv(helper.RedisKeyForDeviceOwners) == "" {
+		logger.Log().Fatal(fmt.Sprintf("checkDuplicateDeviceSettings %v requires %v", helper.DuplicateDevicePolicy, helper.RedisKeyForDeviceOwners))
+	}
+}
+
+//resolveDuplicateDevices keeping a single server per device based on the policy(DUPLICATEDEVICEPOLICY),
+//conflicts with the devices saved on the earlier cycles are detected through the device owners hash.
+//returns the resolved devices and the device ids of the claims lost to another replica(re-sent on the next cycle)
+func resolveDuplicateDevices(devices []*model.RegisteredDeviceData) ([]*model.RegisteredDeviceData, []string) {
+	if len(devices) == 0 {
+		return devices, nil
+	}
+
+	policy := strings.ToLower(strings.TrimSpace(helper.GetEnv(helper.DuplicateDevicePolicy)))
+	switch policy {
+	case duplicatePolicyLatestModified, duplicatePolicyServerPriority, duplicatePolicyQuarantine:
+	case "":
+		policy = duplicatePolicyLatestModified
+	default:
+		logger.Log().Error(fmt.Sprintf("resolveDuplicateDevices unknown policy '%v', using %v", policy, duplicatePolicyLatestModified))
+		policy = duplicatePolicyLatestModified
+	}
+	priority := serverPriority()
+
+	//rows of this cycle by device(the last row of a server wins, as before)
+	deviceIDs := make([]string, 0, len(devices))
+	rowsByDevice := make(map[string]map[string]*model.RegisteredDeviceData, len(devices))
+	for _, d := range devices {
+		rows, ok := rowsByDevice[d.DeviceID]
+		if !ok {
+			rows = make(map[string]*model.RegisteredDeviceData, 1)
+			rowsByDevice[d.DeviceID] = rows
+			deviceIDs = append(deviceIDs, d.DeviceID)
+		}
+		rows[d.ServerID] = d
+	}
+
+	redisKeyForDeviceOwners := helper.GetEnv(helper.RedisKeyForDeviceOwners)
+	owners, ownerValues := loadDeviceOwners(redisKeyForDeviceOwners, deviceIDs)
+
+	resolved := make([]*model.RegisteredDeviceData, 0, len(devices))
+	violations := make([]validation.Violation, 0)
+	//new owner values(empty => removed) and the released quarantines, applied once the claims are won
+	ownerUpdates := make(map[string]string)
+	releases := make(map[string][]string)
+
+	for _, deviceID := range deviceIDs {
+		rows := rowsByDevice[deviceID]
+		owner, hasOwner := owners[deviceID]
+
+		//quarantined on an earlier cycle, released once a single server is left
+		if hasOwner && owner.Quarantined {
+			released, quarantined := quarantinedClaims(deviceID, rows, &owner, ownerUpdates, releases)
+			if released != nil {
+				resolved = append(resolved, released)
+			}
+			violations = append(violations, quarantined...)
+			continue
+		}
+
+		//only the active rows claim the device
+		claims := make([]deviceClaim, 0, len(rows)+1)
+		for _, serverID := range sortedServerIDs(rows) {
+			if d := rows[serverID]; d.Active == 1 {
+				claims = append(claims, deviceClaim{owner: DeviceOwner{ServerID: serverID, TenantUID: d.TenantUID, LastModifiedDate: d.LastModifiedDate}, device: d})
+			}
+		}
+		if hasOwner {
+			if d, ok := rows[owner.ServerID]; !ok || d.Active != 1 {
+				//removed by the owner on this cycle, otherwise still owned
+				if !ok {
+					claims = append(claims, deviceClaim{owner: owner})
+				}
+			}
+		}
+
+		var winner *deviceClaim
+		if len(claims) == 1 {
+			winner = &claims[0]
+		} else if len(claims) > 1 {
+			winner = resolveClaims(policy, claims, priority)
+			raiseDuplicateDevice(deviceID, policy, claims, winner)
+
+			for i := range claims {
+				c := &claims[i]
+				if c.device == nil || (winner != nil && c.device == winner.device) {
+					continue
+				}
+				if policy == duplicatePolicyQuarantine {
+					violations = append(violations, duplicateViolation(c.device, claimServerIDs(claims)))
+				} else {
+					logger.Log().Warn(fmt.Sprintf("resolveDuplicateDevices DeviceID=%v Server=%v dropped, owned by Server=%v(%v)", deviceID, c.device.ServerID, winner.owner.ServerID, policy))
+				}
+			}
+		}
+
+		if winner != nil {
+			if winner.device != nil {
+				resolved = append(resolved, winner.device)
+				if redisKeyForDeviceOwners != "" && (!hasOwner || !sameOwner(owner, winner.owner)) {
+					if jsonData, err := json.Marshal(winner.owner); err == nil {
+						ownerUpdates[deviceID] = string(jsonData)
+					}
+				}
+			}
+			continue
+		}
+
+		//quarantined without a saved owner, the claiming servers are saved instead
+		if len(claims) > 1 && redisKeyForDeviceOwners != "" {
+			if jsonData, err := json.Marshal(DeviceOwner{Quarantined: true, ServerIDs: claimServerIDs(claims)}); err == nil {
+				ownerUpdates[deviceID] = string(jsonData)
+			}
+			continue
+		}
+
+		//no active claim(or quarantined), the deactivation goes through only from the owner(or when not owned)
+		if len(claims) == 0 {
+			for _, serverID := range sortedServerIDs(rows) {
+				if !hasOwner || serverID == owner.ServerID {
+					resolved = append(resolved, rows[serverID])
+					if hasOwner {
+						ownerUpdates[deviceID] = ""
+					}
+					break
+				}
+			}
+		}
+	}
+
+	lost := claimDeviceOwners(redisKeyForDeviceOwners, deviceIDs, ownerValues, ownerUpdates)
+	if len(lost) > 0 {
+		resolved, violations = withoutLostClaims(lost, resolved, violations, releases)
+	}
+
+	for deviceID, serverIDs := range releases {
+		releaseDuplicateQuarantine(deviceID, serverIDs)
+	}
+	quarantineDevices(quarantineKindDuplicate, violations)
+
+	return resolved, lost
+}
+
+//withoutLostClaims dropping the rows/quarantines of the devices claimed by another replica meanwhile
+func withoutLostClaims(lost []string, resolved []*model.RegisteredDeviceData, violations []validation.Violation, releases map[string][]string) ([]*model.RegisteredDeviceData, []validation.Violation) {
+	lostIDs := make(map[string]bool, len(lost))
+	for _, deviceID := range lost {
+		lostIDs[deviceID] = true
+		delete(releases, deviceID)
+	}
+
+	keptDevices := make([]*model.RegisteredDeviceData, 0, len(resolved))
+	for _, d := range resolved {
+		if !lostIDs[d.DeviceID] {
+			keptDevices = append(keptDevices, d)
+		}
+	}
+	keptViolations := make([]validation.Violation, 0, len(violations))
+	for _, v := range violations {
+		if !lostIDs[v.Device.DeviceID] {
+			keptViolations = append(keptViolations, v)
+		}
+	}
+	return keptDevices, keptViolations
+}
+
+//quarantinedClaims rows of a quarantined device are kept in the quarantine, the servers deactivating it leave the claim,
+//the quarantine is released once a single server is left(its active row of this cycle, otherwise its next row wins)
+func quarantinedClaims(deviceID string, rows map[string]*model.RegisteredDeviceData, owner *DeviceOwner, ownerUpdates map[string]string, releases map[string][]string) (*model.RegisteredDeviceData, []validation.Violation) {
+	serverIDs := make([]string, 0, len(owner.ServerIDs)+len(rows))
+	for _, serverID := range owner.ServerIDs {
+		if d, ok := rows[serverID]; !ok || d.Active == 1 {
+			serverIDs = append(serverIDs, serverID)
+		}
+	}
+	for _, serverID := range sortedServerIDs(rows) {
+		if rows[serverID].Active == 1 && !containsString(serverIDs, serverID) {
+			serverIDs = append(serverIDs, serverID)
+		}
+	}
+	sort.Strings(serverIDs)
+
+	if len(serverIDs) <= 1 {
+		logger.Log().Info(fmt.Sprintf("resolveDuplicateDevices DeviceID=%v released from the quarantine, servers left %v", deviceID, serverIDs))
+		releases[deviceID] = owner.ServerIDs
+
+		if len(serverIDs) == 1 {
+			if d, ok := rows[serverIDs[0]]; ok {
+				if jsonData, err := json.Marshal(DeviceOwner{ServerID: d.ServerID, TenantUID: d.TenantUID, LastModifiedDate: d.LastModifiedDate}); err == nil {
+					ownerUpdates[deviceID] = string(jsonData)
+					return d, nil
+				}
+			}
+		}
+		ownerUpdates[deviceID] = ""
+		return nil, nil
+	}
+
+	if !reflect.DeepEqual(serverIDs, owner.ServerIDs) {
+		if jsonData, err := json.Marshal(DeviceOwner{Quarantined: true, ServerIDs: serverIDs}); err == nil {
+			ownerUpdates[deviceID] = string(jsonData)
+		}
+	}
+
+	violations := make([]validation.Violation, 0, len(rows))
+	for _, serverID := range sortedServerIDs(rows) {
+		if d := rows[serverID]; d.Active == 1 {
+			violations = append(violations, duplicateViolation(d, serverIDs))
+		}
+	}
+	return nil, violations
+}
+
+func duplicateViolation(d *model.RegisteredDeviceData, serverIDs []string) validation.Violation {
+	return validation.Violation{Device: d, Reasons: []string{fmt.Sprintf("duplicate device across servers %v", serverIDs)}}
+}
+
+//resolveClaims winner of the claims, nil => quarantined(the saved owner is kept on quarantine)
+func resolveClaims(policy string, claims []deviceClaim, priority map[string]int) *deviceClaim {
+	if policy == duplicatePolicyQuarantine {
+		for i := range claims {
+			if claims[i].device == nil {
+				return &claims[i]
+			}
+		}
+		return nil
+	}
+
+	winner := &claims[0]
+	for i := 1; i < len(claims); i++ {
+		if claimWins(policy, &claims[i], winner, priority) {
+			winner = &claims[i]
+		}
+	}
+	return winner
+}
+
+//claimWins latest modified(then server priority) or server priority, the lowest server id on a tie
+func claimWins(policy string, c, current *deviceClaim, priority map[string]int) bool {
+	if policy == duplicatePolicyLatestModified {
+		cTime, currentTime := modifiedOn(c), modifiedOn(current)
+		if !cTime.Equal(currentTime) {
+			return cTime.After(currentTime)
+		}
+	}
+
+	cRank, currentRank := rankOf(c.owner.ServerID, priority), rankOf(current.owner.ServerID, priority)
+	if cRank != currentRank {
+		return cRank < currentRank
+	}
+	return c.owner.ServerID < current.owner.ServerID
+}
+
+func sameOwner(a, b DeviceOwner) bool {
+	return a.ServerID == b.ServerID && a.TenantUID == b.TenantUID &&
+		modifiedOn(&deviceClaim{owner: a}).Equal(modifiedOn(&deviceClaim{owner: b}))
+}
+
+func modifiedOn(c *deviceClaim) time.Time {
+	if c.owner.LastModifiedDate == nil {
+		return time.Time{}
+	}
+	return *c.owner.LastModifiedDate
+}
+
+//rankOf servers not on the priority list come last
+func rankOf(serverID string, priority map[string]int) int {
+	if rank, ok := priority[serverID]; ok {
+		return rank
+	}
+	return len(priority)
+}
+
+//serverPriority server ids by priority(DUPLICATEDEVICESERVERPRIORITY, highest first)
+func serverPriority() map[string]int {
+	priority := make(map[string]int)
+	for _, serverID := range strings.Split(helper.GetEnv(helper.DuplicateDeviceServerPriority), ",") {
+		serverID = strings.TrimSpace(serverID)
+		if _, ok := priority[serverID]; serverID != "" && !ok {
+			priority[serverID] = len(priority)
+		}
+	}
+	return priority
+}
+
+func raiseDuplicateDevice(deviceID, policy string, claims []deviceClaim, winner *deviceClaim) {
+	tenants := make([]string, 0, len(claims))
+	for _, c := range claims {
+		tenants = append(tenants, c.owner.TenantUID)
+	}
+
+	resolution := "quarantined"
+	if winner != nil {
+		resolution = "server " + winner.owner.ServerID
+	}
+
+	alert.Raise(alert.Alert{
+		Kind:      alertDuplicateDevice,
+		ServerIDs: claimServerIDs(claims),
+		Message:   fmt.Sprintf("device %v on servers %v, tenants %v, resolved to %v(%v)", deviceID, claimServerIDs(claims), tenants, resolution, policy),
+		Details:   map[string]interface{}{"deviceid": deviceID, "tenants": tenants, "policy": policy, "resolution": resolution},
+	})
+}
+
+func claimServerIDs(claims []deviceClaim) []string {
+	serverIDs := make([]string, 0, len(claims))
+	for _, c := range claims {
+		serverIDs = append(serverIDs, c.owner.ServerID)
+	}
+	sort.Strings(serverIDs)
+	return serverIDs
+}
+
+func sortedServerIDs(rows map[string]*model.RegisteredDeviceData) []string {
+	serverIDs := make([]string, 0, len(rows))
+	for serverID := range rows {
+		serverIDs = append(serverIDs, serverID)
+	}
+	sort.Strings(serverIDs)
+	return serverIDs
+}
+
+//loadDeviceOwners saved owners of the devices and their raw values(empty when the owners hash is not configured)
+func loadDeviceOwners(redisKeyForDeviceOwners string, deviceIDs []string) (map[string]DeviceOwner, map[string]string) {
+	owners := make(map[string]DeviceOwner)
+	ownerValues := make(map[string]string)
+	if redisKeyForDeviceOwners == "" || len(deviceIDs) == 0 {
+		return owners, ownerValues
+	}
+
+	values, err := config.HMGet(redisKeyForDeviceOwners, deviceIDs)
+	if err != nil {
+		//the claims of the owned devices are lost, so they are retried on the next cycle
+		logger.Log().Error(fmt.Sprintf("loadDeviceOwners Redis Error : %v", err.Error()))
+		return owners, ownerValues
+	}
+
+	for i, v := range values {
+		value, ok := v.(string)
+		if !ok || i >= len(deviceIDs) {
+			continue
+		}
+		ownerValues[deviceIDs[i]] = value
+
+		owner := DeviceOwner{}
+		if err := json.Unmarshal([]byte(value), &owner); err != nil {
+			logger.Log().Error(fmt.Sprintf("loadDeviceOwners DeviceID=%v Unmarshal Error : %v", deviceIDs[i], err.Error()))
+			continue
+		}
+		owners[deviceIDs[i]] = owner
+	}
+
+	return owners, ownerValues
+}
+
+//claimDeviceOwners saving the owners of the devices, unless changed since they were loaded(by another replica).
+//returns the device ids of the lost claims, all of them when the claims can't be saved
+func claimDeviceOwners(redisKeyForDeviceOwners string, deviceIDs []string, ownerValues map[string]string, ownerUpdates map[string]string) []string {
+	if redisKeyForDeviceOwners == "" || len(deviceIDs) == 0 {
+		return nil
+	}
+
+	//unchanged owners are compared as well, the resolution of their rows relies on them
+	args := make([]interface{}, 0, 3*len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		expected := ownerValues[deviceID]
+		value, ok := ownerUpdates[deviceID]
+		if !ok {
+			value = expected
+		}
+		args = append(args, deviceID, expected, value)
+	}
+
+	resp, err := config.Eval(claimDeviceOwnersScript, []string{redisKeyForDeviceOwners}, args...)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("claimDeviceOwners Redis Error : %v", err.Error()))
+		return deviceIDs
+	}
+
+	values, _ := resp.([]interface{})
+	lost := make([]string, 0, len(values))
+	for _, v := range values {
+		if deviceID, ok := v.(string); ok {
+			lost = append(lost, deviceID)
+		}
+	}
+	if len(lost) > 0 {
+		logger.Log().Warn(fmt.Sprintf("claimDeviceOwners %v devices claimed by another replica meanwhile, re-sent on the next cycle : %v", len(lost), lost))
+	}
+	return lost
+}
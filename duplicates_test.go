@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"data-sync-agent/config"
+	cm "data-sync-agent/config/conman"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+)
+
+const testDeviceOwnersKey = "test:deviceowners"
+
+func setupDuplicatePolicy(t *testing.T, policy string) (*cm.MemoryProvider, func() map[string]string) {
+	configStore, _ := setupStores(t)
+	setClaimScript(configStore, nil)
+	setEnv(t, helper.DuplicateDevicePolicy, policy)
+	setEnv(t, helper.DuplicateDeviceServerPriority, "")
+	setEnv(t, helper.RedisKeyForDeviceOwners, testDeviceOwnersKey)
+	setEnv(t, helper.RedisKeyForQuarantinedDevices, testQuarantineKey)
+	setEnv(t, helper.RedisKeyForValidationRules, testValidationRulesKey)
+
+	return configStore, func() map[string]string {
+		quarantined, _ := configStore.HGetAll(testQuarantineKey)
+		return quarantined
+	}
+}
+
+//setClaimScript the device owners claim script on the memory provider, beforeClaim acts as the other replicas
+func setClaimScript(configStore *cm.MemoryProvider, beforeClaim func()) {
+	configStore.SetScript(claimDeviceOwnersScript, func(keys []string, args ...interface{}) (interface{}, error) {
+		if beforeClaim != nil {
+			beforeClaim()
+		}
+
+		lost := make([]interface{}, 0)
+		for i := 0; i+2 < len(args); i += 3 {
+			deviceID, expected, value := args[i].(string), args[i+1].(string), args[i+2].(string)
+			current, _ := configStore.HGet(keys[0], deviceID)
+			switch {
+			case current != expected:
+				lost = append(lost, deviceID)
+			case value == "":
+				configStore.HDel(keys[0], []string{deviceID})
+			case value != current:
+				configStore.HSet(keys[0], deviceID, value)
+			}
+		}
+		return lost, nil
+	})
+}
+
+//modifiedDevice installed device modified on the source at the given hour
+func modifiedDevice(deviceID, serverID string, hour int) *model.RegisteredDeviceData {
+	d := installedDevice(deviceID, serverID)
+	modifiedOn := time.Date(2020, 7, 13, hour, 0, 0, 0, time.UTC)
+	d.LastModifiedDate = &modifiedOn
+	return d
+}
+
+func saveDeviceOwner(t *testing.T, configStore *cm.MemoryProvider, deviceID string, owner DeviceOwner) {
+	t.Helper()
+	jsonData, _ := json.Marshal(owner)
+	configStore.HSet(testDeviceOwnersKey, deviceID, string(jsonData))
+}
+
+func deviceOwnerOf(t *testing.T, deviceID string) (DeviceOwner, bool) {
+	t.Helper()
+
+	values, _ := loadDeviceOwners(testDeviceOwnersKey, []string{deviceID})
+	owner, ok := values[deviceID]
+	return owner, ok
+}
+
+func resolvedIDs(devices []*model.RegisteredDeviceData) []string {
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.ServerID+":"+d.DeviceID)
+	}
+	return ids
+}
+
+func TestQuarantinedDuplicateStaysBlockedOnLaterSingleClaims(t *testing.T) {
+	_, quarantined := setupDuplicatePolicy(t, duplicatePolicyQuarantine)
+
+	//same device on both servers, without a saved owner
+	if resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{installedDevice("d1", "1"), installedDevice("d1", "2")}); len(resolved) != 0 {
+		t.Fatalf("resolved = %v, want none", resolvedIDs(resolved))
+	}
+	owner, ok := deviceOwnerOf(t, "d1")
+	if !ok || !owner.Quarantined || !reflect.DeepEqual(owner.ServerIDs, []string{"1", "2"}) {
+		t.Fatalf("device owner = %+v, want quarantined on [1 2]", owner)
+	}
+
+	//next incremental cycle, only server 2 sends the device
+	devices := validateDevices([]*model.RegisteredDeviceData{installedDevice("d1", "2")})
+	if resolved, _ := resolveDuplicateDevices(devices); len(resolved) != 0 {
+		t.Fatalf("single claim resolved = %v, want blocked", resolvedIDs(resolved))
+	}
+
+	//valid devices don't release the duplicate quarantine
+	entries := quarantined()
+	if len(entries) != 2 {
+		t.Fatalf("quarantined = %v, want 1:d1 and 2:d1", entries)
+	}
+	entry := QuarantinedDevice{}
+	json.Unmarshal([]byte(entries["2:d1"]), &entry)
+	if entry.Kind != quarantineKindDuplicate {
+		t.Errorf("quarantine kind = %v, want %v", entry.Kind, quarantineKindDuplicate)
+	}
+
+	//server 1 deactivates the device, server 2 is left as the owner
+	inactive := installedDevice("d1", "1")
+	inactive.Active = 0
+	resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{inactive, installedDevice("d1", "2")})
+	if !reflect.DeepEqual(resolvedIDs(resolved), []string{"2:d1"}) {
+		t.Fatalf("resolved = %v, want [2:d1]", resolvedIDs(resolved))
+	}
+	if owner, _ := deviceOwnerOf(t, "d1"); owner.Quarantined || owner.ServerID != "2" {
+		t.Errorf("device owner = %+v, want server 2", owner)
+	}
+	if entries := quarantined(); len(entries) != 0 {
+		t.Errorf("quarantined = %v, want released", entries)
+	}
+}
+
+func TestQuarantinedDuplicateReleasedWithoutRowOfRemainingServer(t *testing.T) {
+	setupDuplicatePolicy(t, duplicatePolicyQuarantine)
+
+	resolveDuplicateDevices([]*model.RegisteredDeviceData{installedDevice("d1", "1"), installedDevice("d1", "2")})
+
+	inactive := installedDevice("d1", "1")
+	inactive.Active = 0
+	if resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{inactive}); len(resolved) != 0 {
+		t.Fatalf("resolved = %v, want none", resolvedIDs(resolved))
+	}
+	if owner, ok := deviceOwnerOf(t, "d1"); ok {
+		t.Fatalf("device owner = %+v, want removed", owner)
+	}
+
+	//next row of the remaining server wins
+	resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{installedDevice("d1", "2")})
+	if !reflect.DeepEqual(resolvedIDs(resolved), []string{"2:d1"}) {
+		t.Errorf("resolved = %v, want [2:d1]", resolvedIDs(resolved))
+	}
+}
+
+func TestDuplicateLatestModifiedWins(t *testing.T) {
+	setupDuplicatePolicy(t, duplicatePolicyLatestModified)
+
+	resolved, lost := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "1", 11), modifiedDevice("d1", "2", 10)})
+	if !reflect.DeepEqual(resolvedIDs(resolved), []string{"1:d1"}) || len(lost) != 0 {
+		t.Fatalf("resolved = %v lost = %v, want [1:d1]", resolvedIDs(resolved), lost)
+	}
+	if owner, _ := deviceOwnerOf(t, "d1"); owner.ServerID != "1" || owner.TenantUID != "tenant-1" {
+		t.Errorf("device owner = %+v, want server 1", owner)
+	}
+}
+
+func TestDuplicateLatestModifiedTie(t *testing.T) {
+	cases := map[string]struct {
+		priority string
+		want     string
+	}{
+		"lowest server id":        {"", "2:d1"},
+		"server priority":         {"3", "3:d1"},
+		"listed servers go first": {"9,3", "3:d1"},
+	}
+	for name, c := range cases {
+		setupDuplicatePolicy(t, duplicatePolicyLatestModified)
+		setEnv(t, helper.DuplicateDeviceServerPriority, c.priority)
+
+		resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "3", 10), modifiedDevice("d1", "2", 10)})
+		if got := resolvedIDs(resolved); !reflect.DeepEqual(got, []string{c.want}) {
+			t.Errorf("%v resolved = %v, want %v", name, got, c.want)
+		}
+	}
+
+	//rows without the modification date are the oldest
+	setupDuplicatePolicy(t, duplicatePolicyLatestModified)
+	resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{installedDevice("d1", "1"), modifiedDevice("d1", "2", 10)})
+	if got := resolvedIDs(resolved); !reflect.DeepEqual(got, []string{"2:d1"}) {
+		t.Errorf("without modification date resolved = %v, want [2:d1]", got)
+	}
+}
+
+func TestDuplicateServerPriorityWins(t *testing.T) {
+	setupDuplicatePolicy(t, duplicatePolicyServerPriority)
+	setEnv(t, helper.DuplicateDeviceServerPriority, " 2 , 1")
+
+	//priority over the modification date
+	resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "1", 11), modifiedDevice("d1", "2", 10), modifiedDevice("d1", "3", 12)})
+	if got := resolvedIDs(resolved); !reflect.DeepEqual(got, []string{"2:d1"}) {
+		t.Errorf("resolved = %v, want [2:d1]", got)
+	}
+
+	//servers not on the list come last, the lowest server id on a tie
+	resolved, _ = resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d2", "4", 11), modifiedDevice("d2", "3", 10)})
+	if got := resolvedIDs(resolved); !reflect.DeepEqual(got, []string{"3:d2"}) {
+		t.Errorf("resolved = %v, want [3:d2]", got)
+	}
+}
+
+func TestDuplicateSavedOwnerBeatsNewClaim(t *testing.T) {
+	cases := map[string]struct {
+		policy     string
+		priority   string
+		claimHour  int
+		wantServer string
+	}{
+		"latest modified, older claim":  {duplicatePolicyLatestModified, "", 9, "1"},
+		"latest modified, newer claim":  {duplicatePolicyLatestModified, "", 11, "2"},
+		"server priority, saved higher": {duplicatePolicyServerPriority, "1,2", 11, "1"},
+		"quarantine":                    {duplicatePolicyQuarantine, "", 11, "1"},
+	}
+	for name, c := range cases {
+		configStore, _ := setupDuplicatePolicy(t, c.policy)
+		setEnv(t, helper.DuplicateDeviceServerPriority, c.priority)
+
+		//owned by server 1 since an earlier cycle
+		savedOn := time.Date(2020, 7, 13, 10, 0, 0, 0, time.UTC)
+		saveDeviceOwner(t, configStore, "d1", DeviceOwner{ServerID: "1", TenantUID: "tenant-1", LastModifiedDate: &savedOn})
+
+		resolved, _ := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "2", c.claimHour)})
+
+		want := []string{}
+		if c.wantServer == "2" {
+			want = []string{"2:d1"}
+		}
+		if got := resolvedIDs(resolved); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v resolved = %v, want %v", name, got, want)
+		}
+		if owner, _ := deviceOwnerOf(t, "d1"); owner.ServerID != c.wantServer {
+			t.Errorf("%v device owner = %+v, want server %v", name, owner, c.wantServer)
+		}
+	}
+}
+
+func TestDuplicateClaimLostToAnotherReplica(t *testing.T) {
+	configStore, _ := setupDuplicatePolicy(t, duplicatePolicyLatestModified)
+
+	//another replica claims d1 between the owners load and the claim
+	setClaimScript(configStore, func() {
+		saveDeviceOwner(t, configStore, "d1", DeviceOwner{ServerID: "3", TenantUID: "tenant-3"})
+	})
+
+	resolved, lost := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "1", 10), modifiedDevice("d2", "1", 10)})
+	if !reflect.DeepEqual(resolvedIDs(resolved), []string{"1:d2"}) || !reflect.DeepEqual(lost, []string{"d1"}) {
+		t.Fatalf("resolved = %v lost = %v, want [1:d2] lost [d1]", resolvedIDs(resolved), lost)
+	}
+	if owner, _ := deviceOwnerOf(t, "d1"); owner.ServerID != "3" {
+		t.Errorf("device owner = %+v, want server 3(the other replica's claim)", owner)
+	}
+	if owner, _ := deviceOwnerOf(t, "d2"); owner.ServerID != "1" {
+		t.Errorf("device owner = %+v, want server 1", owner)
+	}
+}
+
+func TestDuplicateClaimsRetriedWhenNotSaved(t *testing.T) {
+	setupDuplicatePolicy(t, duplicatePolicyLatestModified)
+	//without the claim script, the owners can't be claimed
+	configStore := cm.NewMemoryProvider()
+	config.SetConfigProvider(configStore)
+
+	resolved, lost := resolveDuplicateDevices([]*model.RegisteredDeviceData{modifiedDevice("d1", "1", 10), modifiedDevice("d2", "2", 10)})
+	if len(resolved) != 0 || !reflect.DeepEqual(lost, []string{"d1", "d2"}) {
+		t.Errorf("resolved = %v lost = %v, want none resolved, lost [d1 d2]", resolvedIDs(resolved), lost)
+	}
+}
@@ -0,0 +1,241 @@
+package election
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/utils/logger"
+)
+
+//acquires the lock(when free) with a new term, returns the term or 0
+const acquireScript = `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	local term = redis.call('INCR', KEYS[2])
+	redis.call('SET', KEYS[1], ARGV[1] .. '|' .. term, 'PX', ARGV[2])
+	return term
+end
+return 0`
+
+//extends the lease, only by the current holder
+const renewScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`
+
+//releases the lock, only by the current holder
+const releaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+//Store redis operations used by the elector(replaced with fakes on tests)
+type Store interface {
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+	Get(key string) (string, error)
+}
+
+type configStore struct{}
+
+func (configStore) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return config.Eval(script, keys, args...)
+}
+
+func (configStore) Get(key string) (string, error) {
+	return config.Get(key)
+}
+
+//Leader current lease holder
+type Leader struct {
+	Identity string `json:"identity"`
+	Term     int64  `json:"term"`
+}
+
+//Elector campaigns for the leader lock and renews the lease while holding it
+type Elector struct {
+	store    Store
+	lockKey  string
+	termKey  string
+	identity string
+	lease    time.Duration
+
+	mu       sync.RWMutex
+	isLeader bool
+	term     int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+//NewElector lock keys are {name}:lock and {name}:term(same cluster slot)
+func NewElector(store Store, name string, identity string, lease time.Duration) *Elector {
+	if store == nil {
+		store = configStore{}
+	}
+
+	return &Elector{
+		store:    store,
+		lockKey:  fmt.Sprintf("{%s}:lock", name),
+		termKey:  fmt.Sprintf("{%s}:term", name),
+		identity: identity,
+		lease:    lease,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//DefaultIdentity hostname and process id
+func DefaultIdentity() string {
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostName, os.Getpid())
+}
+
+//Identity ...
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+//IsLeader whether this replica holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader
+}
+
+//Term of the held lease(0 when not the leader)
+func (e *Elector) Term() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.term
+}
+
+//Leader returns the current lease holder(empty when not held)
+func (e *Elector) Leader() (Leader, error) {
+	value, err := e.store.Get(e.lockKey)
+	if err != nil || value == "" {
+		return Leader{}, err
+	}
+	return parseLockValue(value), nil
+}
+
+//Run campaigns/renews every lease/3 until Stop, the lease is released on stop(fast failover)
+func (e *Elector) Run() {
+	defer close(e.done)
+
+	interval := e.lease / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.tick()
+
+		select {
+		case <-e.stop:
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Stop stops the campaign and releases the lease(if held)
+func (e *Elector) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *Elector) tick() {
+	if e.IsLeader() {
+		if e.renew() {
+			return
+		}
+
+		logger.Log().Warn(fmt.Sprintf("Leader Election lost leadership identity : %v, term : %v", e.identity, e.Term()))
+		e.setLeader(false, 0)
+	}
+
+	term, err := e.acquire()
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("Leader Election (Acquire) Error : %v", err.Error()))
+		return
+	}
+
+	if term > 0 {
+		e.setLeader(true, term)
+		logger.Log().Info(fmt.Sprintf("Leader Election elected identity : %v, term : %v", e.identity, term))
+	}
+}
+
+func (e *Elector) acquire() (int64, error) {
+	resp, err := e.store.Eval(acquireScript, []string{e.lockKey, e.termKey}, e.identity, e.lease.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(resp), nil
+}
+
+//renew extends the lease, false when the lease is lost/unknown
+func (e *Elector) renew() bool {
+	resp, err := e.store.Eval(renewScript, []string{e.lockKey}, e.lockValue(), e.lease.Milliseconds())
+	if err != nil {
+		//lease can't be confirmed, so stepping down(the lease may expire meanwhile)
+		logger.Log().Error(fmt.Sprintf("Leader Election (Renew) Error : %v", err.Error()))
+		return false
+	}
+	return toInt64(resp) == 1
+}
+
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+
+	if _, err := e.store.Eval(releaseScript, []string{e.lockKey}, e.lockValue()); err != nil {
+		logger.Log().Error(fmt.Sprintf("Leader Election (Release) Error : %v", err.Error()))
+	}
+
+	logger.Log().Info(fmt.Sprintf("Leader Election released identity : %v, term : %v", e.identity, e.Term()))
+	e.setLeader(false, 0)
+}
+
+func (e *Elector) setLeader(isLeader bool, term int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.isLeader, e.term = isLeader, term
+}
+
+func (e *Elector) lockValue() string {
+	return fmt.Sprintf("%s|%d", e.identity, e.Term())
+}
+
+//parseLockValue identity|term
+func parseLockValue(value string) Leader {
+	i := strings.LastIndex(value, "|")
+	if i < 0 {
+		return Leader{Identity: value}
+	}
+
+	term, _ := strconv.ParseInt(value[i+1:], 10, 64)
+	return Leader{Identity: value[:i], Term: term}
+}
+
+func toInt64(v interface{}) int64 {
+	switch t := v.(type) {
+	case int64:
+		return t
+	case string:
+		i, _ := strconv.ParseInt(t, 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,200 @@
+package election
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeStore in-memory lock/term keys, running the elector scripts like redis(expiry is done by the tests)
+type fakeStore struct {
+	mu     sync.Mutex
+	values map[string]string
+	err    error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{values: make(map[string]string)}
+}
+
+func (s *fakeStore) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	switch script {
+	case acquireScript:
+		if _, ok := s.values[keys[0]]; ok {
+			return int64(0), nil
+		}
+		term, _ := strconv.ParseInt(s.values[keys[1]], 10, 64)
+		term = term + 1
+		s.values[keys[1]] = strconv.FormatInt(term, 10)
+		s.values[keys[0]] = args[0].(string) + "|" + strconv.FormatInt(term, 10)
+		return term, nil
+	case renewScript:
+		if s.values[keys[0]] == args[0].(string) {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case releaseScript:
+		if s.values[keys[0]] == args[0].(string) {
+			delete(s.values, keys[0])
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	return nil, errors.New("unknown script")
+}
+
+func (s *fakeStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+//expire drops the lock, as if the lease expired
+func (s *fakeStore) expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func (s *fakeStore) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func assertLeader(t *testing.T, e *Elector, isLeader bool, term int64) {
+	t.Helper()
+	if e.IsLeader() != isLeader || e.Term() != term {
+		t.Errorf("%v leader = %v term = %v, want %v term %v", e.Identity(), e.IsLeader(), e.Term(), isLeader, term)
+	}
+}
+
+func TestElectorAcquire(t *testing.T) {
+	store := newFakeStore()
+	e1 := NewElector(store, "sync", "agent-1", 3*time.Second)
+	e2 := NewElector(store, "sync", "agent-2", 3*time.Second)
+
+	e1.tick()
+	e2.tick()
+
+	assertLeader(t, e1, true, 1)
+	assertLeader(t, e2, false, 0)
+
+	leader, err := e2.Leader()
+	if err != nil || leader != (Leader{Identity: "agent-1", Term: 1}) {
+		t.Errorf("leader = %v (%v), want agent-1 term 1", leader, err)
+	}
+	if got := store.values["{sync}:lock"]; got != "agent-1|1" {
+		t.Errorf("lock value = %v, want agent-1|1", got)
+	}
+}
+
+func TestElectorRenewKeepsTerm(t *testing.T) {
+	store := newFakeStore()
+	e := NewElector(store, "sync", "agent-1", 3*time.Second)
+
+	e.tick()
+	e.tick()
+	e.tick()
+
+	assertLeader(t, e, true, 1)
+	if got := store.values["{sync}:term"]; got != "1" {
+		t.Errorf("term key = %v, want 1", got)
+	}
+}
+
+func TestElectorLosesExpiredLease(t *testing.T) {
+	store := newFakeStore()
+	e1 := NewElector(store, "sync", "agent-1", 3*time.Second)
+	e2 := NewElector(store, "sync", "agent-2", 3*time.Second)
+
+	e1.tick()
+	store.expire("{sync}:lock")
+	e2.tick()
+
+	assertLeader(t, e2, true, 2)
+
+	//renew fails on the lock of the new leader
+	e1.tick()
+	assertLeader(t, e1, false, 0)
+	assertLeader(t, e2, true, 2)
+}
+
+func TestElectorStepsDownOnRenewError(t *testing.T) {
+	store := newFakeStore()
+	e := NewElector(store, "sync", "agent-1", 3*time.Second)
+
+	e.tick()
+	store.setErr(errors.New("connection refused"))
+	e.tick()
+	assertLeader(t, e, false, 0)
+
+	//re-elected with a new term once the lease expired
+	store.setErr(nil)
+	store.expire("{sync}:lock")
+	e.tick()
+	assertLeader(t, e, true, 2)
+}
+
+func TestElectorReleasesOnStop(t *testing.T) {
+	store := newFakeStore()
+	e1 := NewElector(store, "sync", "agent-1", 3*time.Second)
+	e2 := NewElector(store, "sync", "agent-2", 3*time.Second)
+
+	go e1.Run()
+
+	deadline := time.Now().Add(time.Second)
+	for !e1.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assertLeader(t, e1, true, 1)
+
+	e1.Stop()
+	assertLeader(t, e1, false, 0)
+	if leader, _ := e1.Leader(); leader != (Leader{}) {
+		t.Errorf("leader after stop = %v, want none", leader)
+	}
+
+	//standby takes over without waiting for the expiry
+	e2.tick()
+	assertLeader(t, e2, true, 2)
+}
+
+func TestElectorReleaseKeepsOtherLeaderLock(t *testing.T) {
+	store := newFakeStore()
+	e1 := NewElector(store, "sync", "agent-1", 3*time.Second)
+	e2 := NewElector(store, "sync", "agent-2", 3*time.Second)
+
+	e1.tick()
+	store.expire("{sync}:lock")
+	e2.tick()
+
+	//stale leader(not yet stepped down) must not delete the lock of the new term
+	e1.release()
+	if got := store.values["{sync}:lock"]; got != "agent-2|2" {
+		t.Errorf("lock value = %v, want agent-2|2", got)
+	}
+}
+
+func TestParseLockValue(t *testing.T) {
+	cases := map[string]Leader{
+		"agent-1|3":      {Identity: "agent-1", Term: 3},
+		"host|1234|12":   {Identity: "host|1234", Term: 12},
+		"agent-1":        {Identity: "agent-1"},
+		"agent-1|broken": {Identity: "agent-1"},
+	}
+	for value, want := range cases {
+		if got := parseLockValue(value); got != want {
+			t.Errorf("parseLockValue(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
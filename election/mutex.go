@@ -0,0 +1,84 @@
+package election
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"data-sync-agent/utils/logger"
+)
+
+//ErrLockTimeout indicates the mutex is held by another replica for longer than the wait timeout
+var ErrLockTimeout = errors.New("lock wait timeout")
+
+//acquires the lock(when free), returns 1 or 0
+const lockScript = `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`
+
+//Mutex distributed lock across the replicas, the ttl is extended while held
+type Mutex struct {
+	store Store
+	key   string
+	owner string
+	ttl   time.Duration
+}
+
+//NewMutex ...
+func NewMutex(store Store, key string, owner string, ttl time.Duration) *Mutex {
+	if store == nil {
+		store = configStore{}
+	}
+	return &Mutex{store: store, key: key, owner: owner, ttl: ttl}
+}
+
+//Lock waits up to the timeout for the lock, the returned func releases it
+func (m *Mutex) Lock(timeout time.Duration) (func(), error) {
+	token := fmt.Sprintf("%s|%s", m.owner, strconv.FormatInt(time.Now().UnixNano(), 36))
+	deadline := time.Now().Add(timeout)
+
+	for {
+		resp, err := m.store.Eval(lockScript, []string{m.key}, token, m.ttl.Milliseconds())
+		if err != nil {
+			return nil, err
+		}
+		if toInt64(resp) == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %v", ErrLockTimeout, m.key)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	//extending the ttl until released
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(m.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				resp, err := m.store.Eval(renewScript, []string{m.key}, token, m.ttl.Milliseconds())
+				if err != nil || toInt64(resp) != 1 {
+					logger.Log().Error(fmt.Sprintf("Mutex %v lease lost", m.key))
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		if _, err := m.store.Eval(releaseScript, []string{m.key}, token); err != nil {
+			logger.Log().Error(fmt.Sprintf("Mutex %v (Release) Error : %v", m.key, err.Error()))
+		}
+	}, nil
+}
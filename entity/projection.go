@@ -0,0 +1,166 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	model "data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//tenant placeholder of the per-tenant collection name
+const tenantPlaceholder = "{tenantuid}"
+
+//insert default value placeholders
+const (
+	defaultValueNow           = "$now"
+	defaultValueEpochObjectID = "$epochobjectid"
+)
+
+var (
+	projectionMu sync.RWMutex
+	projections  = map[string]*model.MongoProjection{
+		"recentupdates": defaultProjection(),
+	}
+)
+
+//defaultProjection the device projection used by the IOT listener
+func defaultProjection() *model.MongoProjection {
+	return &model.MongoProjection{
+		Name:       "recentupdates",
+		Collection: "tblvehiclerecentupdates",
+		Fields: map[string]string{
+			"providertenantuids": "providertenantuids", "tenantuid": "tenantuid",
+			"tenantgroupuid": "tenantgroupuid", "devicetypeid": "devicetypeid",
+			"communicationgroupid": "communicationgroupid",
+			"tenantname":           "tenantname", "vehicleid": "vehicleid",
+			"diversiondetails": "diversiondetails",
+		},
+		InsertDefaults: map[string]interface{}{
+			"createdon": defaultValueNow, "insertdatetime": defaultValueNow, "ignitionstatus": 0, "speed": 0, "latitude": 0, "longitude": 0, "recordstatus": 0, "devicedatetime": defaultValueNow, "mongoid": defaultValueEpochObjectID,
+		},
+	}
+}
+
+//LoadProjections registers the projections(name => projection json), over the default one..
+func LoadProjections(values map[string]string) {
+	projectionMu.Lock()
+	defer projectionMu.Unlock()
+
+	for name, v := range values {
+		p := &model.MongoProjection{}
+		if err := json.Unmarshal([]byte(v), p); err != nil {
+			logger.Log().Error(fmt.Sprintf("LoadProjections %v Unmarshal Error : %v", name, err.Error()))
+			continue
+		}
+
+		if p.Name == "" {
+			p.Name = name
+		}
+
+		if p.Disabled {
+			delete(projections, p.Name)
+			logger.Log().Info(fmt.Sprintf("Mongo projection disabled : %v", p.Name))
+			continue
+		}
+
+		if strings.TrimSpace(p.Collection) == "" || len(p.Fields) == 0 {
+			logger.Log().Error(fmt.Sprintf("LoadProjections %v collection/fields missing", name))
+			continue
+		}
+
+		projections[p.Name] = p
+		logger.Log().Info(fmt.Sprintf("Mongo projection registered : %v => %v", p.Name, p.Collection))
+	}
+}
+
+//Projections returns the registered projections ordered by name
+func Projections() []*model.MongoProjection {
+	projectionMu.RLock()
+	defer projectionMu.RUnlock()
+
+	resp := make([]*model.MongoProjection, 0, len(projections))
+	for _, p := range projections {
+		resp = append(resp, p)
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Name < resp[j].Name
+	})
+
+	return resp
+}
+
+//IsPerTenant whether the projection is written to a collection per tenant
+func IsPerTenant(p *model.MongoProjection) bool {
+	return strings.Contains(p.Collection, tenantPlaceholder)
+}
+
+//collectionName resolving the collection of the device
+func collectionName(p *model.MongoProjection, tenantUID string) string {
+	return strings.Replace(p.Collection, tenantPlaceholder, tenantUID, -1)
+}
+
+//ProjectedFields constructing the $set fields of the device
+func ProjectedFields(p *model.MongoProjection, d model.MongoDeviceData) bson.M {
+	source := deviceFieldValues(d)
+
+	resp := bson.M{}
+	for target, sourceField := range p.Fields {
+		if v, ok := source[sourceField]; ok {
+			resp[target] = v
+		}
+	}
+	return resp
+}
+
+//insertDefaults constructing the $setOnInsert fields
+func insertDefaults(p *model.MongoProjection) bson.M {
+	currentTime := time.Now().UTC()
+
+	resp := bson.M{}
+	for k, v := range p.InsertDefaults {
+		switch t := v.(type) {
+		case string:
+			if t == defaultValueNow {
+				resp[k] = currentTime
+			} else if t == defaultValueEpochObjectID {
+				resp[k] = primitive.NewObjectIDFromTimestamp(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))
+			} else {
+				resp[k] = t
+			}
+		case float64:
+			//json numbers, stored as int when integral
+			if t == math.Trunc(t) {
+				resp[k] = int(t)
+			} else {
+				resp[k] = t
+			}
+		default:
+			resp[k] = t
+		}
+	}
+	return resp
+}
+
+//deviceFieldValues source fields of the device, by json name
+func deviceFieldValues(d model.MongoDeviceData) map[string]interface{} {
+	return map[string]interface{}{
+		"tenantuid":            d.TenantUID,
+		"tenantgroupuid":       d.TenantGroupUID,
+		"providertenantuids":   d.ProviderTenantUIDs,
+		"devicetypeid":         d.DeviceTypeID,
+		"communicationgroupid": d.CommunicationGroupID,
+		"tenantname":           d.TenantName,
+		"vehicleid":            d.VehicleID,
+		"diversiondetails":     d.DiversionDetails,
+	}
+}
@@ -2,7 +2,12 @@ package entity
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"data-sync-agent/utils/logger"
@@ -16,18 +21,25 @@ import (
 	model "data-sync-agent/model"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 var storeProvider sm.Provider
 
-func init() {
-	InitializeStoreDataProvider()
+//BulkWriteSettings used for the device bulk writes
+type BulkWriteSettings struct {
+	ChunkSize int
+	Ordered   bool
+	Timeout   time.Duration
 }
 
-//InitializeStoreDataProvider ...a
-func InitializeStoreDataProvider() sm.Provider {
+var bulkSettings = BulkWriteSettings{ChunkSize: 1000, Ordered: false, Timeout: 30 * time.Second}
+
+//ErrStoreSecret indicates missing store server(mongo) connection details
+var ErrStoreSecret = errors.New("mongo server connection secret error")
+
+//InitializeStoreDataProvider initializing the store provider from env....(called by the app on startup)
+func InitializeStoreDataProvider() (sm.Provider, error) {
 
 	endPoint := helper.GetEnv(helper.MongoEndPoint)
 	userName := helper.GetEnv(helper.MongoUserName)
@@ -35,33 +47,160 @@ func InitializeStoreDataProvider() sm.Provider {
 	dbName := helper.GetEnv(helper.MongoDBName)
 	authDB := helper.GetEnv(helper.MongoAuthDB)
 
-
-	if nOK := helper.HasEmpty(endPoint,userName,password,dbName,authDB); nOK {
-		logger.Log().Fatal("Mongo Server Connection Secret Error")
-		return nil
+	if nOK := helper.HasEmpty(endPoint, userName, password, dbName, authDB); nOK {
+		return nil, ErrStoreSecret
 	}
 
-
 	mdProvider, err := sm.NewMongoProvider(sm.ConnRequest{
 		Endpoint:   endPoint,
 		UserName:   userName,
 		Password:   password,
 		DBName:     dbName,
 		AuthSource: authDB,
+
+		Scheme:     helper.GetEnv(helper.MongoScheme),
+		ReplicaSet: helper.GetEnv(helper.MongoReplicaSet),
+
+		TLSEnabled:     helper.GetEnv(helper.MongoTLSEnabled) == "1",
+		TLSCAFile:      helper.GetEnv(helper.MongoTLSCAFile),
+		TLSCertKeyFile: helper.GetEnv(helper.MongoTLSCertKeyFile),
+		TLSInsecure:    helper.GetEnv(helper.MongoTLSInsecure) == "1",
+
+		ReadPreference:          helper.GetEnv(helper.MongoReadPreference),
+		ReadConcern:             helper.GetEnv(helper.MongoReadConcern),
+		WriteConcern:            helper.GetEnv(helper.MongoWriteConcern),
+		WriteJournal:            helper.GetEnv(helper.MongoWriteJournal) == "1",
+		WriteConcernTimeout:     envSeconds(helper.MongoWriteConcernTimeoutInSec),
+		CollectionWriteConcerns: parseCollectionWriteConcerns(helper.GetEnv(helper.MongoCollectionWriteConcerns)),
+
+		ConnectTimeout:         envSeconds(helper.MongoConnectTimeoutInSec),
+		ServerSelectionTimeout: envSeconds(helper.MongoServerSelectionTimeoutInSec),
 	})
 
 	if err != nil {
 		logger.Log().With(zap.Error(err)).Error(fmt.Sprintf("Mongo Server Connection Error EndPoint %s", endPoint))
 
-		return nil
+		return nil, err
 	}
 
 	logger.Log().Info(fmt.Sprintf("Mongo Server started with EndPoint %s", endPoint))
 
+	loadBulkWriteSettings()
+
 	//assigning to global variable(must)
 	storeProvider = mdProvider
 
-	return mdProvider
+	return mdProvider, nil
+}
+
+//SetStoreProvider replacing the store provider(e.g. storeman.NewMemoryProvider() without mongo)
+func SetStoreProvider(provider sm.Provider) {
+	storeProvider = provider
+
+	indexedCollections.Lock()
+	indexedCollections.names = make(map[string]bool)
+	indexedCollections.Unlock()
+}
+
+//SetBulkWriteSettings replacing the bulk write settings(chunk size/ordered/timeout), invalid values are ignored
+func SetBulkWriteSettings(settings BulkWriteSettings) {
+	if settings.ChunkSize > 0 {
+		bulkSettings.ChunkSize = settings.ChunkSize
+	}
+	bulkSettings.Ordered = settings.Ordered
+	if settings.Timeout > 0 {
+		bulkSettings.Timeout = settings.Timeout
+	}
+}
+
+//envSeconds duration of the env value in seconds, 0 when not set/invalid
+func envSeconds(key string) time.Duration {
+	if v, err := strconv.Atoi(helper.GetEnv(key)); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return 0
+}
+
+//parseCollectionWriteConcerns collection:writeconcern pairs separated by comma(e.g. tblvehiclerecentupdates:majority,tbllog*:1)
+func parseCollectionWriteConcerns(value string) map[string]string {
+	resp := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			if strings.TrimSpace(pair) != "" {
+				logger.Log().Warn(fmt.Sprintf("Mongo collection write concern '%v' is invalid", pair))
+			}
+			continue
+		}
+		resp[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return resp
+}
+
+//loadBulkWriteSettings overriding the default bulk write settings from env...
+func loadBulkWriteSettings() {
+	if chunkSize, err := strconv.Atoi(helper.GetEnv(helper.MongoBulkChunkSize)); err == nil && chunkSize > 0 {
+		bulkSettings.ChunkSize = chunkSize
+	}
+
+	bulkSettings.Ordered = helper.GetEnv(helper.MongoBulkOrdered) == "1"
+
+	if timeoutInSec, err := strconv.Atoi(helper.GetEnv(helper.MongoBulkTimeoutInSec)); err == nil && timeoutInSec > 0 {
+		bulkSettings.Timeout = time.Duration(timeoutInSec) * time.Second
+	}
+
+	logger.Log().Info(fmt.Sprintf("Mongo Bulk Write ChunkSize: %v, Ordered: %v, Timeout: %v", bulkSettings.ChunkSize, bulkSettings.Ordered, bulkSettings.Timeout))
+}
+
+//device fields indexed on every projection collection(by source field)
+var indexedSourceFields = []string{"tenantuid", "tenantgroupuid", "communicationgroupid"}
+
+//collections already indexed by the agent(per-tenant collections are indexed on the first write)
+var indexedCollections = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+//EnsureIndexes creating the tenant/comm. group indexes of the projection collections, called on startup..
+func EnsureIndexes() {
+	for _, projection := range Projections() {
+		//per-tenant collections are not known yet
+		if IsPerTenant(projection) {
+			continue
+		}
+		ensureProjectionIndexes(projection, projection.Collection)
+	}
+}
+
+//ensureProjectionIndexes creating the indexes of the collection once per process..
+func ensureProjectionIndexes(projection *model.MongoProjection, name string) {
+	indexedCollections.Lock()
+	defer indexedCollections.Unlock()
+
+	if indexedCollections.names[name] {
+		return
+	}
+
+	fields := make([]string, 0, len(indexedSourceFields))
+	for _, sourceField := range indexedSourceFields {
+		for target, source := range projection.Fields {
+			if source == sourceField {
+				fields = append(fields, target)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	ctx, cancel := context.WithTimeout(context.Background(), bulkSettings.Timeout)
+	defer cancel()
+
+	if err := storeProvider.EnsureIndexes(ctx, name, fields); err != nil {
+		logger.Log().Error(fmt.Sprintf("EnsureIndexes %v Error : %v", name, err.Error()))
+		return
+	}
+
+	indexedCollections.names[name] = true
+	logger.Log().Info(fmt.Sprintf("Mongo indexes ensured : %v => %v", name, fields))
 }
 
 //public operation fns...
@@ -71,55 +210,143 @@ func Get(ctx context.Context, collectionName string, filter bson.M, findOptions
 	return storeProvider.Get(ctx, collectionName, filter, findOptions)
 }
 
-//BulkWrite ...
-func BulkWrite(collectionName string, requestActiveData []model.MongoDeviceData, requestInActiveData []model.MongoDeviceData) (int64, error) {
+//Watch opens the change stream of the collection...
+func Watch(ctx context.Context, collectionName string, pipeline interface{}, resumeToken bson.Raw) (sm.ChangeStream, error) {
+	return storeProvider.Watch(ctx, collectionName, pipeline, resumeToken)
+}
 
-	db, _ := storeProvider.GetDB()
-	collection := db.Collection(collectionName)
+//BulkWrite upserting active and deleting in-active device data of the projection, in chunks..
+//returns the written count and the device ids failed to write
+func BulkWrite(projection *model.MongoProjection, requestActiveData []model.MongoDeviceData, requestInActiveData []model.MongoDeviceData) (int64, []string, error) {
 
-	defaultColumnsToInsert := bson.M{"createdon": time.Now().UTC(), "insertdatetime": time.Now().UTC(), "ignitionstatus": 0, "speed": 0, "latitude": 0, "longitude": 0, "recordstatus": 0, "devicedatetime": time.Now().UTC(), "mongoid": primitive.NewObjectIDFromTimestamp(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))}
+	defaultColumnsToInsert := insertDefaults(projection)
 
-	var operations []mongo.WriteModel
+	//operations and respective device ids(same index), by collection
+	operations := make(map[string][]mongo.WriteModel)
+	operationIDs := make(map[string][]string)
 	for _, d := range requestActiveData {
 		operation := mongo.NewUpdateOneModel()
 		operation.SetFilter(bson.M{"_id": d.ID})
-		operation.SetUpdate(bson.M{"$set": bson.M{
-			"providertenantuids": d.ProviderTenantUIDs, "tenantuid": d.TenantUID,
-			"tenantgroupuid": d.TenantGroupUID, "devicetypeid": d.DeviceTypeID,
-			"communicationgroupid": d.CommunicationGroupID,
-			"tenantname":           d.TenantName, "vehicleid": d.VehicleID,
-			"diversiondetails": d.DiversionDetails},
-			"$setOnInsert": defaultColumnsToInsert,
-		})
+
+		update := bson.M{"$set": ProjectedFields(projection, d)}
+		if len(defaultColumnsToInsert) > 0 {
+			update["$setOnInsert"] = defaultColumnsToInsert
+		}
+		operation.SetUpdate(update)
 
 		// Set Upsert flag option to turn the update operation to upsert
 		operation.SetUpsert(true)
-		operations = append(operations, operation)
+
+		name := collectionName(projection, d.TenantUID)
+		operations[name] = append(operations[name], operation)
+		operationIDs[name] = append(operationIDs[name], d.ID)
 	}
 
 	for _, d := range requestInActiveData {
 		operation := mongo.NewDeleteOneModel()
 		operation.SetFilter(bson.M{"_id": d.ID})
-		operations = append(operations, operation)
+
+		name := collectionName(projection, d.TenantUID)
+		operations[name] = append(operations[name], operation)
+		operationIDs[name] = append(operationIDs[name], d.ID)
 	}
 
-	// Specify an option to turn the bulk insertion in order of operation
+	var lastErr error
+	rowCount := int64(0)
+	failedIDs := make([]string, 0)
+	for name, collectionOperations := range operations {
+		ensureProjectionIndexes(projection, name)
+
+		count, failed, err := bulkWriteInChunks(name, collectionOperations, operationIDs[name])
+		if err != nil {
+			lastErr = err
+		}
+		rowCount = rowCount + count
+		failedIDs = append(failedIDs, failed...)
+	}
+
+	return rowCount, failedIDs, lastErr
+}
+
+//bulkWriteInChunks executing the operations chunk by chunk, based on the bulk write settings..
+func bulkWriteInChunks(collectionName string, operations []mongo.WriteModel, operationIDs []string) (int64, []string, error) {
+	var lastErr error
+	rowCount := int64(0)
+	failedIDs := make([]string, 0)
+
+	// Specify an option to turn the bulk insertion in order of operation(or not)
 	bulkOption := options.BulkWriteOptions{}
-	bulkOption.SetOrdered(true)
-	result, err := collection.BulkWrite(context.TODO(), operations, &bulkOption)
-	if err != nil {
-		return 0, err
+	bulkOption.SetOrdered(bulkSettings.Ordered)
+
+	for start := 0; start < len(operations); start += bulkSettings.ChunkSize {
+		end := start + bulkSettings.ChunkSize
+		if end > len(operations) {
+			end = len(operations)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), bulkSettings.Timeout)
+		result, err := storeProvider.BulkWrite(ctx, collectionName, operations[start:end], &bulkOption)
+		cancel()
+
+		if result != nil {
+			rowCount = rowCount + result.InsertedCount + result.UpsertedCount + result.DeletedCount
+		}
+
+		if err == nil {
+			continue
+		}
+		lastErr = err
+
+		chunkFailedIDs := parseBulkWriteError(err, operationIDs[start:end], bulkSettings.Ordered)
+		failedIDs = append(failedIDs, chunkFailedIDs...)
+
+		logger.Log().Error(fmt.Sprintf("BulkWrite chunk [%v-%v] failed count : %v, Error : %v", start, end, len(chunkFailedIDs), err.Error()))
+
+		//ordered mode, rest of the chunks are not executed
+		if bulkSettings.Ordered {
+			failedIDs = append(failedIDs, operationIDs[end:]...)
+			break
+		}
+	}
+
+	return rowCount, failedIDs, lastErr
+}
+
+//parseBulkWriteError returns the device ids failed on the chunk..
+func parseBulkWriteError(err error, chunkIDs []string, ordered bool) []string {
+	bulkErr, ok := err.(mongo.BulkWriteException)
+	if !ok {
+		//network/timeout/etc, the whole chunk is considered as failed
+		return chunkIDs
+	}
+
+	//write concern not satisfied, none of the chunk writes are acknowledged
+	if bulkErr.WriteConcernError != nil {
+		return chunkIDs
+	}
+
+	failedIDs := make([]string, 0, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Index >= 0 && writeErr.Index < len(chunkIDs) {
+			failedIDs = append(failedIDs, chunkIDs[writeErr.Index])
+			logger.Log().Error(fmt.Sprintf("BulkWrite DeviceID: %v Code: %v Error : %v", chunkIDs[writeErr.Index], writeErr.Code, writeErr.Message))
+		}
+	}
+
+	//ordered mode stops on the first error, so all the following operations are not executed
+	if ordered && len(bulkErr.WriteErrors) > 0 {
+		lastIndex := bulkErr.WriteErrors[len(bulkErr.WriteErrors)-1].Index
+		if lastIndex+1 < len(chunkIDs) {
+			failedIDs = append(failedIDs, chunkIDs[lastIndex+1:]...)
+		}
 	}
 
-	return (result.InsertedCount + result.UpsertedCount + result.DeletedCount), nil
+	return failedIDs
 }
 
 //BulkWriteUpdate ...
 func BulkWriteUpdate(collectionName string, requestActiveData []model.MongoDeviceData) (int64, error) {
 
-	db, _ := storeProvider.GetDB()
-	collection := db.Collection(collectionName)
-
 	var operations []mongo.WriteModel
 	for _, d := range requestActiveData {
 		operation := mongo.NewUpdateOneModel()
@@ -132,7 +359,7 @@ func BulkWriteUpdate(collectionName string, requestActiveData []model.MongoDevic
 	// Specify an option to turn the bulk insertion in order of operation
 	bulkOption := options.BulkWriteOptions{}
 	bulkOption.SetOrdered(true)
-	result, err := collection.BulkWrite(context.TODO(), operations, &bulkOption)
+	result, err := storeProvider.BulkWrite(context.TODO(), collectionName, operations, &bulkOption)
 	if err != nil {
 		return 0, err
 	}
@@ -143,9 +370,6 @@ func BulkWriteUpdate(collectionName string, requestActiveData []model.MongoDevic
 //BulkWrite ...
 func BulkWriteUnAuth(collectionName string, requestActiveData []*model.UnAuthDeviceResponse) (int64, error) {
 
-	db, _ := storeProvider.GetDB()
-	collection := db.Collection(collectionName)
-
 	var operations []mongo.WriteModel
 	for _, d := range requestActiveData {
 		operation := mongo.NewUpdateOneModel()
@@ -163,7 +387,7 @@ func BulkWriteUnAuth(collectionName string, requestActiveData []*model.UnAuthDev
 	// Specify an option to turn the bulk insertion in order of operation
 	bulkOption := options.BulkWriteOptions{}
 	bulkOption.SetOrdered(true)
-	result, err := collection.BulkWrite(context.TODO(), operations, &bulkOption)
+	result, err := storeProvider.BulkWrite(context.TODO(), collectionName, operations, &bulkOption)
 	if err != nil {
 		return 0, err
 	}
@@ -171,7 +395,12 @@ func BulkWriteUnAuth(collectionName string, requestActiveData []*model.UnAuthDev
 	return result.ModifiedCount, nil
 }
 
-//DeleteMany ...
+//InsertMany ...
+func InsertMany(ctx context.Context, collectionName string, requestData []interface{}) ([]string, error) {
+	return storeProvider.InsertMany(ctx, collectionName, requestData)
+}
+
+//UpdateMany ...
 func UpdateMany(ctx context.Context, collectionName string, filter bson.M, options interface{}) (int64, error) {
 	return storeProvider.UpdateMany(ctx, collectionName, filter, options)
 }
@@ -0,0 +1,87 @@
+package storeman
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//ErrChangeStreamClosed indicates the fake change stream is closed
+var ErrChangeStreamClosed = errors.New("change stream closed")
+
+//FakeChangeStream -> in-memory change stream, events are pushed by the caller(used for testing the watchers)
+type FakeChangeStream struct {
+	events  chan bson.M
+	done    chan struct{}
+	current bson.Raw
+	token   bson.Raw
+	err     error
+	once    sync.Once
+}
+
+//NewFakeChangeStream ...
+func NewFakeChangeStream(bufferSize int) *FakeChangeStream {
+	return &FakeChangeStream{events: make(chan bson.M, bufferSize), done: make(chan struct{})}
+}
+
+//Push -> adds the change event(same shape as the mongo change event) to the stream, dropped once closed
+func (fcs *FakeChangeStream) Push(event bson.M) bool {
+	select {
+	case <-fcs.done:
+		return false
+	case fcs.events <- event:
+		return true
+	}
+}
+
+//Next -> waits for the next event, false when closed or ctx done
+func (fcs *FakeChangeStream) Next(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		fcs.err = ctx.Err()
+		return false
+	case <-fcs.done:
+		fcs.err = ErrChangeStreamClosed
+		return false
+	case event := <-fcs.events:
+		raw, err := bson.Marshal(event)
+		if err != nil {
+			fcs.err = err
+			return false
+		}
+
+		fcs.current = raw
+		if id, ok := event["_id"]; ok {
+			fcs.token, _ = bson.Marshal(bson.M{"_data": id})
+		}
+		return true
+	}
+}
+
+//Decode -> decodes the current event
+func (fcs *FakeChangeStream) Decode(val interface{}) error {
+	return bson.Unmarshal(fcs.current, val)
+}
+
+//ResumeToken -> token of the current event
+func (fcs *FakeChangeStream) ResumeToken() bson.Raw {
+	return fcs.token
+}
+
+//Err ...
+func (fcs *FakeChangeStream) Err() error {
+	if fcs.err == ErrChangeStreamClosed {
+		return nil
+	}
+	return fcs.err
+}
+
+//Close ...
+func (fcs *FakeChangeStream) Close(ctx context.Context) error {
+	fcs.once.Do(func() {
+		close(fcs.done)
+	})
+	return nil
+}
@@ -0,0 +1,543 @@
+package storeman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//ErrUnsupportedOperation indicates a filter/update/write model not handled by the memory provider
+var ErrUnsupportedOperation = errors.New("operation not supported by the memory provider")
+
+var _ Provider = (*MemoryProvider)(nil)
+
+//MemoryProvider -> in-memory document store(used for testing without mongo)
+//filters support equality, $in, $nin, $ne and $exists on top level fields,
+//updates support $set, $setOnInsert and $unset
+type MemoryProvider struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]bson.M
+	indexes     map[string]map[string]bool
+	streams     map[string][]*FakeChangeStream
+}
+
+//NewMemoryProvider ...
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		collections: make(map[string]map[string]bson.M),
+		indexes:     make(map[string]map[string]bool),
+		streams:     make(map[string][]*FakeChangeStream),
+	}
+}
+
+//Get -> documents of the collection matching the filter, ordered by _id
+func (mp *MemoryProvider) Get(ctx context.Context, collectionName string, filter bson.M, findOptions *options.FindOptions) ([]bson.M, error) {
+	normalizedFilter, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	result := make([]bson.M, 0)
+	for _, id := range mp.sortedIDs(collectionName) {
+		doc := mp.collections[collectionName][id]
+
+		ok, err := matches(doc, normalizedFilter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, copyDocument(doc))
+		}
+	}
+
+	if findOptions != nil {
+		if findOptions.Skip != nil {
+			skip := int(*findOptions.Skip)
+			if skip > len(result) {
+				skip = len(result)
+			}
+			result = result[skip:]
+		}
+		if findOptions.Limit != nil && *findOptions.Limit > 0 && int(*findOptions.Limit) < len(result) {
+			result = result[:*findOptions.Limit]
+		}
+	}
+
+	return result, nil
+}
+
+//InsertMany -> inserts the documents, _id is generated when missing
+func (mp *MemoryProvider) InsertMany(ctx context.Context, collectionName string, requestData []interface{}) ([]string, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	responseIDs := make([]string, 0, len(requestData))
+	for _, d := range requestData {
+		id, err := mp.insert(collectionName, d)
+		if err != nil {
+			return responseIDs, err
+		}
+		responseIDs = append(responseIDs, id)
+	}
+
+	return responseIDs, nil
+}
+
+//BulkWrite -> executes the write models, failed operations are reported as mongo.BulkWriteException
+func (mp *MemoryProvider) BulkWrite(ctx context.Context, collectionName string, operations []mongo.WriteModel, bulkOptions *options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	ordered := true
+	if bulkOptions != nil && bulkOptions.Ordered != nil {
+		ordered = *bulkOptions.Ordered
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	result := &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+	writeErrors := make([]mongo.BulkWriteError, 0)
+
+	for i, operation := range operations {
+		if err := mp.write(collectionName, int64(i), operation, result); err != nil {
+			writeErrors = append(writeErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: i, Message: err.Error()},
+				Request:    operation,
+			})
+
+			if ordered {
+				break
+			}
+		}
+	}
+
+	if len(writeErrors) > 0 {
+		return result, mongo.BulkWriteException{WriteErrors: writeErrors}
+	}
+	return result, nil
+}
+
+//EnsureIndexes -> records the indexed fields of the collection
+func (mp *MemoryProvider) EnsureIndexes(ctx context.Context, collectionName string, fields []string) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.indexes[collectionName] == nil {
+		mp.indexes[collectionName] = make(map[string]bool)
+	}
+	for _, field := range fields {
+		mp.indexes[collectionName][field] = true
+	}
+	return nil
+}
+
+//Indexes -> indexed fields of the collection, sorted
+func (mp *MemoryProvider) Indexes(collectionName string) []string {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	fields := make([]string, 0, len(mp.indexes[collectionName]))
+	for field := range mp.indexes[collectionName] {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//UpdateMany -> applies the update on all the documents matching the filter
+func (mp *MemoryProvider) UpdateMany(ctx context.Context, collectionName string, requestData interface{}, options interface{}) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	result := &mongo.BulkWriteResult{}
+	err := mp.write(collectionName, 0, mongo.NewUpdateManyModel().SetFilter(requestData).SetUpdate(options), result)
+	return result.ModifiedCount, err
+}
+
+//DeleteMany -> deletes all the documents matching the filter
+func (mp *MemoryProvider) DeleteMany(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	result := &mongo.BulkWriteResult{}
+	err := mp.write(collectionName, 0, mongo.NewDeleteManyModel().SetFilter(filter), result)
+	return result.DeletedCount, err
+}
+
+//Watch -> fake change stream of the collection, receives the insert/update/replace/delete events of the provider
+func (mp *MemoryProvider) Watch(ctx context.Context, collectionName string, pipeline interface{}, resumeToken bson.Raw) (ChangeStream, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	stream := NewFakeChangeStream(1000)
+	mp.streams[collectionName] = append(mp.streams[collectionName], stream)
+	return stream, nil
+}
+
+//Close -> closes the change streams
+func (mp *MemoryProvider) Close(ctx context.Context) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, streams := range mp.streams {
+		for _, stream := range streams {
+			stream.Close(ctx)
+		}
+	}
+	mp.streams = make(map[string][]*FakeChangeStream)
+	return nil
+}
+
+//write executes a single write model(lock must be held)
+func (mp *MemoryProvider) write(collectionName string, index int64, operation mongo.WriteModel, result *mongo.BulkWriteResult) error {
+	switch m := operation.(type) {
+	case *mongo.InsertOneModel:
+		if _, err := mp.insert(collectionName, m.Document); err != nil {
+			return err
+		}
+		result.InsertedCount++
+
+	case *mongo.UpdateOneModel:
+		return mp.update(collectionName, index, m.Filter, m.Update, m.Upsert, false, false, result)
+
+	case *mongo.UpdateManyModel:
+		return mp.update(collectionName, index, m.Filter, m.Update, m.Upsert, true, false, result)
+
+	case *mongo.ReplaceOneModel:
+		return mp.update(collectionName, index, m.Filter, m.Replacement, m.Upsert, false, true, result)
+
+	case *mongo.DeleteOneModel:
+		count, err := mp.delete(collectionName, m.Filter, false)
+		result.DeletedCount += count
+		return err
+
+	case *mongo.DeleteManyModel:
+		count, err := mp.delete(collectionName, m.Filter, true)
+		result.DeletedCount += count
+		return err
+
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedOperation, operation)
+	}
+
+	return nil
+}
+
+func (mp *MemoryProvider) insert(collectionName string, document interface{}) (string, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	id := documentID(doc["_id"])
+	if _, exists := mp.collections[collectionName][id]; exists {
+		return "", fmt.Errorf("duplicate key _id: %v", id)
+	}
+
+	mp.collection(collectionName)[id] = doc
+	mp.emit(collectionName, bson.M{"operationType": "insert", "documentKey": bson.M{"_id": doc["_id"]}, "fullDocument": copyDocument(doc)})
+
+	return id, nil
+}
+
+func (mp *MemoryProvider) update(collectionName string, index int64, filter, update interface{}, upsert *bool, many, replace bool, result *mongo.BulkWriteResult) error {
+	normalizedFilter, err := toDocument(filter)
+	if err != nil {
+		return err
+	}
+	normalizedUpdate, err := toDocument(update)
+	if err != nil {
+		return err
+	}
+
+	matched := 0
+	for _, id := range mp.sortedIDs(collectionName) {
+		doc := mp.collections[collectionName][id]
+
+		ok, err := matches(doc, normalizedFilter)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		updated, err := applyUpdate(doc, normalizedUpdate, replace, false)
+		if err != nil {
+			return err
+		}
+
+		matched++
+		result.MatchedCount++
+		if !reflect.DeepEqual(doc, updated) {
+			result.ModifiedCount++
+			mp.collections[collectionName][id] = updated
+			mp.emitUpdate(collectionName, doc, updated, replace)
+		}
+
+		if !many {
+			break
+		}
+	}
+
+	if matched > 0 || upsert == nil || !*upsert {
+		return nil
+	}
+
+	//upsert, new document from the equality fields of the filter
+	seed := bson.M{}
+	for k, v := range normalizedFilter {
+		if _, isOperator := v.(bson.M); !isOperator && !strings.HasPrefix(k, "$") {
+			seed[k] = v
+		}
+	}
+
+	doc, err := applyUpdate(seed, normalizedUpdate, replace, true)
+	if err != nil {
+		return err
+	}
+	if replace {
+		if v, ok := seed["_id"]; ok {
+			doc["_id"] = v
+		}
+	}
+
+	if _, err := mp.insert(collectionName, doc); err != nil {
+		return err
+	}
+
+	result.UpsertedCount++
+	if result.UpsertedIDs != nil {
+		result.UpsertedIDs[index] = doc["_id"]
+	}
+	return nil
+}
+
+func (mp *MemoryProvider) delete(collectionName string, filter interface{}, many bool) (int64, error) {
+	normalizedFilter, err := toDocument(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(0)
+	for _, id := range mp.sortedIDs(collectionName) {
+		doc := mp.collections[collectionName][id]
+
+		ok, err := matches(doc, normalizedFilter)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			continue
+		}
+
+		delete(mp.collections[collectionName], id)
+		mp.emit(collectionName, bson.M{"operationType": "delete", "documentKey": bson.M{"_id": doc["_id"]}})
+
+		count++
+		if !many {
+			break
+		}
+	}
+
+	return count, nil
+}
+
+func (mp *MemoryProvider) collection(collectionName string) map[string]bson.M {
+	if mp.collections[collectionName] == nil {
+		mp.collections[collectionName] = make(map[string]bson.M)
+	}
+	return mp.collections[collectionName]
+}
+
+func (mp *MemoryProvider) sortedIDs(collectionName string) []string {
+	ids := make([]string, 0, len(mp.collections[collectionName]))
+	for id := range mp.collections[collectionName] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//emitUpdate publishes the update(changed/removed fields) or replace event
+func (mp *MemoryProvider) emitUpdate(collectionName string, before, after bson.M, replace bool) {
+	event := bson.M{"documentKey": bson.M{"_id": after["_id"]}, "fullDocument": copyDocument(after)}
+	if replace {
+		event["operationType"] = "replace"
+		mp.emit(collectionName, event)
+		return
+	}
+
+	updatedFields := bson.M{}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			updatedFields[k] = v
+		}
+	}
+	removedFields := bson.A{}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			removedFields = append(removedFields, k)
+		}
+	}
+
+	event["operationType"] = "update"
+	event["updateDescription"] = bson.M{"updatedFields": updatedFields, "removedFields": removedFields}
+	mp.emit(collectionName, event)
+}
+
+//emit publishes the event to the open streams of the collection, full/closed streams are skipped
+func (mp *MemoryProvider) emit(collectionName string, event bson.M) {
+	streams := mp.streams[collectionName]
+	if len(streams) == 0 {
+		return
+	}
+
+	event["_id"] = primitive.NewObjectID().Hex()
+	for _, stream := range streams {
+		select {
+		case <-stream.done:
+		case stream.events <- event:
+		default:
+		}
+	}
+}
+
+//toDocument converts the filter/update/document(struct, bson.M, bson.D) into bson.M with bson value types
+func toDocument(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func copyDocument(doc bson.M) bson.M {
+	resp, _ := toDocument(doc)
+	return resp
+}
+
+func documentID(v interface{}) string {
+	if objectID, ok := v.(primitive.ObjectID); ok {
+		return objectID.Hex()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+//matches whether the document satisfies the filter
+func matches(doc, filter bson.M) (bool, error) {
+	for field, condition := range filter {
+		if strings.HasPrefix(field, "$") {
+			return false, fmt.Errorf("%w: filter %v", ErrUnsupportedOperation, field)
+		}
+
+		value, exists := doc[field]
+
+		operators, isOperator := condition.(bson.M)
+		if !isOperator {
+			if !exists || !reflect.DeepEqual(value, condition) {
+				return false, nil
+			}
+			continue
+		}
+
+		for operator, operand := range operators {
+			ok, err := matchOperator(operator, operand, value, exists)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func matchOperator(operator string, operand, value interface{}, exists bool) (bool, error) {
+	switch operator {
+	case "$ne":
+		return !exists || !reflect.DeepEqual(value, operand), nil
+	case "$exists":
+		want, _ := operand.(bool)
+		return exists == want, nil
+	case "$in", "$nin":
+		values, ok := operand.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%w: %v requires an array", ErrUnsupportedOperation, operator)
+		}
+
+		found := false
+		for _, v := range values {
+			if exists && reflect.DeepEqual(value, v) {
+				found = true
+				break
+			}
+		}
+		return found == (operator == "$in"), nil
+	default:
+		return false, fmt.Errorf("%w: filter %v", ErrUnsupportedOperation, operator)
+	}
+}
+
+//applyUpdate returns the updated copy of the document
+func applyUpdate(doc, update bson.M, replace, inserting bool) (bson.M, error) {
+	if replace {
+		resp := copyDocument(update)
+		if id, ok := doc["_id"]; ok {
+			resp["_id"] = id
+		}
+		return resp, nil
+	}
+
+	resp := copyDocument(doc)
+	for operator, v := range update {
+		fields, ok := v.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("%w: update %v", ErrUnsupportedOperation, operator)
+		}
+
+		switch operator {
+		case "$set":
+			for k, fv := range fields {
+				resp[k] = fv
+			}
+		case "$setOnInsert":
+			if !inserting {
+				continue
+			}
+			for k, fv := range fields {
+				resp[k] = fv
+			}
+		case "$unset":
+			for k := range fields {
+				delete(resp, k)
+			}
+		default:
+			return nil, fmt.Errorf("%w: update %v", ErrUnsupportedOperation, operator)
+		}
+	}
+
+	return resp, nil
+}
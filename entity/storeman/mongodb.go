@@ -2,7 +2,11 @@ package storeman
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,16 +15,37 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+//connection schemes
+const (
+	SchemeStandard = "mongodb"
+	SchemeSRV      = "mongodb+srv"
 )
 
 type (
 
-	//Provider ...
+	//Provider -> document store operations used by the agent(mongo, in-memory)
 	Provider interface {
-		GetDB() (*mongo.Database, error)
 		Get(ctx context.Context, collectionName string, filter bson.M, findOptions *options.FindOptions) ([]bson.M, error)
+		InsertMany(ctx context.Context, collectionName string, requestData []interface{}) ([]string, error)
+		BulkWrite(ctx context.Context, collectionName string, operations []mongo.WriteModel, bulkOptions *options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+		EnsureIndexes(ctx context.Context, collectionName string, fields []string) error
 		UpdateMany(ctx context.Context, collectionName string, requestData interface{}, options interface{}) (int64, error)
 		DeleteMany(ctx context.Context, collectionName string, filter interface{}) (int64, error)
+		Watch(ctx context.Context, collectionName string, pipeline interface{}, resumeToken bson.Raw) (ChangeStream, error)
+		Close(ctx context.Context) error
+	}
+
+	//ChangeStream -> change stream of the collection(*mongo.ChangeStream)
+	ChangeStream interface {
+		Next(ctx context.Context) bool
+		Decode(val interface{}) error
+		ResumeToken() bson.Raw
+		Err() error
 		Close(ctx context.Context) error
 	}
 
@@ -31,33 +56,64 @@ type (
 		Password   string
 		AuthSource string
 		DBName     string
+
+		//mongodb(default) or mongodb+srv
+		Scheme     string
+		ReplicaSet string
+
+		//TLS, CertKeyFile is the PEM file with the client certificate and the private key
+		TLSEnabled     bool
+		TLSCAFile      string
+		TLSCertKeyFile string
+		TLSInsecure    bool
+
+		//primary, primaryPreferred, secondary, secondaryPreferred, nearest
+		ReadPreference string
+		//local, majority, available, linearizable, snapshot
+		ReadConcern string
+		//majority, number of nodes or tag set name
+		WriteConcern        string
+		WriteJournal        bool
+		WriteConcernTimeout time.Duration
+		//write concern by collection name(name* matches the prefix)
+		CollectionWriteConcerns map[string]string
+
+		ConnectTimeout         time.Duration
+		ServerSelectionTimeout time.Duration
 	}
 
 	//MongoProvider ->  store client
 	MongoProvider struct {
 		client *mongo.Client
 		db     *mongo.Database
+
+		collectionWriteConcerns map[string]*writeconcern.WriteConcern
 	}
 )
 
 //NewMongoProvider ...
 func NewMongoProvider(request ConnRequest) (Provider, error) {
-	//create empty context
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// auth credentials
-	credential := options.Credential{
-		Username: request.UserName,
-		Password: request.Password,
+	clientOptions, err := buildClientOptions(request)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(strings.TrimSpace(request.AuthSource)) > 0 {
-		credential.AuthSource = request.AuthSource
+	collectionWriteConcerns := make(map[string]*writeconcern.WriteConcern)
+	for name, spec := range request.CollectionWriteConcerns {
+		wc, err := parseWriteConcern(spec, request.WriteJournal, request.WriteConcernTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("collection %v: %w", name, err)
+		}
+		collectionWriteConcerns[name] = wc
 	}
 
-	// Set client options
-	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s", request.Endpoint)).SetAuth(credential)
+	//create empty context
+	connectTimeout := 10 * time.Second
+	if request.ConnectTimeout > connectTimeout {
+		connectTimeout = request.ConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
 
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOptions)
@@ -73,11 +129,167 @@ func NewMongoProvider(request ConnRequest) (Provider, error) {
 
 	// returning mongo client...
 	return &MongoProvider{
-		client: client,
-		db:     client.Database(request.DBName),
+		client:                  client,
+		db:                      client.Database(request.DBName),
+		collectionWriteConcerns: collectionWriteConcerns,
 	}, nil
 }
 
+//buildClientOptions constructing the client options of the request
+func buildClientOptions(request ConnRequest) (*options.ClientOptions, error) {
+
+	// auth credentials
+	credential := options.Credential{
+		Username: request.UserName,
+		Password: request.Password,
+	}
+
+	if len(strings.TrimSpace(request.AuthSource)) > 0 {
+		credential.AuthSource = request.AuthSource
+	}
+
+	scheme := strings.ToLower(strings.TrimSpace(request.Scheme))
+	if scheme == "" {
+		scheme = SchemeStandard
+	}
+	if scheme != SchemeStandard && scheme != SchemeSRV {
+		return nil, fmt.Errorf("unknown mongo scheme '%v'", request.Scheme)
+	}
+
+	// Set client options
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("%s://%s", scheme, request.Endpoint)).SetAuth(credential)
+
+	if len(strings.TrimSpace(request.ReplicaSet)) > 0 {
+		clientOptions.SetReplicaSet(request.ReplicaSet)
+	}
+
+	if request.TLSEnabled {
+		tlsConfig, err := tlsConfig(request)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if len(strings.TrimSpace(request.ReadPreference)) > 0 {
+		mode, err := readpref.ModeFromString(strings.TrimSpace(request.ReadPreference))
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(rp)
+	}
+
+	if len(strings.TrimSpace(request.ReadConcern)) > 0 {
+		clientOptions.SetReadConcern(readconcern.New(readconcern.Level(strings.TrimSpace(request.ReadConcern))))
+	}
+
+	if len(strings.TrimSpace(request.WriteConcern)) > 0 || request.WriteJournal || request.WriteConcernTimeout > 0 {
+		wc, err := parseWriteConcern(request.WriteConcern, request.WriteJournal, request.WriteConcernTimeout)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetWriteConcern(wc)
+	}
+
+	if request.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(request.ConnectTimeout)
+	}
+	if request.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(request.ServerSelectionTimeout)
+	}
+
+	return clientOptions, nil
+}
+
+//tlsConfig loading the CA and the client certificate(if any)
+func tlsConfig(request ConnRequest) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: request.TLSInsecure}
+
+	if len(request.TLSCAFile) > 0 {
+		caData, err := ioutil.ReadFile(request.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no CA certificate found on %v", request.TLSCAFile)
+		}
+	}
+
+	if len(request.TLSCertKeyFile) > 0 {
+		keyPairData, err := ioutil.ReadFile(request.TLSCertKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair(keyPairData, keyPairData)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+//parseWriteConcern majority, number of nodes or tag set name
+func parseWriteConcern(spec string, journal bool, timeout time.Duration) (*writeconcern.WriteConcern, error) {
+	wcOptions := make([]writeconcern.Option, 0, 3)
+
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+	case strings.EqualFold(spec, "majority"):
+		wcOptions = append(wcOptions, writeconcern.WMajority())
+	default:
+		if w, err := strconv.Atoi(spec); err == nil {
+			if w < 0 {
+				return nil, fmt.Errorf("invalid write concern '%v'", spec)
+			}
+			wcOptions = append(wcOptions, writeconcern.W(w))
+		} else {
+			wcOptions = append(wcOptions, writeconcern.WTagSet(spec))
+		}
+	}
+
+	if journal {
+		wcOptions = append(wcOptions, writeconcern.J(true))
+	}
+	if timeout > 0 {
+		wcOptions = append(wcOptions, writeconcern.WTimeout(timeout))
+	}
+
+	return writeconcern.New(wcOptions...), nil
+}
+
+//collection returns the collection with its configured write concern(if any)
+func (mdbp *MongoProvider) collection(collectionName string) *mongo.Collection {
+	if wc, ok := mdbp.collectionWriteConcerns[collectionName]; ok {
+		return mdbp.db.Collection(collectionName, options.Collection().SetWriteConcern(wc))
+	}
+
+	//prefix match, longest first
+	var matched *writeconcern.WriteConcern
+	matchedLen := -1
+	for name, wc := range mdbp.collectionWriteConcerns {
+		prefix := strings.TrimSuffix(name, "*")
+		if prefix != name && strings.HasPrefix(collectionName, prefix) && len(prefix) > matchedLen {
+			matched, matchedLen = wc, len(prefix)
+		}
+	}
+	if matched != nil {
+		return mdbp.db.Collection(collectionName, options.Collection().SetWriteConcern(matched))
+	}
+
+	return mdbp.db.Collection(collectionName)
+}
+
+//GetDB -> underlying mongo database(not part of the provider)
 func (mdbp *MongoProvider) GetDB() (*mongo.Database, error) {
 	//returning the response back...
 	return mdbp.db, nil
@@ -108,23 +320,47 @@ func (mdbp *MongoProvider) Get(ctx context.Context, collectionName string, filte
 //InsertMany ...
 func (mdbp *MongoProvider) InsertMany(ctx context.Context, collectionName string, requestData []interface{}) ([]string, error) {
 
-	resp, err := mdbp.db.Collection(collectionName).InsertMany(ctx, requestData)
+	resp, err := mdbp.collection(collectionName).InsertMany(ctx, requestData)
 	if err != nil {
 		return nil, err
 	}
 
 	responseIDs := make([]string, 0)
 	for _, idObj := range resp.InsertedIDs {
-		responseIDs = append(responseIDs, idObj.(primitive.ObjectID).Hex())
+		if objectID, ok := idObj.(primitive.ObjectID); ok {
+			responseIDs = append(responseIDs, objectID.Hex())
+		} else {
+			responseIDs = append(responseIDs, fmt.Sprintf("%v", idObj))
+		}
 	}
 
 	return responseIDs, nil
 }
 
-//InsertMany ...
+//BulkWrite -> executes the write operations on the collection
+func (mdbp *MongoProvider) BulkWrite(ctx context.Context, collectionName string, operations []mongo.WriteModel, bulkOptions *options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	return mdbp.collection(collectionName).BulkWrite(ctx, operations, bulkOptions)
+}
+
+//EnsureIndexes -> creates the ascending single field indexes(if not exists)
+func (mdbp *MongoProvider) EnsureIndexes(ctx context.Context, collectionName string, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.IndexModel, 0, len(fields))
+	for _, field := range fields {
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}})
+	}
+
+	_, err := mdbp.collection(collectionName).Indexes().CreateMany(ctx, models)
+	return err
+}
+
+//UpdateMany ...
 func (mdbp *MongoProvider) UpdateMany(ctx context.Context, collectionName string, requestData interface{}, options interface{}) (int64, error) {
 
-	resp, err := mdbp.db.Collection(collectionName).UpdateMany(ctx, requestData, options)
+	resp, err := mdbp.collection(collectionName).UpdateMany(ctx, requestData, options)
 	if err != nil {
 		return 0, err
 	}
@@ -134,7 +370,7 @@ func (mdbp *MongoProvider) UpdateMany(ctx context.Context, collectionName string
 
 //DeleteMany ...
 func (mdbp *MongoProvider) DeleteMany(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
-	resp, err := mdbp.db.Collection(collectionName).DeleteMany(ctx, filter)
+	resp, err := mdbp.collection(collectionName).DeleteMany(ctx, filter)
 	if err != nil {
 		return 0, err
 	}
@@ -142,6 +378,16 @@ func (mdbp *MongoProvider) DeleteMany(ctx context.Context, collectionName string
 	return resp.DeletedCount, nil
 }
 
+//Watch -> opens the change stream of the collection, resumed after the token(if any)
+func (mdbp *MongoProvider) Watch(ctx context.Context, collectionName string, pipeline interface{}, resumeToken bson.Raw) (ChangeStream, error) {
+	streamOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(resumeToken) > 0 {
+		streamOptions.SetResumeAfter(resumeToken)
+	}
+
+	return mdbp.collection(collectionName).Watch(ctx, pipeline, streamOptions)
+}
+
 //Close -> used to close the connection from mongo
 func (mdbp *MongoProvider) Close(ctx context.Context) error {
 	return mdbp.client.Disconnect(ctx)
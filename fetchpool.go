@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+//FetchPoolSettings used for fetching the data from the sql servers
+type FetchPoolSettings struct {
+	//servers fetched at the same time
+	Concurrency int
+	//max. time of the queries of a single server
+	QueryTimeout time.Duration
+	//max. time of the whole fetch, servers not responded are synced on the next cycle
+	CycleDeadline time.Duration
+}
+
+//FetchPoolMetrics of the last cycle
+type FetchPoolMetrics struct {
+	Workers      int           `json:"workers"`
+	Tasks        int           `json:"tasks"`
+	Completed    int           `json:"completed"`
+	TimedOut     []string      `json:"timedout"`
+	Skipped      []string      `json:"skipped"`
+	Unhealthy    []string      `json:"unhealthy"`
+	Unvalidated  []string      `json:"unvalidated"`
+	MaxQueueWait time.Duration `json:"maxqueuewait"`
+	AvgQueueWait time.Duration `json:"avgqueuewait"`
+	MaxFetchTime time.Duration `json:"maxfetchtime"`
+	SlowestTask  string        `json:"slowesttask"`
+	CycleTime    time.Duration `json:"cycletime"`
+}
+
+var fetchPoolSettings = FetchPoolSettings{Concurrency: 8, QueryTimeout: 2 * time.Minute, CycleDeadline: 5 * time.Minute}
+
+var fetchPoolMetrics = struct {
+	sync.Mutex
+	last FetchPoolMetrics
+}{}
+
+//loadFetchPoolSettings overriding the default fetch settings from env...
+func loadFetchPoolSettings() {
+	if concurrency, err := strconv.Atoi(helper.GetEnv(helper.SQLFetchConcurrency)); err == nil && concurrency > 0 {
+		fetchPoolSettings.Concurrency = concurrency
+	}
+	if timeoutInSec, err := strconv.Atoi(helper.GetEnv(helper.SQLQueryTimeoutInSec)); err == nil && timeoutInSec > 0 {
+		fetchPoolSettings.QueryTimeout = time.Duration(timeoutInSec) * time.Second
+	}
+	if deadlineInSec, err := strconv.Atoi(helper.GetEnv(helper.SyncCycleDeadlineInSec)); err == nil && deadlineInSec > 0 {
+		fetchPoolSettings.CycleDeadline = time.Duration(deadlineInSec) * time.Second
+	}
+
+	logger.Log().Info(fmt.Sprintf("SQL Fetch Pool Concurrency: %v, QueryTimeout: %v, CycleDeadline: %v", fetchPoolSettings.Concurrency, fetchPoolSettings.QueryTimeout, fetchPoolSettings.CycleDeadline))
+}
+
+//lastFetchPoolMetrics metrics of the last cycle
+func lastFetchPoolMetrics() FetchPoolMetrics {
+	fetchPoolMetrics.Lock()
+	defer fetchPoolMetrics.Unlock()
+	return fetchPoolMetrics.last
+}
+
+//queuedTask task waiting for a worker
+type queuedTask struct {
+	task     *model.SQLConnectionData
+	queuedOn time.Time
+}
+
+//startWorker fetching the servers through a bounded pool of workers, blocks until all the tasks are done/skipped
+func startWorker(ctx context.Context, taskList []*model.SQLConnectionData, workerResponseNotifyChan chan<- WorkerResponse) {
+	cycleStartedOn := time.Now()
+
+	workers := fetchPoolSettings.Concurrency
+	if workers > len(taskList) {
+		workers = len(taskList)
+	}
+
+	queue := make(chan queuedTask, len(taskList))
+	for _, task := range taskList {
+		queue <- queuedTask{task: task, queuedOn: time.Now()}
+	}
+	close(queue)
+
+	var mu sync.Mutex
+	metrics := FetchPoolMetrics{Workers: workers, Tasks: len(taskList), TimedOut: make([]string, 0), Skipped: make([]string, 0), Unhealthy: make([]string, 0), Unvalidated: make([]string, 0)}
+	totalQueueWait := time.Duration(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for qt := range queue {
+				queueWait := time.Since(qt.queuedOn)
+
+				//cycle deadline reached, rest of the queue is skipped
+				if ctx.Err() != nil {
+					mu.Lock()
+					metrics.Skipped = append(metrics.Skipped, qt.task.ServerID)
+					mu.Unlock()
+					continue
+				}
+
+				//unhealthy servers are re-pinged, still unreachable ones are left out of the cycle
+				if !qt.task.Healthy() && !sourcedriver.PingServer(ctx, qt.task) {
+					mu.Lock()
+					metrics.Unhealthy = append(metrics.Unhealthy, qt.task.ServerID)
+					mu.Unlock()
+					continue
+				}
+
+				//servers unreachable at startup are validated before their first fetch
+				if err := sourcedriver.EnsureSchemaValidated(ctx, qt.task); err != nil {
+					logger.Log().Error(fmt.Sprintf("startWorker ValidateSchema Server=%v Error : %v", qt.task.ServerID, err.Error()))
+					mu.Lock()
+					metrics.Unvalidated = append(metrics.Unvalidated, qt.task.ServerID)
+					mu.Unlock()
+					continue
+				}
+
+				startedOn := time.Now()
+				timedOut := startTask(ctx, qt.task, workerResponseNotifyChan)
+				fetchTime := time.Since(startedOn)
+
+				mu.Lock()
+				totalQueueWait += queueWait
+				if queueWait > metrics.MaxQueueWait {
+					metrics.MaxQueueWait = queueWait
+				}
+				if fetchTime > metrics.MaxFetchTime {
+					metrics.MaxFetchTime, metrics.SlowestTask = fetchTime, qt.task.ServerID
+				}
+				if timedOut {
+					metrics.TimedOut = append(metrics.TimedOut, qt.task.ServerID)
+				} else {
+					metrics.Completed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	//closing chan..
+	close(workerResponseNotifyChan)
+
+	started := metrics.Completed + len(metrics.TimedOut)
+	if started > 0 {
+		metrics.AvgQueueWait = totalQueueWait / time.Duration(started)
+	}
+	metrics.CycleTime = time.Since(cycleStartedOn)
+
+	fetchPoolMetrics.Lock()
+	fetchPoolMetrics.last = metrics
+	fetchPoolMetrics.Unlock()
+
+	logger.Log().Info(fmt.Sprintf("FETCH POOL workers: %v, tasks: %v, completed: %v, timedout: %v, skipped: %v, unhealthy: %v, unvalidated: %v, queuewait(avg/max): %v/%v, slowest: %v(%v), cycle: %v",
+		metrics.Workers, metrics.Tasks, metrics.Completed, metrics.TimedOut, metrics.Skipped, metrics.Unhealthy, metrics.Unvalidated, metrics.AvgQueueWait, metrics.MaxQueueWait, metrics.SlowestTask, metrics.MaxFetchTime, metrics.CycleTime))
+}
+
+//startTask fetching the device and spatial data of the server, timed out servers are not reported(synced on the next cycle)
+func startTask(ctx context.Context, task *model.SQLConnectionData, workerResponseNotifyChan chan<- WorkerResponse) bool {
+	taskCtx, cancel := context.WithTimeout(ctx, fetchPoolSettings.QueryTimeout)
+	defer cancel()
+
+	capabilities := task.Capabilities()
+
+	//getting device data(zero fetch date => sync date not updated)
+	regDeviceData := &model.RegisteredDeviceRequestData{RegisteredDeviceData: make([]*model.RegisteredDeviceData, 0)}
+	if capabilities.DeviceSync && len(task.MainPageIDs) > 0 {
+		regDeviceData = sourcedriver.For(task).GetRegisteredDeviceData(taskCtx, task)
+		if !capabilities.Diversion {
+			withoutDiversions(regDeviceData.RegisteredDeviceData)
+		}
+	}
+
+	//getting spatial data
+	spatialRequestData := &model.SpatialRequestData{Data: make(map[string][]*model.SpatialData)}
+	if capabilities.SpatialSync {
+		spatialRequestData = sourcedriver.For(task).GetSpatialData(taskCtx, task)
+	}
+
+	if taskCtx.Err() != nil {
+		logger.Log().Error(fmt.Sprintf("startTask Server=%v Error : %v", task.ServerID, taskCtx.Err().Error()))
+		return true
+	}
+
+	//sending response back to chan..
+	workerResponseNotifyChan <- WorkerResponse{
+		task: task, registeredDeviceRequestData: regDeviceData,
+		spatialRequestData: spatialRequestData,
+	}
+	return false
+}
+
+//createResponseCollector collecting the worker responses until all are done or the cycle deadline
+func createResponseCollector(ctx context.Context, taskCount int, workerResponseNotifyChan <-chan WorkerResponse, done chan<- WorkerPoolResponse) {
+
+	registeredDeviceDataList := make([]*model.RegisteredDeviceData, 0)
+	spatialRequestData := &model.SpatialRequestData{
+		Data: make(map[string][]*model.SpatialData),
+	}
+
+	dataFetchRequestData := make(map[string]model.DataFetchRequestData)
+
+collect:
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log().Warn(fmt.Sprintf("createResponseCollector cycle deadline reached, collected %v / %v servers", len(dataFetchRequestData), taskCount))
+			break collect
+
+		case workerresponse, ok := <-workerResponseNotifyChan:
+			if !ok {
+				break collect
+			}
+
+			//appending reg.device data
+			registeredDeviceDataList = append(registeredDeviceDataList, workerresponse.registeredDeviceRequestData.RegisteredDeviceData...)
+
+			//appending spatial data, entity wise
+			for entityName, data := range workerresponse.spatialRequestData.Data {
+				spatialRequestData.Data[entityName] = append(spatialRequestData.Data[entityName], data...)
+			}
+
+			//preparing data fetch time, for later update to SQL server
+			dataFetchRequestData[workerresponse.task.ServerID] = model.DataFetchRequestData{
+				DeviceData:  workerresponse.registeredDeviceRequestData.DataFetchDate,
+				SpatialData: workerresponse.spatialRequestData.DataFetchDate,
+			}
+		}
+	}
+
+	//all done, then send back the response to done channel..
+	done <- WorkerPoolResponse{
+		registeredDeviceDataList: registeredDeviceDataList,
+		spatialRequestData:       spatialRequestData,
+		dataFetchRequestData:     dataFetchRequestData,
+	}
+}
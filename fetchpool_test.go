@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/model"
+)
+
+const testSchemaDialect = "fakeschema"
+
+//fakeSchemaDriver source driver failing the schema validation of the given servers
+type fakeSchemaDriver struct {
+	fakeDriver
+
+	mu          sync.Mutex
+	validateErr map[string]error
+	validations map[string]int
+	fetched     []string
+}
+
+func (f *fakeSchemaDriver) Dialect() string { return testSchemaDialect }
+
+func (f *fakeSchemaDriver) ValidateSchema(ctx context.Context, connData *model.SQLConnectionData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.validations[connData.ServerID]++
+	return f.validateErr[connData.ServerID]
+}
+
+func (f *fakeSchemaDriver) GetRegisteredDeviceData(ctx context.Context, connData *model.SQLConnectionData) *model.RegisteredDeviceRequestData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetched = append(f.fetched, connData.ServerID)
+	return &model.RegisteredDeviceRequestData{RegisteredDeviceData: make([]*model.RegisteredDeviceData, 0)}
+}
+
+func (f *fakeSchemaDriver) GetSpatialData(ctx context.Context, connData *model.SQLConnectionData) *model.SpatialRequestData {
+	return &model.SpatialRequestData{Data: make(map[string][]*model.SpatialData)}
+}
+
+func TestStartWorkerValidatesSchemaBeforeFirstFetch(t *testing.T) {
+	driver := &fakeSchemaDriver{
+		validateErr: map[string]error{"2": errors.New("missing procedures : [usp_GetIOTRegisteredDeviceData]")},
+		validations: make(map[string]int),
+	}
+	sourcedriver.Register(driver)
+
+	tasks := make([]*model.SQLConnectionData, 0)
+	for _, serverID := range []string{"1", "2"} {
+		task := &model.SQLConnectionData{ServerID: serverID, Dialect: testSchemaDialect, MainPageIDs: []string{"1"}}
+		task.SetCapabilities(model.ServerCapabilities{DeviceSync: true})
+		tasks = append(tasks, task)
+	}
+
+	runCycle := func() {
+		startWorker(context.Background(), tasks, make(chan WorkerResponse, len(tasks)))
+	}
+
+	runCycle()
+	if !reflect.DeepEqual(driver.fetched, []string{"1"}) {
+		t.Errorf("fetched servers = %v, want [1]", driver.fetched)
+	}
+	if got := lastFetchPoolMetrics().Unvalidated; !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("unvalidated servers = %v, want [2]", got)
+	}
+
+	//procedures deployed, validated once on the next cycle
+	driver.mu.Lock()
+	driver.validateErr = map[string]error{}
+	driver.fetched = nil
+	driver.mu.Unlock()
+
+	runCycle()
+	runCycle()
+	if len(driver.fetched) != 4 {
+		t.Errorf("fetched servers = %v, want both servers on both cycles", driver.fetched)
+	}
+	if want := map[string]int{"1": 1, "2": 2}; !reflect.DeepEqual(driver.validations, want) {
+		t.Errorf("validations = %v, want %v", driver.validations, want)
+	}
+}
@@ -4,13 +4,13 @@ go 1.13
 
 require (
 	github.com/DataDog/zstd v1.4.4 // indirect
-	github.com/denisenkom/go-mssqldb v0.0.0-20191128021309-1d7a30a10f73
 	github.com/go-redis/redis/v7 v7.4.0
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/google/go-cmp v0.2.0 // indirect
 	github.com/jackc/pgconn v1.4.0
 	github.com/jackc/pgx/v4 v4.5.0
 	github.com/lib/pq v1.3.0
+	github.com/microsoft/go-mssqldb v1.7.2
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.9.0 // indirect
 	github.com/tidwall/pretty v1.0.1 // indirect
@@ -20,5 +20,4 @@ require (
 	go.uber.org/atomic v1.5.1 // indirect
 	go.uber.org/multierr v1.4.0 // indirect
 	go.uber.org/zap v1.11.0
-	golang.org/x/net v0.0.0-20191027093000-83d349e8ac1a // indirect
 )
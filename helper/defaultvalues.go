@@ -16,6 +16,23 @@ const (
 	RedisKeyForCommunicationGroup = "REDISKEYFORCOMMUNICATIONGROUP"
 	DeviceCountPerPartition       = "DEVICECOUNTPERPARTITION"
 	RedisDeviceCommandChannel     = "REDISDEVICECOMMANDCHANNEL"
+	RedisKeyForSpatialEntities    = "REDISKEYFORSPATIALENTITIES"
+	RedisKeyForSpatialChange      = "REDISKEYFORSPATIALCHANGE"
+	SpatialChangeNotifyMode       = "SPATIALCHANGENOTIFYMODE"
+	SpatialChangeStreamMaxLen     = "SPATIALCHANGESTREAMMAXLEN"
+	RedisKeyForSpatialLifecycle   = "REDISKEYFORSPATIALLIFECYCLE"
+	SpatialJanitorIntervalInMin   = "SPATIALJANITORINTERVALINMIN"
+	RedisKeyForSpatialHistory     = "REDISKEYFORSPATIALHISTORY"
+	RedisKeyForUnAuthDevices      = "REDISKEYFORUNAUTHDEVICES"
+	UnAuthJobIntervalInSec        = "UNAUTHJOBINTERVALINSEC"
+	RedisKeyForQueryProfiles      = "REDISKEYFORQUERYPROFILES"
+	RedisKeyForAlerts             = "REDISKEYFORALERTS"
+	AlertStreamMaxLen             = "ALERTSTREAMMAXLEN"
+	RedisKeyForValidationRules    = "REDISKEYFORVALIDATIONRULES"
+	RedisKeyForQuarantinedDevices = "REDISKEYFORQUARANTINEDDEVICES"
+	DuplicateDevicePolicy         = "DUPLICATEDEVICEPOLICY"
+	DuplicateDeviceServerPriority = "DUPLICATEDEVICESERVERPRIORITY"
+	RedisKeyForDeviceOwners       = "REDISKEYFORDEVICEOWNERS"
 
 	KafkaBrokers     = "KAFKABROKERS"
 	KafkaUserName    = "KAFKAUSERNAME"
@@ -28,6 +45,30 @@ const (
 	MongoAuthDB   = "MONGOAUTHDB"
 	MongoDBName   = "MONGODBNAME"
 
+	MongoScheme                      = "MONGOSCHEME"
+	MongoReplicaSet                  = "MONGOREPLICASET"
+	MongoTLSEnabled                  = "MONGOTLSENABLED"
+	MongoTLSCAFile                   = "MONGOTLSCAFILE"
+	MongoTLSCertKeyFile              = "MONGOTLSCERTKEYFILE"
+	MongoTLSInsecure                 = "MONGOTLSINSECURE"
+	MongoReadPreference              = "MONGOREADPREFERENCE"
+	MongoReadConcern                 = "MONGOREADCONCERN"
+	MongoWriteConcern                = "MONGOWRITECONCERN"
+	MongoWriteJournal                = "MONGOWRITEJOURNAL"
+	MongoWriteConcernTimeoutInSec    = "MONGOWRITECONCERNTIMEOUTINSEC"
+	MongoCollectionWriteConcerns     = "MONGOCOLLECTIONWRITECONCERNS"
+	MongoConnectTimeoutInSec         = "MONGOCONNECTTIMEOUTINSEC"
+	MongoServerSelectionTimeoutInSec = "MONGOSERVERSELECTIONTIMEOUTINSEC"
+
+	MongoBulkChunkSize              = "MONGOBULKCHUNKSIZE"
+	MongoBulkOrdered                = "MONGOBULKORDERED"
+	MongoBulkTimeoutInSec           = "MONGOBULKTIMEOUTINSEC"
+	RedisKeyForMongoProjections     = "REDISKEYFORMONGOPROJECTIONS"
+	MongoChangeWatcherEnabled       = "MONGOCHANGEWATCHERENABLED"
+	MongoChangeWatcherProjection    = "MONGOCHANGEWATCHERPROJECTION"
+	RedisKeyForDeviceListenerState  = "REDISKEYFORDEVICELISTENERSTATE"
+	MongoCollectionForUnAuthDevices = "MONGOCOLLECTIONFORUNAUTHDEVICES"
+
 	PGSHosts    = "PGSHOSTS"
 	PGSPort     = "PGSPORT"
 	PGSUserName = "PGSUSERNAME"
@@ -40,6 +81,20 @@ const (
 	LoggerLogFormat      = "LOGGERLOGFORMAT"
 	LoggerLogLevel       = "LOGGERLOGLEVEL"
 	JobIntervalInSec     = "JOBINTERVALINSEC"
+	JobsConfig           = "JOBSCONFIG"
+	RedisKeyForJobs      = "REDISKEYFORJOBS"
+
+	SQLFetchConcurrency      = "SQLFETCHCONCURRENCY"
+	SQLQueryTimeoutInSec     = "SQLQUERYTIMEOUTINSEC"
+	SyncCycleDeadlineInSec   = "SYNCCYCLEDEADLINEINSEC"
+	ReadIntentLagMarginInSec = "READINTENTLAGMARGININSEC"
+
+	AgentIdentity           = "AGENTIDENTITY"
+	RedisKeyForLeaderLock   = "REDISKEYFORLEADERLOCK"
+	LeaderLeaseInSec        = "LEADERLEASEINSEC"
+	RedisKeyForAgentStatus  = "REDISKEYFORAGENTSTATUS"
+	RedisKeyForShardMembers = "REDISKEYFORSHARDMEMBERS"
+	ShardMemberTTLInSec     = "SHARDMEMBERTTLINSEC"
 )
 
 
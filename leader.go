@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/election"
+	"data-sync-agent/helper"
+	"data-sync-agent/scheduler"
+	"data-sync-agent/utils/logger"
+)
+
+//leader election of the replicas(nil => single replica, always the leader)
+var leaderElector *election.Elector
+
+//agentIdentity identity of this replica, used on the lease and status
+var agentIdentity = election.DefaultIdentity()
+
+//AgentStatus status of the replica saved on redis
+type AgentStatus struct {
+	Identity       string                `json:"identity"`
+	IsLeader       bool                  `json:"isleader"`
+	Term           int64                 `json:"term"`
+	LeaderIdentity string                `json:"leaderidentity"`
+	LeaderTerm     int64                 `json:"leaderterm"`
+	Jobs           []scheduler.JobStatus `json:"jobs"`
+	FetchPool      FetchPoolMetrics      `json:"fetchpool"`
+	UpdatedOn      time.Time             `json:"updatedon"`
+}
+
+//startLeaderElection campaigning for the leader lock(optional)
+func startLeaderElection() {
+	if identity := helper.GetEnv(helper.AgentIdentity); identity != "" {
+		agentIdentity = identity
+	}
+
+	redisKeyForLeaderLock := helper.GetEnv(helper.RedisKeyForLeaderLock)
+	if redisKeyForLeaderLock == "" {
+		logger.Log().Info(fmt.Sprintf("Leader Election disabled, running as the single replica : %v", agentIdentity))
+		return
+	}
+
+	leaseInSec, err := strconv.ParseInt(helper.GetEnv(helper.LeaderLeaseInSec), 10, 64)
+	if err != nil || leaseInSec < 3 {
+		leaseInSec = 15
+	}
+
+	leaderElector = election.NewElector(nil, redisKeyForLeaderLock, agentIdentity, time.Duration(leaseInSec)*time.Second)
+	logger.Log().Info(fmt.Sprintf("Leader Election Starting!! identity : %v, lease : %vs", agentIdentity, leaseInSec))
+
+	go leaderElector.Run()
+}
+
+//stopLeaderElection releasing the lease, so the standby takes over without waiting for the expiry
+func stopLeaderElection() {
+	if leaderElector != nil {
+		leaderElector.Stop()
+	}
+}
+
+//isLeader whether this replica should run the sync cycles
+func isLeader() bool {
+	return leaderElector == nil || leaderElector.IsLeader()
+}
+
+//leaderOnly skips the run on the standby replicas
+func leaderOnly(jobName string, run func()) scheduler.RunFunc {
+	return func(ctx context.Context) {
+		if !isLeader() {
+			logger.Log().Debug(fmt.Sprintf("Job %v skipped, standby replica : %v", jobName, agentIdentity))
+			return
+		}
+		run()
+	}
+}
+
+//saveAgentStatus saving the replica status(leader, term, jobs) on the redis hash
+func saveAgentStatus(redisKeyForAgentStatus string) {
+	status := AgentStatus{
+		Identity:  agentIdentity,
+		IsLeader:  isLeader(),
+		Jobs:      jobRegistry.Status(),
+		FetchPool: lastFetchPoolMetrics(),
+		UpdatedOn: time.Now().UTC(),
+	}
+
+	if leaderElector == nil {
+		status.LeaderIdentity = agentIdentity
+	} else {
+		status.Term = leaderElector.Term()
+		if leader, err := leaderElector.Leader(); err == nil {
+			status.LeaderIdentity, status.LeaderTerm = leader.Identity, leader.Term
+		}
+	}
+
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("saveAgentStatus Marshal Error : %v", err.Error()))
+		return
+	}
+
+	if err := config.HSet(redisKeyForAgentStatus, agentIdentity, string(jsonData)); err != nil {
+		logger.Log().Error(fmt.Sprintf("saveAgentStatus Redis Error : %v", err.Error()))
+	}
+}
+
+//leaderFence term of the leader when the run started, the writes of the run are fenced by it
+type leaderFence struct {
+	elector *election.Elector
+	term    int64
+}
+
+//newLeaderFence fencing the run with the current term(nil => not fenced, single replica or sharded replicas)
+func newLeaderFence() *leaderFence {
+	if leaderElector == nil || shardMembership != nil {
+		return nil
+	}
+	return &leaderFence{elector: leaderElector, term: leaderElector.Term()}
+}
+
+//holds whether this replica is still the leader of the run's term, checked before each write of the run
+func (f *leaderFence) holds(stage string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.elector.IsLeader() && f.elector.Term() == f.term {
+		return true
+	}
+
+	logger.Log().Warn(fmt.Sprintf("Leader Election %v skipped, leadership of term %v lost (current term : %v)", stage, f.term, f.elector.Term()))
+	return false
+}
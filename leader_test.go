@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"data-sync-agent/election"
+)
+
+//fakeLeaseStore single lock/term pair, running the elector scripts like redis
+type fakeLeaseStore struct {
+	mu   sync.Mutex
+	lock string
+	term int64
+}
+
+func (s *fakeLeaseStore) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.Contains(script, "INCR"):
+		if s.lock != "" {
+			return int64(0), nil
+		}
+		s.term = s.term + 1
+		s.lock = args[0].(string) + "|" + strconv.FormatInt(s.term, 10)
+		return s.term, nil
+	case strings.Contains(script, "PEXPIRE"):
+		if s.lock == args[0].(string) {
+			return int64(1), nil
+		}
+	case strings.Contains(script, "DEL"):
+		if s.lock == args[0].(string) {
+			s.lock = ""
+			return int64(1), nil
+		}
+	}
+	return int64(0), nil
+}
+
+func (s *fakeLeaseStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock, nil
+}
+
+func (s *fakeLeaseStore) setLock(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lock = value
+}
+
+//waitUntil polls the condition until the timeout
+func waitUntil(t *testing.T, name string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//startTestElector running the elector as the leaderElector of the agent, stopped when the test ends
+func startTestElector(t *testing.T, store election.Store) *election.Elector {
+	t.Helper()
+
+	e := election.NewElector(store, "sync", "agent-1", 60*time.Millisecond)
+	leaderElector = e
+	go e.Run()
+
+	t.Cleanup(func() {
+		e.Stop()
+		leaderElector = nil
+	})
+
+	waitUntil(t, "leadership", e.IsLeader)
+	return e
+}
+
+func TestLeaderFenceWithoutElection(t *testing.T) {
+	fence := newLeaderFence()
+	if fence != nil || !fence.holds("test") {
+		t.Errorf("single replica fence = %v, want nil(always holds)", fence)
+	}
+}
+
+func TestLeaderFenceLostLease(t *testing.T) {
+	store := &fakeLeaseStore{}
+	e := startTestElector(t, store)
+
+	fence := newLeaderFence()
+	if !fence.holds("test") {
+		t.Fatalf("fence of the current term should hold")
+	}
+
+	//another replica took over the lock
+	store.setLock("agent-2|5")
+	waitUntil(t, "step down", func() bool { return !e.IsLeader() })
+
+	if fence.holds("test") {
+		t.Errorf("fence holds after the lease was lost")
+	}
+}
+
+func TestLeaderFenceTermChange(t *testing.T) {
+	store := &fakeLeaseStore{}
+	e := startTestElector(t, store)
+
+	fence := newLeaderFence()
+
+	//lease lost and re-acquired with a new term(a run of the old term may overlap the other leader)
+	store.setLock("")
+	waitUntil(t, "new term", func() bool { return e.IsLeader() && e.Term() == 2 })
+
+	if fence.holds("test") {
+		t.Errorf("fence of term 1 holds on term %v", e.Term())
+	}
+	if !newLeaderFence().holds("test") {
+		t.Errorf("fence of the new term should hold")
+	}
+}
@@ -17,15 +17,19 @@ import (
 	"data-sync-agent/model"
 	"data-sync-agent/utils/logger"
 
+	"data-sync-agent/alert"
 	"data-sync-agent/crypto"
 	"data-sync-agent/dataservice/postgreprovider"
+	"data-sync-agent/dataservice/spatialentity"
+	"data-sync-agent/dataservice/sourcedriver"
 	"data-sync-agent/dataservice/sqldataprovider"
 
 	"data-sync-agent/entity"
+	"data-sync-agent/scheduler"
 )
 
 var sqlConnectionListData []*model.SQLConnectionData
-var allowedMainPageIDs string
+var redisKeyForSQLServers string
 var updSysncDateErrorCount int
 var isAppAlive = true
 
@@ -33,6 +37,26 @@ var isAppAlive = true
 var redisKeyForRegisteredDevice, redisKeyForTestDevice,
 	redisKeyForCommunicationGroup, redisKeyForDeviceCommandChannel string
 var singlePartitionDeviceCount int
+var spatialChangeNotifyKey, spatialChangeNotifyMode string
+var spatialChangeStreamMaxLen int64
+
+//job names
+const (
+	jobNameDeviceSync     = "devicesync"
+	jobNameSpatialJanitor = "spatialjanitor"
+	jobNameUnAuthDevices  = "unauthdevices"
+	jobNameAgentStatus    = "agentstatus"
+	jobNameShardHeartbeat = "shardheartbeat"
+)
+
+//registered jobs(device sync, spatial janitor, etc..)
+var jobRegistry = scheduler.NewRegistry()
+
+//spatial change notify modes
+const (
+	spatialChangeNotifyModePubSub = "pubsub"
+	spatialChangeNotifyModeStream = "stream"
+)
 
 //WorkerResponse from each worker
 type WorkerResponse struct {
@@ -50,6 +74,14 @@ type WorkerPoolResponse struct {
 
 //entry for app..
 func main() {
+	//config(redis) and store(mongo) providers are required to run the app
+	if _, err := config.InitializeConfigProvider(); err != nil {
+		logger.Log().Fatal(fmt.Sprintf("Config Server Error : %v", err.Error()))
+	}
+	if _, err := entity.InitializeStoreDataProvider(); err != nil {
+		logger.Log().Fatal(fmt.Sprintf("Mongo Server Error : %v", err.Error()))
+	}
+
 	// bootstrap app!!!!
 	go func() {
 		initDataSyncJob()
@@ -70,7 +102,7 @@ func initDataSyncJob() {
 		return
 	}
 
-	redisKeyForSQLServers := helper.GetEnv(helper.RedisKeyForServers)
+	redisKeyForSQLServers = helper.GetEnv(helper.RedisKeyForServers)
 
 	if redisKeyForSQLServers == "" {
 		logger.Log().Error("startDataSyncJob - redisKeyForSQLServers is empty")
@@ -80,6 +112,10 @@ func initDataSyncJob() {
 	//initialize the crypto module..
 	crypto.InitializeCryptoProvider(logger.Log())
 
+	//alerts(schema drift...) are also added to the redis stream, when configured
+	alertStreamMaxLen, _ := strconv.ParseInt(helper.GetEnv(helper.AlertStreamMaxLen), 10, 64)
+	alert.Init(helper.GetEnv(helper.RedisKeyForAlerts), alertStreamMaxLen)
+
 	onboardedServerIds, err := config.SMembers(redisKeyForOnboardedSQLServers)
 	if err != nil {
 		logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis SQL On-boarded Servers Error : %v", err.Error()))
@@ -102,8 +138,56 @@ func initDataSyncJob() {
 		}
 	}
 
+	//mongo projections of the devices, registered over the default one...
+	redisKeyForMongoProjections := helper.GetEnv(helper.RedisKeyForMongoProjections)
+	if redisKeyForMongoProjections != "" {
+		projectionValues, err := config.HGetAll(redisKeyForMongoProjections)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis Mongo Projections Error : %v", err.Error()))
+		} else {
+			entity.LoadProjections(projectionValues)
+		}
+	}
+	entity.EnsureIndexes()
+
+	//custom spatial entities, registered over the built-in ones...
+	redisKeyForSpatialEntities := helper.GetEnv(helper.RedisKeyForSpatialEntities)
+	if redisKeyForSpatialEntities != "" {
+		err = spatialentity.LoadFromConfig(redisKeyForSpatialEntities)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis Spatial Entities Error : %v", err.Error()))
+		}
+	}
+
+	//lifecycle policies of the deactivated spatial objects...
+	redisKeyForSpatialLifecycle := helper.GetEnv(helper.RedisKeyForSpatialLifecycle)
+	if redisKeyForSpatialLifecycle != "" {
+		err = spatialentity.LoadLifecyclePolicies(redisKeyForSpatialLifecycle)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis Spatial Lifecycle Error : %v", err.Error()))
+		}
+	}
+
+	//versioned spatial entities(history tables)...
+	redisKeyForSpatialHistory := helper.GetEnv(helper.RedisKeyForSpatialHistory)
+	if redisKeyForSpatialHistory != "" {
+		err = spatialentity.LoadHistoryTables(redisKeyForSpatialHistory)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis Spatial History Error : %v", err.Error()))
+		}
+	}
+
+	//query profiles of the older SQL server schema versions...
+	redisKeyForQueryProfiles := helper.GetEnv(helper.RedisKeyForQueryProfiles)
+	if redisKeyForQueryProfiles != "" {
+		err = sqldataprovider.LoadQueryProfiles(redisKeyForQueryProfiles)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf(" startDataSyncJob Redis Query Profiles Error : %v", err.Error()))
+		}
+	}
+
 	//initialize the SQL server conn...
-	connectionListData, mainPageIDs := sqldataprovider.InitConnection(onboardedServers)
+	connectionListData := sourcedriver.InitConnection(onboardedServers)
 	if len(connectionListData) > 0 {
 		logger.Log().Info(" SQL server initialized")
 	} else {
@@ -113,7 +197,9 @@ func initDataSyncJob() {
 
 	//assigning to global var
 	sqlConnectionListData = connectionListData
-	allowedMainPageIDs = mainPageIDs
+
+	//feature flags of the servers
+	refreshServerCapabilities()
 
 	//Postgre Connection
 	err = postgreprovider.InitPostgreConnection(&model.DBCredentialProvider{
@@ -142,70 +228,298 @@ func assignDefaultValues() {
 
 	redisKeyForDeviceCommandChannel = helper.GetEnv(helper.RedisDeviceCommandChannel)
 
+	//spatial change notification(pubsub/stream)
+	spatialChangeNotifyKey = helper.GetEnv(helper.RedisKeyForSpatialChange)
+	spatialChangeNotifyMode = helper.GetEnv(helper.SpatialChangeNotifyMode)
+	if spatialChangeNotifyMode != spatialChangeNotifyModeStream {
+		spatialChangeNotifyMode = spatialChangeNotifyModePubSub
+	}
+	spatialChangeStreamMaxLen, _ = strconv.ParseInt(helper.GetEnv(helper.SpatialChangeStreamMaxLen), 10, 64)
+
+	//bounded fetch of the sql servers
+	loadFetchPoolSettings()
+
 }
 
 func prepareJob() {
 	logger.Log().Info("Job Starting!!")
 
-	//time job interval...
+	//assigning default values
+	assignDefaultValues()
+
+	//a configured duplicate device policy requires the device owners hash
+	checkDuplicateDeviceSettings()
+
+	//only the leader replica runs the sync cycles
+	startLeaderElection()
+
+	//or the replicas divide the servers
+	startSharding()
+
+	//optional mongo change stream watcher
+	startDeviceChangeWatcher()
+
+	registerJobs()
+
+	//job configs(name => config json), env first then redis(toggle without deploy)
+	if jobsConfig := helper.GetEnv(helper.JobsConfig); jobsConfig != "" {
+		values := make(map[string]json.RawMessage)
+		if err := json.Unmarshal([]byte(jobsConfig), &values); err != nil {
+			logger.Log().Error(fmt.Sprintf("prepareJob JobsConfig Unmarshal Error : %v", err.Error()))
+		} else {
+			envValues := make(map[string]string)
+			for name, v := range values {
+				envValues[name] = string(v)
+			}
+			jobRegistry.Configure(envValues)
+		}
+	}
+
+	if redisKeyForJobs := helper.GetEnv(helper.RedisKeyForJobs); redisKeyForJobs != "" {
+		values, err := config.HGetAll(redisKeyForJobs)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("prepareJob Redis Jobs Error : %v", err.Error()))
+		} else {
+			jobRegistry.Configure(values)
+		}
+	}
+
+	jobRegistry.Start()
+}
+
+//deviceSyncRunFunc sharded replicas sync their own servers, otherwise only the leader syncs
+func deviceSyncRunFunc() scheduler.RunFunc {
+	if shardMembership != nil {
+		return func(ctx context.Context) {
+			executeJob()
+		}
+	}
+	return leaderOnly(jobNameDeviceSync, executeJob)
+}
+
+//registerJobs registering the jobs with their default schedule
+func registerJobs() {
+	//device + spatial sync...
 	jobIntervalInSec, err := strconv.ParseInt(helper.GetEnv(helper.JobIntervalInSec), 10, 64)
-	if err != nil {
+	if err != nil || jobIntervalInSec <= 0 {
 		jobIntervalInSec = 15
 	}
-	jobIntervalDuration := time.Duration(jobIntervalInSec) * time.Second
 
-	//assigning default values
-	assignDefaultValues()
+	//spatial tombstones purge...
+	janitorIntervalInMin, err := strconv.ParseInt(helper.GetEnv(helper.SpatialJanitorIntervalInMin), 10, 64)
+	if err != nil || janitorIntervalInMin <= 0 {
+		janitorIntervalInMin = 60
+	}
+
+	//unauthorized devices reported by the listener...
+	unAuthJobIntervalInSec, err := strconv.ParseInt(helper.GetEnv(helper.UnAuthJobIntervalInSec), 10, 64)
+	if err != nil || unAuthJobIntervalInSec <= 0 {
+		unAuthJobIntervalInSec = 60
+	}
+	redisKeyForUnAuthDevices := helper.GetEnv(helper.RedisKeyForUnAuthDevices)
+	mongoCollectionForUnAuthDevices := helper.GetEnv(helper.MongoCollectionForUnAuthDevices)
+
+	//replica status(leader, term, jobs)...
+	redisKeyForAgentStatus := helper.GetEnv(helper.RedisKeyForAgentStatus)
+
+	jobs := []*scheduler.Job{
+		{
+			Name:       jobNameDeviceSync,
+			Schedule:   scheduler.IntervalSchedule{Interval: time.Duration(jobIntervalInSec) * time.Second},
+			Enabled:    true,
+			RunOnStart: true,
+			Run:        deviceSyncRunFunc(),
+		},
+		{
+			Name:     jobNameSpatialJanitor,
+			Schedule: scheduler.IntervalSchedule{Interval: time.Duration(janitorIntervalInMin) * time.Minute},
+			Enabled:  true,
+			Run:      leaderOnly(jobNameSpatialJanitor, executeSpatialJanitor),
+		},
+		{
+			Name:     jobNameUnAuthDevices,
+			Schedule: scheduler.IntervalSchedule{Interval: time.Duration(unAuthJobIntervalInSec) * time.Second},
+			Enabled:  redisKeyForUnAuthDevices != "",
+			Run: leaderOnly(jobNameUnAuthDevices, func() {
+				executeUnAuthDeviceJob(redisKeyForUnAuthDevices, mongoCollectionForUnAuthDevices)
+			}),
+		},
+		{
+			Name:       jobNameAgentStatus,
+			Schedule:   scheduler.IntervalSchedule{Interval: 10 * time.Second},
+			Enabled:    redisKeyForAgentStatus != "",
+			RunOnStart: true,
+			Run: func(ctx context.Context) {
+				saveAgentStatus(redisKeyForAgentStatus)
+			},
+		},
+	}
+
+	for _, job := range jobs {
+		if err := jobRegistry.Register(job); err != nil {
+			logger.Log().Error(fmt.Sprintf("registerJobs %v Error : %v", job.Name, err.Error()))
+		}
+	}
+}
 
-	for {
-		if !isAppAlive {
-			return
+func executeSpatialJanitor() {
+	for _, d := range spatialentity.All() {
+		if d.Lifecycle.Mode == model.SpatialLifecycleKeep {
+			continue
 		}
 
-		executeJob()
+		purgedCount, err := postgreprovider.PurgeSpatialTombstones(context.Background(), d)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("Spatial Janitor %v Error : %v", d.Name, err.Error()))
+			continue
+		}
 
-		//sleeping...
-		time.Sleep(jobIntervalDuration)
+		if purgedCount > 0 {
+			logger.Log().Info(fmt.Sprintf("Spatial Janitor %v (%v) PURGED COUNT : %v", d.Name, d.Lifecycle.Mode, purgedCount))
+		}
 	}
 }
 
 func executeJob() {
 	//defining worker..
-	workerResponseNotifyChan := make(chan WorkerResponse, len(sqlConnectionListData))
+	//servers of this replica(all, when not sharded)
+	tasks := shardedTasks()
+	if len(tasks) == 0 {
+		return
+	}
+
+	//writes of the cycle are skipped once the leadership(term) is lost
+	fence := newLeaderFence()
+
+	//flags toggled by the operators are applied from this cycle
+	refreshServerCapabilities()
+
+	//slow servers are left out of the cycle after the deadline
+	cycleCtx, cancel := context.WithTimeout(context.Background(), fetchPoolSettings.CycleDeadline)
+	defer cancel()
+
+	//buffered, so the late workers never block
+	workerResponseNotifyChan := make(chan WorkerResponse, len(tasks))
 	//workers completed chan
 	allCompletedResp := make(chan WorkerPoolResponse)
 	//hooking task response collector
-	go createResponseCollector(workerResponseNotifyChan, allCompletedResp)
+	go createResponseCollector(cycleCtx, len(tasks), workerResponseNotifyChan, allCompletedResp)
 
-	//generate worker pool, to split and assign tak..
-	startWorker(sqlConnectionListData, workerResponseNotifyChan)
+	//bounded worker pool, to split and assign tak..
+	go startWorker(cycleCtx, tasks, workerResponseNotifyChan)
 
 	resp := <-allCompletedResp
 	//closing chan
 	close(allCompletedResp)
 
-	//saving device data ...
-	canUpdateDeviceDate := saveDataToStore(resp.registeredDeviceDataList)
+	if !fence.holds("executeJob store") {
+		return
+	}
+
+	//invalid devices are quarantined, instead of being saved/published
+	resp.registeredDeviceDataList = validateDevices(resp.registeredDeviceDataList)
+
+	//saving device data ...(communication groups are allocated by one replica at a time)
+	canUpdateDeviceDate, failedDeviceIDs := false, make([]string, 0)
+	unlock, err := lockCommunicationGroups()
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("executeJob Communication Group Lock Error : %v", err.Error()))
+	} else {
+		//a device present on more than one server is resolved to a single server(device owners are claimed atomically)
+		resolvedDevices, lostDeviceIDs := resolveDuplicateDevices(resp.registeredDeviceDataList)
+		canUpdateDeviceDate, failedDeviceIDs = saveDataToStore(resolvedDevices)
+		//devices claimed by another replica meanwhile are re-sent, like the failed ones
+		failedDeviceIDs = append(failedDeviceIDs, lostDeviceIDs...)
+		unlock()
+	}
+	//servers of the failed devices should re-send the devices, so the device fetch date is not updated
+	failedDeviceServers := getServersOfDevices(resp.registeredDeviceDataList, failedDeviceIDs)
 	canUpdateSpatialDate := true
+	if !fence.holds("SaveSpatialData") {
+		return
+	}
 	//save spatial data to PostgreGIS
-	if len(resp.spatialRequestData.GeofenceData) > 0 || len(resp.spatialRequestData.AreaData) > 0 || len(resp.spatialRequestData.ZoneData) > 0 || len(resp.spatialRequestData.NoGoAreaData) > 0 {
+	if resp.spatialRequestData.Count() > 0 {
 
-		savedCount, err := postgreprovider.SaveSpatialData(context.Background(), resp.spatialRequestData)
+		savedCount, changeEvents, err := postgreprovider.SaveSpatialData(context.Background(), resp.spatialRequestData)
 		if err != nil {
 			canUpdateSpatialDate = false
 			logger.Log().Error(fmt.Sprintf("SaveSpatialData Error : %v", err.Error()))
 		}
 
 		logger.Log().Info(fmt.Sprintf("SaveSpatialData Count : %v", savedCount))
+
+		//notifying downstream services(geofence engines) abt committed changes...
+		if len(changeEvents) > 0 {
+			notifySpatialChanges(changeEvents)
+		}
 	}
 
 	//upd the last-fetch date to DB.....
 	// ====================================
-	if canUpdateDeviceDate || canUpdateSpatialDate {
-		saveJobWorkerStatus(canUpdateDeviceDate, canUpdateSpatialDate, resp.dataFetchRequestData)
+	if (canUpdateDeviceDate || canUpdateSpatialDate) && fence.holds("saveJobWorkerStatus") {
+		saveJobWorkerStatus(tasks, canUpdateDeviceDate, canUpdateSpatialDate, resp.dataFetchRequestData, failedDeviceServers)
 	}
 }
 
+//getServersOfDevices returns the source servers of the given device ids
+func getServersOfDevices(regDeviceData []*model.RegisteredDeviceData, deviceIDs []string) map[string]bool {
+	servers := make(map[string]bool)
+	if len(deviceIDs) == 0 {
+		return servers
+	}
+
+	ids := make(map[string]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		ids[id] = true
+	}
+
+	for _, d := range regDeviceData {
+		if ids[d.DeviceID] {
+			servers[d.ServerID] = true
+		}
+	}
+
+	if len(servers) > 0 {
+		logger.Log().Warn(fmt.Sprintf("Device fetch date not updated for servers : %v", servers))
+	}
+
+	return servers
+}
+
+//notifySpatialChanges publishing each spatial change event to redis pub/sub channel or stream
+func notifySpatialChanges(changeEvents []*model.SpatialChangeEvent) {
+	if spatialChangeNotifyKey == "" {
+		return
+	}
+
+	pushedCount := 0
+	for _, event := range changeEvents {
+		byteRes, err := json.Marshal(event)
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("SPATIAL_CHANGE_NOTIFY (Marshal) UID: %v Error : %v", event.UID, err.Error()))
+			continue
+		}
+
+		if spatialChangeNotifyMode == spatialChangeNotifyModeStream {
+			_, err = config.XAdd(spatialChangeNotifyKey, spatialChangeStreamMaxLen, map[string]interface{}{
+				"entitytype": event.EntityType,
+				"data":       string(byteRes),
+			})
+		} else {
+			_, err = config.Publish(spatialChangeNotifyKey, byteRes)
+		}
+
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("SPATIAL_CHANGE_NOTIFY (Redis %v) UID: %v Error : %v", spatialChangeNotifyMode, event.UID, err.Error()))
+			continue
+		}
+		pushedCount = pushedCount + 1
+	}
+
+	logger.Log().Info(fmt.Sprintf("SPATIAL_CHANGE_NOTIFY PUSHED COUNT: %v / %v", pushedCount, len(changeEvents)))
+}
+
 //close app..
 func closeAppSetup() {
 	// Wait for ctrl+c
@@ -218,6 +532,16 @@ func closeAppSetup() {
 	logger.Log().Info("App trying to stop gracefully!!!")
 
 	isAppAlive = false
+
+	//waiting for the running jobs...
+	if !jobRegistry.Stop(30 * time.Second) {
+		logger.Log().Warn("Jobs are still running, closing the connections!!!")
+	}
+
+	//handing over the leadership/servers...
+	stopLeaderElection()
+	stopSharding()
+
 	//======== Closing all the connections======....
 	logger.Log().Info("Closing DB Conn!!!")
 
@@ -227,6 +551,9 @@ func closeAppSetup() {
 	//closing sql server conn..
 	for _, d := range sqlConnectionListData {
 		sqldataprovider.Close(d.DB)
+		if d.ReadDB != nil {
+			sqldataprovider.Close(d.ReadDB)
+		}
 	}
 
 	//closing redis conn..
@@ -239,101 +566,12 @@ func closeAppSetup() {
 	os.Exit(0)
 }
 
-func startTask(task *model.SQLConnectionData, workerResponseNotifyChan chan<- WorkerResponse, wg *sync.WaitGroup) {
-
-	deviceDataChan := make(chan *model.RegisteredDeviceRequestData)
-	spatialDataChan := make(chan *model.SpatialRequestData)
-
-	//getting device data
-	go func(c chan<- *model.RegisteredDeviceRequestData) {
-		c <- sqldataprovider.GetRegisteredDeviceData(task, allowedMainPageIDs)
-	}(deviceDataChan)
-
-	//getting spatial data
-	go func(c chan<- *model.SpatialRequestData, serverID string) {
-		if serverID != "27" {
-			c <- sqldataprovider.GetSpatialData(task)
-		} else {
-			c <- &model.SpatialRequestData{
-				GeofenceData:  make([]*model.GeofenceData, 0),
-				AreaData:      make([]*model.AreaData, 0),
-				ZoneData:      make([]*model.ZoneData, 0),
-				NoGoAreaData:  make([]*model.NoGoAreaData, 0),
-				DataFetchDate: time.Now().UTC(),
-			}
-		}
-	}(spatialDataChan, task.ServerID)
-
-	regDeviceData, spatialRequestData := <-deviceDataChan, <-spatialDataChan
-
-	//closing chan...
-	close(deviceDataChan)
-	close(spatialDataChan)
-
-	//sending response back to chan..
-	workerResponseNotifyChan <- WorkerResponse{
-		task: task, registeredDeviceRequestData: regDeviceData,
-		spatialRequestData: spatialRequestData,
-	}
-
-	wg.Done()
-}
-
-func startWorker(taskList []*model.SQLConnectionData, workerResponseNotifyChan chan<- WorkerResponse) {
-	var wg sync.WaitGroup
-	for _, task := range taskList {
-		wg.Add(1)
-		go startTask(task, workerResponseNotifyChan, &wg)
-	}
-	wg.Wait()
-	//closing chan..
-	close(workerResponseNotifyChan)
-}
-
-func createResponseCollector(workerResponseNotifyChan <-chan WorkerResponse, done chan<- WorkerPoolResponse) {
-
-	registeredDeviceDataList := make([]*model.RegisteredDeviceData, 0)
-	spatialRequestData := &model.SpatialRequestData{
-		GeofenceData: make([]*model.GeofenceData, 0),
-		AreaData:     make([]*model.AreaData, 0),
-		ZoneData:     make([]*model.ZoneData, 0),
-		NoGoAreaData: make([]*model.NoGoAreaData, 0),
-	}
-
-	dataFetchRequestData := make(map[string]model.DataFetchRequestData)
-
-	for workerresponse := range workerResponseNotifyChan {
-		//appending reg.device data
-		registeredDeviceDataList = append(registeredDeviceDataList, workerresponse.registeredDeviceRequestData.RegisteredDeviceData...)
-
-		//appending spatial data
-		spatialRequestData.GeofenceData = append(spatialRequestData.GeofenceData, workerresponse.spatialRequestData.GeofenceData...)
-
-		spatialRequestData.AreaData = append(spatialRequestData.AreaData, workerresponse.spatialRequestData.AreaData...)
-
-		spatialRequestData.ZoneData = append(spatialRequestData.ZoneData, workerresponse.spatialRequestData.ZoneData...)
-
-		spatialRequestData.NoGoAreaData = append(spatialRequestData.NoGoAreaData, workerresponse.spatialRequestData.NoGoAreaData...)
-
-		//preparing data fetch time, for later update to SQL server
-		dataFetchRequestData[workerresponse.task.ServerID] = model.DataFetchRequestData{
-			DeviceData:  workerresponse.registeredDeviceRequestData.DataFetchDate,
-			SpatialData: workerresponse.spatialRequestData.DataFetchDate,
-		}
-	}
-
-	//all done, then send back the response to done channel..
-	done <- WorkerPoolResponse{
-		registeredDeviceDataList: registeredDeviceDataList,
-		spatialRequestData:       spatialRequestData,
-		dataFetchRequestData:     dataFetchRequestData,
-	}
-}
-
 //saveDataToStore used to store the data on redis and mongo...
-func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
+//returns the fetch date update status and the device ids failed to store
+func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) (bool, []string) {
 
 	canUpdateFetDate := true
+	failedDeviceIDs := make([]string, 0)
 
 	if len(regDeviceData) > 0 {
 		getCommunicationGroupFromRedis(regDeviceData, redisKeyForRegisteredDevice)
@@ -348,6 +586,8 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 
 	regMongoDeviceData := make([]model.MongoDeviceData, 0)
 	deRegMongoDeviceData := make([]model.MongoDeviceData, 0)
+	//comm. group of the de-registered devices, de-allocated once deleted from mongo
+	deRegCommunicationGroups := make(map[string]string)
 
 	//already saved communication group details...
 	updatedCommGroup := make(map[string]interface{}, 0)
@@ -415,17 +655,7 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 			//only add installed active devices into mongodb...
 			if data.DeviceMasterStatusUno == 5 {
 				//mongo active dev...
-				regMongoDeviceData = append(regMongoDeviceData, model.MongoDeviceData{
-					ID:                   data.DeviceID,
-					ProviderTenantUIDs:   data.ProviderTenantUIDs,
-					TenantGroupUID:       data.TenantGroupUID,
-					TenantUID:            data.TenantUID,
-					TenantName:           data.TenantName,
-					DeviceTypeID:         data.DeviceTypeID,
-					CommunicationGroupID: data.CommunicationGroupID,
-					VehicleID:            data.VehicleID,
-					DiversionDetails:     data.DiversionDetails,
-				})
+				regMongoDeviceData = append(regMongoDeviceData, toMongoDeviceData(data))
 
 			} else {
 				//store devices which is not yet installed on vehicle added under testdevicedata redis key..
@@ -443,18 +673,12 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 			}
 			//if comm. group is present then proceed...
 			if currentCommunicationGroupID >= 0 {
-				//getting curr. val
-				currentCount, _ := strconv.Atoi(savedCommunicationGroup[currentCommunicationGroup])
-				//setting new val(by removing)
-				savedCommunicationGroup[currentCommunicationGroup] = strconv.Itoa(currentCount - 1)
-				//add/upd. list to find out modified list..
-				updatedCommGroup[currentCommunicationGroup] = ""
-
-				removedData = append(removedData, data.DeviceID)
+				deRegCommunicationGroups[data.DeviceID] = currentCommunicationGroup
 
 				// /mongo
 				deRegMongoDeviceData = append(deRegMongoDeviceData, model.MongoDeviceData{
-					ID: data.DeviceID,
+					ID:        data.DeviceID,
+					TenantUID: data.TenantUID,
 				})
 			}
 		}
@@ -471,14 +695,35 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 	}
 
 	//mongo DB saving
+	mongoFailed := make(map[string]bool)
 	if len(regMongoDeviceData) > 0 || len(deRegMongoDeviceData) > 0 {
-		rowCount, mErr := entity.BulkWrite("tblvehiclerecentupdates", regMongoDeviceData, deRegMongoDeviceData)
-		if mErr != nil {
-			logger.Log().Error(fmt.Sprintf("startDevicveDataTransferJob (Mongo Bulk) Error : %v", mErr.Error()))
+
+		//own deletes are ignored by the device change watcher
+		if len(deRegMongoDeviceData) > 0 {
+			deletedIDs := make([]string, 0, len(deRegMongoDeviceData))
+			for _, d := range deRegMongoDeviceData {
+				deletedIDs = append(deletedIDs, d.ID)
+			}
+			markAgentDeletes(deletedIDs)
 		}
 
-		if rowCount > 0 {
-			logger.Log().Info(fmt.Sprintf("MONGO COUNT: %v", rowCount))
+		//writing all the configured projections...
+		for _, projection := range entity.Projections() {
+			rowCount, mongoFailedIDs, mErr := entity.BulkWrite(projection, regMongoDeviceData, deRegMongoDeviceData)
+			if mErr != nil {
+				logger.Log().Error(fmt.Sprintf("startDevicveDataTransferJob (Mongo Bulk %v) Error : %v, FAILED DEVICE COUNT : %v", projection.Name, mErr.Error(), len(mongoFailedIDs)))
+			}
+
+			for _, id := range mongoFailedIDs {
+				if !mongoFailed[id] {
+					mongoFailed[id] = true
+					failedDeviceIDs = append(failedDeviceIDs, id)
+				}
+			}
+
+			if rowCount > 0 {
+				logger.Log().Info(fmt.Sprintf("MONGO COUNT (%v): %v", projection.Name, rowCount))
+			}
 		}
 
 		// deleting test device data
@@ -486,7 +731,10 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 			removedTestData := make([]string, 0)
 
 			for _, r := range regMongoDeviceData {
-				removedTestData = append(removedTestData, r.ID)
+				//failed device stays as test device, until the next successful write
+				if !mongoFailed[r.ID] {
+					removedTestData = append(removedTestData, r.ID)
+				}
 			}
 
 			// removedData = append(removedData, data.DeviceID)
@@ -503,6 +751,24 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 		}
 	}
 
+	//de-registered devices are removed only once deleted from mongo, the failed ones keep their comm. group,
+	//so they are de-registered again on the next cycle
+	for _, d := range deRegMongoDeviceData {
+		if mongoFailed[d.ID] {
+			continue
+		}
+
+		currentCommunicationGroup := deRegCommunicationGroups[d.ID]
+		//getting curr. val
+		currentCount, _ := strconv.Atoi(savedCommunicationGroup[currentCommunicationGroup])
+		//setting new val(by removing)
+		savedCommunicationGroup[currentCommunicationGroup] = strconv.Itoa(currentCount - 1)
+		//add/upd. list to find out modified list..
+		updatedCommGroup[currentCommunicationGroup] = ""
+
+		removedData = append(removedData, d.ID)
+	}
+
 	//saving test device data
 	if len(registeredTestData) > 0 {
 		redisErr1 := config.HMSet(redisKeyForTestDevice, registeredTestData)
@@ -579,7 +845,22 @@ func saveDataToStore(regDeviceData []*model.RegisteredDeviceData) bool {
 		logger.Log().Info(fmt.Sprintf("Comm.group updated successfully [REDIS] count : %v", len(updatedCommGroup)))
 	}
 
-	return canUpdateFetDate
+	return canUpdateFetDate, failedDeviceIDs
+}
+
+//toMongoDeviceData mongo projection source of the device
+func toMongoDeviceData(data *model.RegisteredDeviceData) model.MongoDeviceData {
+	return model.MongoDeviceData{
+		ID:                   data.DeviceID,
+		ProviderTenantUIDs:   data.ProviderTenantUIDs,
+		TenantGroupUID:       data.TenantGroupUID,
+		TenantUID:            data.TenantUID,
+		TenantName:           data.TenantName,
+		DeviceTypeID:         data.DeviceTypeID,
+		CommunicationGroupID: data.CommunicationGroupID,
+		VehicleID:            data.VehicleID,
+		DiversionDetails:     data.DiversionDetails,
+	}
 }
 
 //calculating comm. group
@@ -755,11 +1036,11 @@ func getCommunicationGroupFromRedis(regData []*model.RegisteredDeviceData, redis
 }
 
 //saveJobWorkerStatus saving/updating back to Sql Server abt last fetch date....
-func saveJobWorkerStatus(canUpdateDeviceDate bool, canUpdateSpatialDate bool, dataFetchRequestData map[string]model.DataFetchRequestData) {
+func saveJobWorkerStatus(tasks []*model.SQLConnectionData, canUpdateDeviceDate bool, canUpdateSpatialDate bool, dataFetchRequestData map[string]model.DataFetchRequestData, failedDeviceServers map[string]bool) {
 
 	errOccured := false
 	var wg sync.WaitGroup
-	for _, task := range sqlConnectionListData {
+	for _, task := range tasks {
 
 		v, ok := dataFetchRequestData[task.ServerID]
 		if ok {
@@ -768,14 +1049,14 @@ func saveJobWorkerStatus(canUpdateDeviceDate bool, canUpdateSpatialDate bool, da
 
 			go func(updDeviceDate bool, updSpatialDate bool, dataFetchData model.DataFetchRequestData, t *model.SQLConnectionData, resolver *sync.WaitGroup) {
 
-				_, err := sqldataprovider.UpdateDataSyncFetchDate(t, updDeviceDate, dataFetchData.DeviceData, updSpatialDate, dataFetchData.SpatialData)
+				_, err := sourcedriver.For(t).UpdateDataSyncFetchDate(t, updDeviceDate, dataFetchData.DeviceData, updSpatialDate, dataFetchData.SpatialData)
 				//error occured
 				if err != nil {
 					errOccured = true
 				}
 				//resolving...
 				resolver.Done()
-			}(canUpdateDeviceDate, canUpdateSpatialDate, v, task, &wg)
+			}(canUpdateDeviceDate && !failedDeviceServers[task.ServerID] && !v.DeviceData.IsZero(), canUpdateSpatialDate && !v.SpatialData.IsZero(), v, task, &wg)
 		}
 
 	}
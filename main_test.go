@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"data-sync-agent/config"
+	cm "data-sync-agent/config/conman"
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/entity"
+	sm "data-sync-agent/entity/storeman"
+	"data-sync-agent/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testDialect = "fake"
+
+//failingStore memory store failing the writes of the given device ids, the chunk sizes are recorded
+type failingStore struct {
+	*sm.MemoryProvider
+
+	mu      sync.Mutex
+	failIDs map[string]bool
+	chunks  []int
+}
+
+func (fs *failingStore) BulkWrite(ctx context.Context, collectionName string, operations []mongo.WriteModel, bulkOptions *options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	fs.mu.Lock()
+	fs.chunks = append(fs.chunks, len(operations))
+	fs.mu.Unlock()
+
+	ordered := bulkOptions != nil && bulkOptions.Ordered != nil && *bulkOptions.Ordered
+
+	result := &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+	writeErrors := make([]mongo.BulkWriteError, 0)
+	for i, operation := range operations {
+		if fs.failIDs[operationID(operation)] {
+			writeErrors = append(writeErrors, mongo.BulkWriteError{WriteError: mongo.WriteError{Index: i, Code: 11000, Message: "E11000 duplicate key"}, Request: operation})
+			if ordered {
+				break
+			}
+			continue
+		}
+
+		r, err := fs.MemoryProvider.BulkWrite(ctx, collectionName, []mongo.WriteModel{operation}, bulkOptions)
+		if err != nil {
+			return result, err
+		}
+		result.UpsertedCount = result.UpsertedCount + r.UpsertedCount
+		result.ModifiedCount = result.ModifiedCount + r.ModifiedCount
+		result.DeletedCount = result.DeletedCount + r.DeletedCount
+	}
+
+	if len(writeErrors) > 0 {
+		return result, mongo.BulkWriteException{WriteErrors: writeErrors}
+	}
+	return result, nil
+}
+
+func operationID(operation mongo.WriteModel) string {
+	switch o := operation.(type) {
+	case *mongo.UpdateOneModel:
+		return o.Filter.(bson.M)["_id"].(string)
+	case *mongo.DeleteOneModel:
+		return o.Filter.(bson.M)["_id"].(string)
+	}
+	return ""
+}
+
+//fakeDriver source driver recording the fetch date updates
+type fakeDriver struct {
+	mu      sync.Mutex
+	updates map[string]bool
+}
+
+func (f *fakeDriver) Dialect() string { return testDialect }
+
+func (f *fakeDriver) Open(sqlCredentialProvider *model.SQLCredentialProvider, readOnly bool) (*sql.DB, error) {
+	return nil, nil
+}
+
+func (f *fakeDriver) ValidateSchema(ctx context.Context, connData *model.SQLConnectionData) error {
+	return nil
+}
+
+func (f *fakeDriver) GetRegisteredDeviceData(ctx context.Context, connData *model.SQLConnectionData) *model.RegisteredDeviceRequestData {
+	return nil
+}
+
+func (f *fakeDriver) GetSpatialData(ctx context.Context, connData *model.SQLConnectionData) *model.SpatialRequestData {
+	return nil
+}
+
+func (f *fakeDriver) UpdateDataSyncFetchDate(connData *model.SQLConnectionData, canUpdateDeviceDate bool, deviceDate time.Time, canUpdateSpatialDate bool, spatialDate time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates[connData.ServerID] = canUpdateDeviceDate
+	return true, nil
+}
+
+func (f *fakeDriver) GetUnAuthDeviceDetails(connData *model.SQLConnectionData, deviceList []string) ([]model.UnAuthDeviceResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeDriver) UpdUnAuthDeviceDetails(connData *model.SQLConnectionData, applicationServerID int, deviceList []model.UnAuthDeviceResponse) (int64, error) {
+	return 0, nil
+}
+
+//setupStores replacing redis/mongo with the memory providers
+func setupStores(t *testing.T, failIDs ...string) (*cm.MemoryProvider, *failingStore) {
+	t.Helper()
+
+	redisKeyForRegisteredDevice = "test:registereddevices"
+	redisKeyForTestDevice = "test:testdevices"
+	redisKeyForCommunicationGroup = "test:communicationgroups"
+	redisKeyForDeviceCommandChannel = "test:devicecommands"
+	singlePartitionDeviceCount = 1000
+
+	configStore := cm.NewMemoryProvider()
+	config.SetConfigProvider(configStore)
+
+	store := &failingStore{MemoryProvider: sm.NewMemoryProvider(), failIDs: make(map[string]bool)}
+	for _, id := range failIDs {
+		store.failIDs[id] = true
+	}
+	entity.SetStoreProvider(store)
+
+	return configStore, store
+}
+
+func installedDevice(deviceID, serverID string) *model.RegisteredDeviceData {
+	return &model.RegisteredDeviceData{
+		DeviceID:              deviceID,
+		ServerID:              serverID,
+		TenantUID:             "tenant-" + serverID,
+		TenantGroupUID:        "group-" + serverID,
+		DeviceTypeID:          1,
+		ParserID:              1,
+		Active:                1,
+		DeviceMasterStatusUno: 5,
+		CommunicationGroupID:  -1,
+	}
+}
+
+func testDevices() []*model.RegisteredDeviceData {
+	return []*model.RegisteredDeviceData{
+		installedDevice("d1", "1"),
+		installedDevice("d2", "1"),
+		installedDevice("d3", "2"),
+		installedDevice("d4", "2"),
+		installedDevice("d5", "2"),
+	}
+}
+
+func mongoDeviceIDs(t *testing.T, store *failingStore) []string {
+	t.Helper()
+
+	docs, err := store.Get(context.Background(), "tblvehiclerecentupdates", bson.M{}, nil)
+	if err != nil {
+		t.Fatalf("mongo get: %v", err)
+	}
+
+	ids := make([]string, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d["_id"].(string))
+	}
+	return ids
+}
+
+func TestSaveDataToStoreWritesInChunksAndReportsFailedDevices(t *testing.T) {
+	configStore, store := setupStores(t, "d4")
+	entity.SetBulkWriteSettings(entity.BulkWriteSettings{ChunkSize: 2, Ordered: false, Timeout: time.Second})
+
+	//failed device stays as test device
+	configStore.HMSet(redisKeyForTestDevice, map[string]interface{}{"d1": "{}", "d4": "{}"})
+	//already registered device is notified to the listener, with the saved comm. group
+	configStore.HSet(redisKeyForRegisteredDevice, "d1", `{"deviceid":"d1","communicationgroupid":0}`)
+	configStore.HSet(redisKeyForCommunicationGroup, "0", "1")
+
+	canUpdate, failedIDs := saveDataToStore(testDevices())
+
+	if !canUpdate {
+		t.Errorf("canUpdate = false, want true")
+	}
+	if !reflect.DeepEqual(store.chunks, []int{2, 2, 1}) {
+		t.Errorf("chunks = %v, want [2 2 1]", store.chunks)
+	}
+	if !reflect.DeepEqual(failedIDs, []string{"d4"}) {
+		t.Errorf("failedIDs = %v, want [d4]", failedIDs)
+	}
+	if ids := mongoDeviceIDs(t, store); !reflect.DeepEqual(ids, []string{"d1", "d2", "d3", "d5"}) {
+		t.Errorf("mongo devices = %v, want [d1 d2 d3 d5]", ids)
+	}
+
+	registered, _ := configStore.HGetAll(redisKeyForRegisteredDevice)
+	if len(registered) != 5 {
+		t.Errorf("registered devices = %v, want 5", len(registered))
+	}
+	testDevices, _ := configStore.HGetAll(redisKeyForTestDevice)
+	if _, ok := testDevices["d4"]; !ok || len(testDevices) != 1 {
+		t.Errorf("test devices = %v, want only d4", testDevices)
+	}
+	if published := configStore.Published(redisKeyForDeviceCommandChannel); len(published) != 1 {
+		t.Errorf("device commands published = %v, want 1", len(published))
+	}
+	//new devices are allocated to the saved comm. group
+	if groups, _ := configStore.HGetAll(redisKeyForCommunicationGroup); groups["0"] != "5" {
+		t.Errorf("comm. group 0 count = %v, want 5", groups["0"])
+	}
+}
+
+func TestSaveDataToStoreOrderedStopsOnFirstFailure(t *testing.T) {
+	_, store := setupStores(t, "d2")
+	entity.SetBulkWriteSettings(entity.BulkWriteSettings{ChunkSize: 2, Ordered: true, Timeout: time.Second})
+
+	_, failedIDs := saveDataToStore(testDevices())
+	sort.Strings(failedIDs)
+
+	//rest of the chunks are not executed
+	if !reflect.DeepEqual(store.chunks, []int{2}) {
+		t.Errorf("chunks = %v, want [2]", store.chunks)
+	}
+	if !reflect.DeepEqual(failedIDs, []string{"d2", "d3", "d4", "d5"}) {
+		t.Errorf("failedIDs = %v, want [d2 d3 d4 d5]", failedIDs)
+	}
+	if ids := mongoDeviceIDs(t, store); !reflect.DeepEqual(ids, []string{"d1"}) {
+		t.Errorf("mongo devices = %v, want [d1]", ids)
+	}
+}
+
+func TestDeviceFetchDateNotUpdatedForFailedServers(t *testing.T) {
+	setupStores(t, "d4")
+	entity.SetBulkWriteSettings(entity.BulkWriteSettings{ChunkSize: 2, Ordered: false, Timeout: time.Second})
+
+	driver := &fakeDriver{updates: make(map[string]bool)}
+	sourcedriver.Register(driver)
+
+	devices := testDevices()
+	canUpdate, failedIDs := saveDataToStore(devices)
+	failedServers := getServersOfDevices(devices, failedIDs)
+
+	fetchedOn := time.Now()
+	tasks := []*model.SQLConnectionData{{ServerID: "1", Dialect: testDialect}, {ServerID: "2", Dialect: testDialect}}
+	fetchData := map[string]model.DataFetchRequestData{
+		"1": {DeviceData: fetchedOn},
+		"2": {DeviceData: fetchedOn},
+	}
+	saveJobWorkerStatus(tasks, canUpdate, false, fetchData, failedServers)
+
+	if want := map[string]bool{"1": true, "2": false}; !reflect.DeepEqual(driver.updates, want) {
+		t.Errorf("device fetch date updates = %v, want %v", driver.updates, want)
+	}
+}
+
+func TestSaveDataToStoreKeepsDevicesOfFailedDeletes(t *testing.T) {
+	configStore, store := setupStores(t, "d3")
+	entity.SetBulkWriteSettings(entity.BulkWriteSettings{ChunkSize: 2, Ordered: false, Timeout: time.Second})
+
+	//d1 and d3 registered on comm. group 0, both deactivated on the source
+	configStore.HMSet(redisKeyForRegisteredDevice, map[string]interface{}{
+		"d1": `{"deviceid":"d1","communicationgroupid":0}`,
+		"d3": `{"deviceid":"d3","communicationgroupid":0}`,
+	})
+	configStore.HSet(redisKeyForCommunicationGroup, "0", "2")
+	store.InsertMany(context.Background(), "tblvehiclerecentupdates", []interface{}{bson.M{"_id": "d1"}, bson.M{"_id": "d3"}})
+
+	deactivated := func() []*model.RegisteredDeviceData {
+		d1, d3 := installedDevice("d1", "1"), installedDevice("d3", "2")
+		d1.Active, d3.Active = 0, 0
+		return []*model.RegisteredDeviceData{d1, d3}
+	}
+
+	_, failedIDs := saveDataToStore(deactivated())
+	if !reflect.DeepEqual(failedIDs, []string{"d3"}) {
+		t.Errorf("failedIDs = %v, want [d3]", failedIDs)
+	}
+	if ids := mongoDeviceIDs(t, store); !reflect.DeepEqual(ids, []string{"d3"}) {
+		t.Errorf("mongo devices = %v, want [d3]", ids)
+	}
+	//d3 keeps its comm. group, so it is de-registered again on the next cycle
+	registered, _ := configStore.HGetAll(redisKeyForRegisteredDevice)
+	if _, ok := registered["d3"]; !ok || len(registered) != 1 {
+		t.Errorf("registered devices = %v, want only d3", registered)
+	}
+	if groups, _ := configStore.HGetAll(redisKeyForCommunicationGroup); groups["0"] != "1" {
+		t.Errorf("comm. group 0 count = %v, want 1", groups["0"])
+	}
+
+	//delete succeeds on the next cycle
+	delete(store.failIDs, "d3")
+	if _, failedIDs = saveDataToStore(deactivated()[1:]); len(failedIDs) != 0 {
+		t.Errorf("failedIDs = %v, want none", failedIDs)
+	}
+	if ids := mongoDeviceIDs(t, store); len(ids) != 0 {
+		t.Errorf("mongo devices = %v, want none", ids)
+	}
+	if registered, _ := configStore.HGetAll(redisKeyForRegisteredDevice); len(registered) != 0 {
+		t.Errorf("registered devices = %v, want none", registered)
+	}
+	if groups, _ := configStore.HGetAll(redisKeyForCommunicationGroup); groups["0"] != "0" {
+		t.Errorf("comm. group 0 count = %v, want 0", groups["0"])
+	}
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"sync"
 	"time"
 )
 
@@ -36,6 +37,12 @@ type RegisteredDeviceData struct {
 	Active                int    `json:"active"`
 
 	DiversionDetails []*DeviceDiversionData `json:"diversiondetails"`
+
+	//LastModifiedDate on the source server(optional column), used to resolve the duplicates across the servers
+	LastModifiedDate *time.Time `json:"lastmodifieddate,omitempty"`
+
+	//ServerID source SQL server of the device(not stored)
+	ServerID string `json:"-"`
 }
 
 //DeviceCommGroupData ...
@@ -55,17 +62,219 @@ type DBCredentialProvider struct {
 
 //SQLCredentialProvider ...
 type SQLCredentialProvider struct {
+	//Dialect of the source database, sqlserver(default) or postgres
+	Dialect string `json:"dialect"`
+	//SchemaVersion selects the query profile(procedure/parameter names) of the server, empty => default
+	SchemaVersion string `json:"schemaversion"`
+
 	HostName   string `json:"hostname"`
 	DBName     string `json:"dbname"`
 	UserName   string `json:"username"`
 	Password   string `json:"password"`
 	MainPageID string `json:"mainpageid"`
+	SRID       int    `json:"srid"`
+
+	//connection settings, defaults are applied by ApplyDefaults
+	Encrypt                string `json:"encrypt"`
+	TrustServerCertificate bool   `json:"trustservercertificate"`
+	ConnectionTimeoutInSec int    `json:"connectiontimeoutinsec"`
+	AppName                string `json:"appname"`
+	MaxOpenConns           int    `json:"maxopenconns"`
+	MaxIdleConns           int    `json:"maxidleconns"`
+	ConnMaxLifetimeInSec   int    `json:"connmaxlifetimeinsec"`
+
+	//named instance/port, database mirroring partner and availability group settings
+	InstanceName        string `json:"instancename"`
+	Port                int    `json:"port"`
+	FailoverPartner     string `json:"failoverpartner"`
+	FailoverPort        int    `json:"failoverport"`
+	MultiSubnetFailover bool   `json:"multisubnetfailover"`
+	ReadIntent          bool   `json:"readintent"`
+
+	//MainPageIDs main page ids of the server(along with MainPageID, which may also be comma separated)
+	MainPageIDs []string `json:"mainpageids"`
+
+	//AuthMode sqllogin(default), ntlm(username as DOMAIN\user), kerberos or azuread
+	AuthMode string `json:"authmode"`
+	//kerberos keytab settings(user name as the principal, krb5.conf of KRB5_CONFIG or /etc/krb5.conf when not configured)
+	KeytabFile     string `json:"keytabfile"`
+	Realm          string `json:"realm"`
+	SPN            string `json:"spn"`
+	Krb5ConfigFile string `json:"krb5configfile"`
+	//azure ad service principal(client secret) or a pre-issued access token
+	TenantID     string `json:"tenantid"`
+	ClientID     string `json:"clientid"`
+	ClientSecret string `json:"clientsecret"`
+	AccessToken  string `json:"accesstoken"`
+}
+
+//source database dialects
+const (
+	SourceDialectSQLServer = "sqlserver"
+	SourceDialectPostgres  = "postgres"
+)
+
+//SQL server auth modes
+const (
+	SQLAuthModeSQLLogin = "sqllogin"
+	SQLAuthModeNTLM     = "ntlm"
+	SQLAuthModeKerberos = "kerberos"
+	SQLAuthModeAzureAD  = "azuread"
+)
+
+//SQL server connection defaults
+const (
+	DefaultSQLConnectionTimeoutInSec = 30
+	DefaultSQLAppName                = "data-sync-agent"
+	DefaultSQLMaxOpenConns           = 10
+	DefaultSQLMaxIdleConns           = 2
+	DefaultSQLConnMaxLifetimeInSec   = 1800
+)
+
+//ApplyDefaults settings not configured on the server credentials(encrypt is left to the driver default)
+func (p *SQLCredentialProvider) ApplyDefaults() {
+	if p.ConnectionTimeoutInSec <= 0 {
+		p.ConnectionTimeoutInSec = DefaultSQLConnectionTimeoutInSec
+	}
+	if p.AppName == "" {
+		p.AppName = DefaultSQLAppName
+	}
+	if p.MaxOpenConns <= 0 {
+		p.MaxOpenConns = DefaultSQLMaxOpenConns
+	}
+	if p.MaxIdleConns <= 0 {
+		p.MaxIdleConns = DefaultSQLMaxIdleConns
+	}
+	if p.MaxIdleConns > p.MaxOpenConns {
+		p.MaxIdleConns = p.MaxOpenConns
+	}
+	if p.ConnMaxLifetimeInSec <= 0 {
+		p.ConnMaxLifetimeInSec = DefaultSQLConnMaxLifetimeInSec
+	}
 }
 
 //SQLConnectionData ...
 type SQLConnectionData struct {
 	ServerID string
-	DB       *sql.DB
+	//Dialect of the source database(selects the source driver)
+	Dialect string
+	//SchemaVersion of the source database(selects the query profile)
+	SchemaVersion string
+	//DB primary, used for the updates
+	DB *sql.DB
+	//ReadDB readable secondary(read intent), nil => fetches go to the primary
+	ReadDB *sql.DB
+	//ReadLagMargin subtracted from the fetch date of the secondary, the rows not replicated yet are fetched again
+	ReadLagMargin time.Duration
+	//SRID of the spatial data stored on this server
+	SRID int
+	//MainPageIDs main page ids of this server only, the device data is scoped to these
+	MainPageIDs []string
+	//ConnectionTimeout used for the health pings
+	ConnectionTimeout time.Duration
+
+	//capabilities/health are changed at runtime, so guarded
+	mu              sync.RWMutex
+	capabilities    ServerCapabilities
+	unhealthy       bool
+	schemaValidated bool
+}
+
+//ReaderDB connection used for the fetches
+func (c *SQLConnectionData) ReaderDB() *sql.DB {
+	if c.ReadDB != nil {
+		return c.ReadDB
+	}
+	return c.DB
+}
+
+//SchemaValidated whether the procedures of the server were validated(unreachable servers are validated on the first successful ping)
+func (c *SQLConnectionData) SchemaValidated() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.schemaValidated
+}
+
+//SetSchemaValidated ...
+func (c *SQLConnectionData) SetSchemaValidated(validated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schemaValidated = validated
+}
+
+//FetchWatermark fetch date persisted on the primary, the replica lag margin is kept when the data is read from the secondary
+func (c *SQLConnectionData) FetchWatermark(dataFetchedOn time.Time) time.Time {
+	if c.ReadDB == nil || dataFetchedOn.IsZero() {
+		return dataFetchedOn
+	}
+	return dataFetchedOn.Add(-c.ReadLagMargin)
+}
+
+//Healthy whether the last ping of the server succeeded
+func (c *SQLConnectionData) Healthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.unhealthy
+}
+
+//SetHealthy ...
+func (c *SQLConnectionData) SetHealthy(healthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unhealthy = !healthy
+}
+
+//Capabilities features enabled on this server
+func (c *SQLConnectionData) Capabilities() ServerCapabilities {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.capabilities
+}
+
+//SetCapabilities ...
+func (c *SQLConnectionData) SetCapabilities(capabilities ServerCapabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capabilities = capabilities
+}
+
+//QueryProfile procedure/parameter names of a source schema version, empty values are taken from the default profile
+type QueryProfile struct {
+	SchemaVersion string `json:"schemaversion"`
+
+	RegisteredDeviceProcedure string `json:"registereddeviceprocedure"`
+	MainPageIDParam           string `json:"mainpageidparam"`
+	DataFetchedOnParam        string `json:"datafetchedonparam"`
+
+	SpatialProcedure string `json:"spatialprocedure"`
+	//SpatialResultSets result-set index by spatial entity name, over the index of the entity descriptor
+	SpatialResultSets map[string]int `json:"spatialresultsets"`
+
+	UpdateFetchDateProcedure  string `json:"updatefetchdateprocedure"`
+	CanUpdateDeviceDateParam  string `json:"canupdatedevicedateparam"`
+	DeviceDateParam           string `json:"devicedateparam"`
+	CanUpdateSpatialDateParam string `json:"canupdatespatialdateparam"`
+	SpatialDateParam          string `json:"spatialdateparam"`
+	StatusParam               string `json:"statusparam"`
+
+	UnAuthDeviceProcedure    string `json:"unauthdeviceprocedure"`
+	UnAuthDeviceListParam    string `json:"unauthdevicelistparam"`
+	UpdUnAuthDeviceProcedure string `json:"updunauthdeviceprocedure"`
+	UpdUnAuthServerIDParam   string `json:"updunauthserveridparam"`
+	UpdUnAuthDeviceListParam string `json:"updunauthdevicelistparam"`
+}
+
+//ServerCapabilities per server feature flags, stored on the server hash next to the credentials
+type ServerCapabilities struct {
+	DeviceSync   bool `json:"devicesync"`
+	SpatialSync  bool `json:"spatialsync"`
+	Diversion    bool `json:"diversion"`
+	UnAuthLookup bool `json:"unauthlookup"`
+}
+
+//DefaultServerCapabilities all the features are enabled, unless turned off
+func DefaultServerCapabilities() ServerCapabilities {
+	return ServerCapabilities{DeviceSync: true, SpatialSync: true, Diversion: true, UnAuthLookup: true}
 }
 
 //ListenerDeviceCommandType command type received in the consumer
@@ -99,59 +308,118 @@ type MongoDeviceData struct {
 	DiversionDetails     []*DeviceDiversionData `json:"diversiondetails"`
 }
 
+//MongoProjection describes a mongo collection maintained from the registered(installed) devices
+type MongoProjection struct {
+	Name string `json:"name"`
+	//Collection name, {tenantuid} is replaced with the device tenant uid(per-tenant collection)
+	Collection string `json:"collection"`
+	//Fields target field => source device field(MongoDeviceData json name)
+	Fields map[string]string `json:"fields"`
+	//InsertDefaults set only on insert, $now => current time, $epochobjectid => object id of 1990-01-01
+	InsertDefaults map[string]interface{} `json:"insertdefaults"`
+	Disabled       bool                   `json:"disabled"`
+}
+
+//DeviceListenerState state of the device on the IOT listener, collected from the mongo change stream
+type DeviceListenerState struct {
+	DeviceID           string    `json:"deviceid"`
+	EverConnected      bool      `json:"everconnected"`
+	LastDeviceDateTime time.Time `json:"lastdevicedatetime"`
+	LastSeenOn         time.Time `json:"lastseenon"`
+}
+
 //Postgre == Geo spatial models....
 
+//DefaultSRID WGS 84, spatial data is always stored on PostGIS with this SRID
+const DefaultSRID = 4326
+
+//SpatialEntityDescriptor describes a spatial layer, how it is read from the SQL server result-set and where it is saved on PostGIS
+type SpatialEntityDescriptor struct {
+	Name               string   `json:"name"`
+	ResultSetIndex     int      `json:"resultsetindex"`
+	KeyColumns         []string `json:"keycolumns"`
+	UIDColumn          string   `json:"uidcolumn"`
+	TenantColumn       string   `json:"tenantcolumn"`
+	AttributeColumns   []string `json:"attributecolumns"`
+	TargetTable        string   `json:"targettable"`
+	ConflictConstraint string   `json:"conflictconstraint"`
+
+	Lifecycle SpatialLifecyclePolicy `json:"lifecycle"`
+	//HistoryTable versioned mode, each change is also written with valid-from/valid-to range(when set)
+	HistoryTable string `json:"historytable"`
+}
+
+//SpatialLifecycleMode what happens to deactivated(tombstone) spatial objects
+type SpatialLifecycleMode string
+
+const (
+	//SpatialLifecycleKeep tombstones are kept forever
+	SpatialLifecycleKeep SpatialLifecycleMode = "keep"
+	//SpatialLifecycleDelete tombstones are deleted after the retention days
+	SpatialLifecycleDelete SpatialLifecycleMode = "delete"
+	//SpatialLifecycleArchive tombstones are moved to the archive table after the retention days
+	SpatialLifecycleArchive SpatialLifecycleMode = "archive"
+)
+
+//SpatialLifecyclePolicy lifecycle of the deactivated spatial objects, executed by the janitor
+type SpatialLifecyclePolicy struct {
+	Mode          SpatialLifecycleMode `json:"mode"`
+	RetentionDays int                  `json:"retentiondays"`
+	ArchiveTable  string               `json:"archivetable"`
+}
+
 //SpatialRequestData ...
 type SpatialRequestData struct {
-	GeofenceData  []*GeofenceData
-	AreaData      []*AreaData
-	ZoneData      []*ZoneData
-	NoGoAreaData  []*NoGoAreaData
+	//Data spatial objects grouped by entity(descriptor) name
+	Data          map[string][]*SpatialData
 	DataFetchDate time.Time
 }
 
-//GeofenceData ...
-type GeofenceData struct {
-	TenantUID        string
-	TenantGroupUID   string
-	GeofenceUID      string
-	GeofenceNameEN   string
-	GeofenceNameOL   string
-	GeofenceTypeID   int
-	IsApproved       int
-	Active           int
-	LastModifiedDate time.Time
-	OgrGeometry      string
+//Count total spatial objects across all the entities
+func (s *SpatialRequestData) Count() int {
+	count := 0
+	for _, d := range s.Data {
+		count = count + len(d)
+	}
+	return count
 }
 
-//AreaData ...
-type AreaData struct {
-	TenantUID        string
-	TenantGroupUID   string
-	AreaUID          string
+//SpatialData single spatial object of any registered entity
+type SpatialData struct {
+	Keys             map[string]interface{}
+	Attributes       map[string]interface{}
 	Active           int
 	LastModifiedDate time.Time
 	OgrGeometry      string
+	SourceSRID       int
 }
 
-//ZoneData ...
-type ZoneData struct {
-	TenantUID        string
-	TenantGroupUID   string
-	ZoneUID          string
-	Active           int
-	LastModifiedDate time.Time
-	OgrGeometry      string
+//SpatialChangeAction action applied on the spatial object
+type SpatialChangeAction string
+
+const (
+	//SpatialUpsert spatial object added/modified
+	SpatialUpsert SpatialChangeAction = "upsert"
+	//SpatialDeactivate spatial object geometry removed
+	SpatialDeactivate SpatialChangeAction = "deactivate"
+)
+
+//SpatialBoundingBox bounding box(WGS 84) of the spatial object
+type SpatialBoundingBox struct {
+	MinX float64 `json:"minx"`
+	MinY float64 `json:"miny"`
+	MaxX float64 `json:"maxx"`
+	MaxY float64 `json:"maxy"`
 }
 
-//NoGoAreaData  ...
-type NoGoAreaData struct {
-	TenantUID           string
-	TenantGroupUID      string
-	NoGoAreaGeofenceUID string
-	Active              int
-	LastModifiedDate    time.Time
-	OgrGeometry         string
+//SpatialChangeEvent notified to the downstream services after the spatial data committed
+type SpatialChangeEvent struct {
+	TenantUID   string              `json:"tenantuid"`
+	EntityType  string              `json:"entitytype"`
+	UID         string              `json:"uid"`
+	Action      SpatialChangeAction `json:"action"`
+	BoundingBox *SpatialBoundingBox `json:"boundingbox,omitempty"`
+	ChangedOn   time.Time           `json:"changedon"`
 }
 
 //DataFetchRequestData ...
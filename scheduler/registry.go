@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"data-sync-agent/utils/logger"
+)
+
+//ErrJobExists indicates a job registered twice with the same name
+var ErrJobExists = errors.New("job already registered")
+
+//RunFunc executes a single run of the job, ctx is cancelled when the scheduler stops
+type RunFunc func(ctx context.Context)
+
+//JobConfig configurable values of the job(env/redis json), missing values keep the current ones
+type JobConfig struct {
+	Schedule       string `json:"schedule"`
+	Enabled        *bool  `json:"enabled"`
+	MaxConcurrency int    `json:"maxconcurrency"`
+}
+
+//Job named job with its schedule and concurrency limit
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Enabled  bool
+	//max. runs of the job at the same time, a tick is skipped when reached(default 1)
+	MaxConcurrency int
+	//first run immediately, instead of after the first schedule tick
+	RunOnStart bool
+	Run        RunFunc
+
+	running chan struct{}
+}
+
+//JobStatus ...
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Enabled  bool      `json:"enabled"`
+	Running  int       `json:"running"`
+	LastRun  time.Time `json:"lastrun"`
+	NextRun  time.Time `json:"nextrun"`
+}
+
+//Registry holds the jobs and runs the enabled ones
+type Registry struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	lastRun map[string]time.Time
+	nextRun map[string]time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+//NewRegistry ...
+func NewRegistry() *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		jobs:    make(map[string]*Job),
+		lastRun: make(map[string]time.Time),
+		nextRun: make(map[string]time.Time),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+//Register adds the job, must be called before Start
+func (r *Registry) Register(job *Job) error {
+	if job == nil || job.Name == "" || job.Run == nil || job.Schedule == nil {
+		return fmt.Errorf("job name, schedule and run are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.jobs[job.Name]; ok {
+		return fmt.Errorf("%w: %v", ErrJobExists, job.Name)
+	}
+
+	if job.MaxConcurrency <= 0 {
+		job.MaxConcurrency = 1
+	}
+
+	r.jobs[job.Name] = job
+	return nil
+}
+
+//Configure applies the job configs(name => config json) over the registered values
+func (r *Registry) Configure(values map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, v := range values {
+		job, ok := r.jobs[name]
+		if !ok {
+			logger.Log().Warn(fmt.Sprintf("scheduler Configure job %v is not registered", name))
+			continue
+		}
+
+		jobConfig := JobConfig{}
+		if err := json.Unmarshal([]byte(v), &jobConfig); err != nil {
+			logger.Log().Error(fmt.Sprintf("scheduler Configure %v Unmarshal Error : %v", name, err.Error()))
+			continue
+		}
+
+		if jobConfig.Schedule != "" {
+			schedule, err := ParseSchedule(jobConfig.Schedule)
+			if err != nil {
+				logger.Log().Error(fmt.Sprintf("scheduler Configure %v Error : %v", name, err.Error()))
+				continue
+			}
+			job.Schedule = schedule
+		}
+		if jobConfig.Enabled != nil {
+			job.Enabled = *jobConfig.Enabled
+		}
+		if jobConfig.MaxConcurrency > 0 {
+			job.MaxConcurrency = jobConfig.MaxConcurrency
+		}
+	}
+}
+
+//Start runs the enabled jobs on their schedule
+func (r *Registry) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if !job.Enabled {
+			logger.Log().Info(fmt.Sprintf("Job %v is disabled", job.Name))
+			continue
+		}
+
+		job.running = make(chan struct{}, job.MaxConcurrency)
+		logger.Log().Info(fmt.Sprintf("Job %v Starting!! schedule : %v, concurrency : %v", job.Name, job.Schedule, job.MaxConcurrency))
+
+		r.wg.Add(1)
+		go r.loop(job)
+	}
+}
+
+//Stop stops scheduling and waits for the running jobs, false when the timeout elapsed first
+func (r *Registry) Stop(timeout time.Duration) bool {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+//Status of all the jobs, ordered by name
+func (r *Registry) Status() []JobStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resp := make([]JobStatus, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		resp = append(resp, JobStatus{
+			Name:     job.Name,
+			Schedule: fmt.Sprintf("%v", job.Schedule),
+			Enabled:  job.Enabled,
+			Running:  len(job.running),
+			LastRun:  r.lastRun[job.Name],
+			NextRun:  r.nextRun[job.Name],
+		})
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Name < resp[j].Name
+	})
+	return resp
+}
+
+//loop waits for the schedule ticks and triggers the job
+func (r *Registry) loop(job *Job) {
+	defer r.wg.Done()
+
+	if job.RunOnStart {
+		r.trigger(job)
+	}
+
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			logger.Log().Error(fmt.Sprintf("Job %v schedule %v has no next run", job.Name, job.Schedule))
+			return
+		}
+
+		r.mu.Lock()
+		r.nextRun[job.Name] = next
+		r.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		r.trigger(job)
+	}
+}
+
+//trigger runs the job when the concurrency limit allows
+func (r *Registry) trigger(job *Job) {
+	select {
+	case job.running <- struct{}{}:
+	default:
+		logger.Log().Warn(fmt.Sprintf("Job %v skipped, %v run(s) still in progress", job.Name, job.MaxConcurrency))
+		return
+	}
+
+	r.mu.Lock()
+	r.lastRun[job.Name] = time.Now().UTC()
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-job.running }()
+
+		job.Run(r.ctx)
+	}()
+}
@@ -0,0 +1,157 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func noop(ctx context.Context) {}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+
+	invalid := []*Job{
+		nil,
+		{Schedule: IntervalSchedule{Interval: time.Second}, Run: noop},
+		{Name: "job", Run: noop},
+		{Name: "job", Schedule: IntervalSchedule{Interval: time.Second}},
+	}
+	for _, job := range invalid {
+		if err := r.Register(job); err == nil {
+			t.Errorf("Register(%+v) error = nil, want error", job)
+		}
+	}
+
+	job := &Job{Name: "job", Schedule: IntervalSchedule{Interval: time.Second}, Run: noop}
+	if err := r.Register(job); err != nil {
+		t.Fatalf("Register error : %v", err)
+	}
+	if job.MaxConcurrency != 1 {
+		t.Errorf("MaxConcurrency = %v, want default 1", job.MaxConcurrency)
+	}
+	if err := r.Register(&Job{Name: "job", Schedule: IntervalSchedule{Interval: time.Second}, Run: noop}); !errors.Is(err, ErrJobExists) {
+		t.Errorf("Register twice error = %v, want %v", err, ErrJobExists)
+	}
+}
+
+func TestRegistryConfigure(t *testing.T) {
+	r := NewRegistry()
+	job := &Job{Name: "job", Schedule: IntervalSchedule{Interval: time.Second}, Enabled: true, Run: noop}
+	r.Register(job)
+
+	r.Configure(map[string]string{"job": `{"schedule":"*/5 * * * *","enabled":false,"maxconcurrency":3}`, "unknown": `{"enabled":true}`})
+	if _, ok := job.Schedule.(*CronSchedule); !ok || job.Enabled || job.MaxConcurrency != 3 {
+		t.Errorf("job = %v, enabled %v, concurrency %v, want cron, disabled, 3", job.Schedule, job.Enabled, job.MaxConcurrency)
+	}
+
+	//invalid config is skipped, missing values are kept
+	r.Configure(map[string]string{"job": `{"schedule":"every day","enabled":true}`})
+	if job.Enabled {
+		t.Errorf("job enabled by an invalid config")
+	}
+	r.Configure(map[string]string{"job": `not json`})
+	r.Configure(map[string]string{"job": `{"enabled":true}`})
+	if _, ok := job.Schedule.(*CronSchedule); !ok || !job.Enabled || job.MaxConcurrency != 3 {
+		t.Errorf("job = %v, enabled %v, concurrency %v, want cron, enabled, 3", job.Schedule, job.Enabled, job.MaxConcurrency)
+	}
+}
+
+func TestRegistryRunsOnlyEnabledJobs(t *testing.T) {
+	r := NewRegistry()
+
+	var enabledRuns, disabledRuns int32
+	r.Register(&Job{Name: "enabled", Schedule: IntervalSchedule{Interval: 5 * time.Millisecond}, Enabled: true, RunOnStart: true,
+		Run: func(ctx context.Context) { atomic.AddInt32(&enabledRuns, 1) }})
+	r.Register(&Job{Name: "disabled", Schedule: IntervalSchedule{Interval: 5 * time.Millisecond}, RunOnStart: true,
+		Run: func(ctx context.Context) { atomic.AddInt32(&disabledRuns, 1) }})
+
+	r.Start()
+	time.Sleep(50 * time.Millisecond)
+	if !r.Stop(time.Second) {
+		t.Fatalf("Stop timed out")
+	}
+
+	if atomic.LoadInt32(&enabledRuns) < 2 {
+		t.Errorf("enabled job runs = %v, want several", enabledRuns)
+	}
+	if atomic.LoadInt32(&disabledRuns) != 0 {
+		t.Errorf("disabled job runs = %v, want 0", disabledRuns)
+	}
+
+	status := r.Status()
+	if len(status) != 2 || status[0].Name != "disabled" || status[1].Name != "enabled" {
+		t.Fatalf("status = %+v, want disabled and enabled", status)
+	}
+	if status[1].LastRun.IsZero() || !status[0].LastRun.IsZero() {
+		t.Errorf("last runs = %v / %v, want only the enabled job", status[0].LastRun, status[1].LastRun)
+	}
+}
+
+func TestRegistrySkipsTicksOverMaxConcurrency(t *testing.T) {
+	r := NewRegistry()
+
+	var mu sync.Mutex
+	running, maxRunning, runs := 0, 0, 0
+	release := make(chan struct{})
+
+	r.Register(&Job{Name: "slow", Schedule: IntervalSchedule{Interval: 2 * time.Millisecond}, Enabled: true, RunOnStart: true, MaxConcurrency: 2,
+		Run: func(ctx context.Context) {
+			mu.Lock()
+			running++
+			runs++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}})
+
+	r.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	if status := r.Status(); status[0].Running != 2 {
+		t.Errorf("running = %v, want 2", status[0].Running)
+	}
+
+	close(release)
+	if !r.Stop(time.Second) {
+		t.Fatalf("Stop timed out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != 2 {
+		t.Errorf("max. concurrent runs = %v, want 2", maxRunning)
+	}
+	if runs < 2 {
+		t.Errorf("runs = %v, want at least 2", runs)
+	}
+}
+
+func TestRegistryStopTimesOutOnStuckJobs(t *testing.T) {
+	r := NewRegistry()
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	r.Register(&Job{Name: "stuck", Schedule: IntervalSchedule{Interval: time.Hour}, Enabled: true, RunOnStart: true,
+		Run: func(ctx context.Context) { <-stuck }})
+
+	r.Start()
+	time.Sleep(10 * time.Millisecond)
+
+	if r.Stop(20 * time.Millisecond) {
+		t.Errorf("Stop = true, want timed out")
+	}
+}
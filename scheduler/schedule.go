@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ErrInvalidSchedule indicates a schedule spec which is neither an interval nor a cron expression
+var ErrInvalidSchedule = errors.New("invalid job schedule")
+
+//Schedule returns the next run time after the given time
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+//IntervalSchedule runs every interval
+type IntervalSchedule struct {
+	Interval time.Duration
+}
+
+//Next ...
+func (s IntervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(s.Interval)
+}
+
+func (s IntervalSchedule) String() string {
+	return "@every " + s.Interval.String()
+}
+
+//CronSchedule standard 5 field cron expression(minute hour day-of-month month day-of-week), evaluated in UTC
+type CronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+//Next ...
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	//bounded search, an expression may never match(e.g. 31st of february)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+//dayMatches day-of-month OR day-of-week when both are restricted(cron semantics)
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+//ParseSchedule interval("15s", "@every 5m") or cron expression("*/5 * * * *")
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, ErrInvalidSchedule
+	}
+
+	if strings.HasPrefix(spec, "@every ") || !strings.Contains(spec, " ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%w: '%v'", ErrInvalidSchedule, spec)
+		}
+		return IntervalSchedule{Interval: interval}, nil
+	}
+
+	return parseCron(spec)
+}
+
+func parseCron(spec string) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: '%v' requires 5 fields", ErrInvalidSchedule, spec)
+	}
+
+	s := &CronSchedule{spec: spec}
+	bounds := []struct {
+		target   *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7},
+	}
+
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("%w: '%v' => %v", ErrInvalidSchedule, spec, err.Error())
+		}
+		*b.target = bits
+	}
+
+	//sunday is 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow = (s.dow | 1) &^ (1 << 7)
+	}
+
+	//fields starting with * are unrestricted(e.g. */2), as on the standard cron
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+//parseCronField lists of values, ranges and steps(e.g. 1,5,10-20/2,*/15)
+func parseCronField(field string, min, max int) (uint64, error) {
+	bits := uint64(0)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step '%v'", part)
+			}
+			rangePart, step = part[:i], v
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			values := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(values[0])
+			end, err2 = strconv.Atoi(values[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range '%v'", part)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%v'", part)
+			}
+			start, end = v, v
+			//5/15 => from 5 to max
+			if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("'%v' out of range [%v-%v]", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	cases := []struct {
+		spec     string
+		interval time.Duration
+		cron     bool
+		invalid  bool
+	}{
+		{spec: "15s", interval: 15 * time.Second},
+		{spec: "@every 5m", interval: 5 * time.Minute},
+		{spec: " @every 1h ", interval: time.Hour},
+		{spec: "*/5 * * * *", cron: true},
+		{spec: "0 0 1,15 * 1-5", cron: true},
+		{spec: "", invalid: true},
+		{spec: "0s", invalid: true},
+		{spec: "-5m", invalid: true},
+		{spec: "@every often", invalid: true},
+		{spec: "* * * *", invalid: true},
+		{spec: "60 * * * *", invalid: true},
+		{spec: "* 24 * * *", invalid: true},
+		{spec: "* * 0 * *", invalid: true},
+		{spec: "* * * 13 *", invalid: true},
+		{spec: "* * * * 8", invalid: true},
+		{spec: "*/0 * * * *", invalid: true},
+		{spec: "10-5 * * * *", invalid: true},
+		{spec: "a * * * *", invalid: true},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.spec)
+		switch {
+		case c.invalid:
+			if !errors.Is(err, ErrInvalidSchedule) {
+				t.Errorf("ParseSchedule(%q) error = %v, want %v", c.spec, err, ErrInvalidSchedule)
+			}
+		case err != nil:
+			t.Errorf("ParseSchedule(%q) error : %v", c.spec, err)
+		case c.cron:
+			if _, ok := schedule.(*CronSchedule); !ok {
+				t.Errorf("ParseSchedule(%q) = %T, want cron", c.spec, schedule)
+			}
+		default:
+			if interval, ok := schedule.(IntervalSchedule); !ok || interval.Interval != c.interval {
+				t.Errorf("ParseSchedule(%q) = %v, want every %v", c.spec, schedule, c.interval)
+			}
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatalf("parse %v: %v", value, err)
+		}
+		return v
+	}
+
+	//2020-07-01 is a wednesday
+	cases := []struct {
+		spec, after, want string
+	}{
+		{"*/15 * * * *", "2020-07-01 10:07", "2020-07-01 10:15"},
+		{"*/15 * * * *", "2020-07-01 10:15", "2020-07-01 10:30"},
+		{"5/20 * * * *", "2020-07-01 10:26", "2020-07-01 10:45"},
+		{"0 * * * *", "2020-07-01 23:59", "2020-07-02 00:00"},
+		{"0 0 1 1 *", "2020-07-13 08:00", "2021-01-01 00:00"},
+		{"0 9 * * 1-5", "2020-07-03 09:00", "2020-07-06 09:00"},
+		//sunday is 0 or 7
+		{"30 9 * * 0", "2020-07-13 00:00", "2020-07-19 09:30"},
+		{"30 9 * * 7", "2020-07-13 00:00", "2020-07-19 09:30"},
+		//both day fields restricted => either matches
+		{"0 12 1,15 * 5", "2020-07-01 12:00", "2020-07-03 12:00"},
+		{"0 12 10 * 1", "2020-07-01 00:00", "2020-07-06 12:00"},
+		//*/2 day-of-month is unrestricted => both have to match(odd mondays)
+		{"0 0 */2 * 1", "2020-07-01 00:00", "2020-07-13 00:00"},
+		{"0 0 * * */2", "2020-07-01 00:00", "2020-07-02 00:00"},
+		{"0 0 29 2 *", "2021-03-01 00:00", "2024-02-29 00:00"},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) error : %v", c.spec, err)
+		}
+		if got := schedule.Next(at(c.after)); !got.Equal(at(c.want)) {
+			t.Errorf("%q Next(%v) = %v, want %v", c.spec, c.after, got, c.want)
+		}
+	}
+}
+
+func TestCronScheduleNextNeverMatching(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("ParseSchedule error : %v", err)
+	}
+	if next := schedule.Next(time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("Next = %v, want none", next)
+	}
+}
+
+func TestCronScheduleNextInUTC(t *testing.T) {
+	schedule, _ := ParseSchedule("0 9 * * *")
+	zone := time.FixedZone("UTC+5", 5*3600)
+
+	//08:30 UTC
+	next := schedule.Next(time.Date(2020, 7, 1, 13, 30, 0, 0, zone))
+	if want := time.Date(2020, 7, 1, 9, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next = %v, want %v", next, want)
+	}
+}
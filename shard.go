@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"data-sync-agent/election"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+	"data-sync-agent/scheduler"
+	"data-sync-agent/sharding"
+	"data-sync-agent/utils/logger"
+)
+
+//sharding of the sql servers across the replicas(nil => all the servers are synced by this replica)
+var shardMembership *sharding.Membership
+
+//communication group allocation lock across the replicas(nil => single replica)
+var communicationGroupMutex *election.Mutex
+
+//last owned servers, for logging the rebalancing
+var ownedServerIDs string
+
+//startSharding registering this replica as a shard member(optional)
+func startSharding() {
+	redisKeyForShardMembers := helper.GetEnv(helper.RedisKeyForShardMembers)
+	if redisKeyForShardMembers == "" {
+		return
+	}
+
+	//the global jobs(unauth, spatial janitor, watcher restore) are run by the leader only,
+	//without the leader lock every sharded replica would run them
+	if leaderElector == nil {
+		logger.Log().Fatal(fmt.Sprintf("startSharding %v requires %v, exiting", helper.RedisKeyForShardMembers, helper.RedisKeyForLeaderLock))
+	}
+
+	memberTTLInSec, err := strconv.ParseInt(helper.GetEnv(helper.ShardMemberTTLInSec), 10, 64)
+	if err != nil || memberTTLInSec < 3 {
+		memberTTLInSec = 30
+	}
+	memberTTL := time.Duration(memberTTLInSec) * time.Second
+
+	shardMembership = sharding.NewMembership(nil, redisKeyForShardMembers, agentIdentity, memberTTL)
+	if err := shardMembership.Heartbeat(); err != nil {
+		logger.Log().Error(fmt.Sprintf("startSharding Heartbeat Error : %v", err.Error()))
+	}
+
+	communicationGroupMutex = election.NewMutex(nil, redisKeyForShardMembers+":commgrouplock", agentIdentity, time.Minute)
+
+	err = jobRegistry.Register(&scheduler.Job{
+		Name:     jobNameShardHeartbeat,
+		Schedule: scheduler.IntervalSchedule{Interval: memberTTL / 3},
+		Enabled:  true,
+		Run: func(ctx context.Context) {
+			if err := shardMembership.Heartbeat(); err != nil {
+				logger.Log().Error(fmt.Sprintf("Shard Heartbeat Error : %v", err.Error()))
+			}
+		},
+	})
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("startSharding Register Error : %v", err.Error()))
+	}
+
+	logger.Log().Info(fmt.Sprintf("Sharding Starting!! member : %v, ttl : %v", agentIdentity, memberTTL))
+}
+
+//stopSharding leaving the membership, so the servers are taken over on the next cycle
+func stopSharding() {
+	if shardMembership == nil {
+		return
+	}
+	if err := shardMembership.Leave(); err != nil {
+		logger.Log().Error(fmt.Sprintf("stopSharding Error : %v", err.Error()))
+	}
+}
+
+//shardedTasks servers owned by this replica, based on the consistent hash of the server id
+func shardedTasks() []*model.SQLConnectionData {
+	if shardMembership == nil {
+		return sqlConnectionListData
+	}
+
+	members, err := shardMembership.Members()
+	if err != nil {
+		//ownership unknown, skipping the cycle instead of syncing the servers twice
+		logger.Log().Error(fmt.Sprintf("shardedTasks Members Error : %v", err.Error()))
+		return nil
+	}
+
+	//heartbeat not visible yet
+	if !containsString(members, agentIdentity) {
+		members = append(members, agentIdentity)
+	}
+
+	ring := sharding.NewRing(members, 0)
+
+	tasks := make([]*model.SQLConnectionData, 0)
+	serverIDs := make([]string, 0)
+	for _, task := range sqlConnectionListData {
+		if ring.Owner(task.ServerID) == agentIdentity {
+			tasks = append(tasks, task)
+			serverIDs = append(serverIDs, task.ServerID)
+		}
+	}
+
+	if owned := strings.Join(serverIDs, ","); owned != ownedServerIDs {
+		ownedServerIDs = owned
+		logger.Log().Info(fmt.Sprintf("Shard rebalanced, members : %v, owned servers : [%v]", members, owned))
+	}
+
+	return tasks
+}
+
+//lockCommunicationGroups serializing the communication group allocation across the replicas
+func lockCommunicationGroups() (func(), error) {
+	if communicationGroupMutex == nil {
+		return func() {}, nil
+	}
+	return communicationGroupMutex.Lock(2 * time.Minute)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package sharding
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/utils/logger"
+)
+
+//Store redis operations used by the membership(replaced with fakes on tests)
+type Store interface {
+	HSet(key string, field string, value interface{}) error
+	HGetAll(key string) (map[string]string, error)
+	HDel(key string, fields []string) (int64, error)
+}
+
+type configStore struct{}
+
+func (configStore) HSet(key string, field string, value interface{}) error {
+	return config.HSet(key, field, value)
+}
+
+func (configStore) HGetAll(key string) (map[string]string, error) {
+	return config.HGetAll(key)
+}
+
+func (configStore) HDel(key string, fields []string) (int64, error) {
+	return config.HDel(key, fields)
+}
+
+//Membership replicas alive on the redis hash(identity => last heartbeat in unix ms)
+type Membership struct {
+	store    Store
+	key      string
+	identity string
+	ttl      time.Duration
+}
+
+//NewMembership a member is considered gone when its heartbeat is older than the ttl
+func NewMembership(store Store, key string, identity string, ttl time.Duration) *Membership {
+	if store == nil {
+		store = configStore{}
+	}
+	return &Membership{store: store, key: key, identity: identity, ttl: ttl}
+}
+
+//Heartbeat registers/refreshes this replica
+func (m *Membership) Heartbeat() error {
+	return m.store.HSet(m.key, m.identity, strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+}
+
+//Leave removes this replica, the others take over its servers on their next cycle
+func (m *Membership) Leave() error {
+	_, err := m.store.HDel(m.key, []string{m.identity})
+	return err
+}
+
+//Members alive replicas(sorted), stale ones are removed
+func (m *Membership) Members() ([]string, error) {
+	values, err := m.store.HGetAll(m.key)
+	if err != nil {
+		return nil, err
+	}
+
+	minHeartbeat := time.Now().Add(-m.ttl).UnixNano() / int64(time.Millisecond)
+
+	members := make([]string, 0, len(values))
+	stale := make([]string, 0)
+	for identity, v := range values {
+		heartbeat, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || heartbeat < minHeartbeat {
+			stale = append(stale, identity)
+			continue
+		}
+		members = append(members, identity)
+	}
+
+	if len(stale) > 0 {
+		if _, err := m.store.HDel(m.key, stale); err != nil {
+			logger.Log().Error(fmt.Sprintf("sharding Members (Remove Stale) Error : %v", err.Error()))
+		} else {
+			logger.Log().Info(fmt.Sprintf("sharding stale members removed : %v", stale))
+		}
+	}
+
+	sort.Strings(members)
+	return members, nil
+}
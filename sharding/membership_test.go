@@ -0,0 +1,127 @@
+package sharding
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//fakeStore in-memory redis hashes
+type fakeStore struct {
+	hashes map[string]map[string]string
+	getErr error
+	delErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{hashes: make(map[string]map[string]string)}
+}
+
+func (s *fakeStore) HSet(key string, field string, value interface{}) error {
+	if s.hashes[key] == nil {
+		s.hashes[key] = make(map[string]string)
+	}
+	s.hashes[key][field] = value.(string)
+	return nil
+}
+
+func (s *fakeStore) HGetAll(key string) (map[string]string, error) {
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	resp := make(map[string]string, len(s.hashes[key]))
+	for field, value := range s.hashes[key] {
+		resp[field] = value
+	}
+	return resp, nil
+}
+
+func (s *fakeStore) HDel(key string, fields []string) (int64, error) {
+	if s.delErr != nil {
+		return 0, s.delErr
+	}
+	var count int64
+	for _, field := range fields {
+		if _, ok := s.hashes[key][field]; ok {
+			delete(s.hashes[key], field)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func heartbeatAt(at time.Time) string {
+	return strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func TestMembershipHeartbeatAndLeave(t *testing.T) {
+	store := newFakeStore()
+	m1 := NewMembership(store, "shardmembers", "agent-1", time.Minute)
+	m2 := NewMembership(store, "shardmembers", "agent-2", time.Minute)
+
+	for _, m := range []*Membership{m2, m1} {
+		if err := m.Heartbeat(); err != nil {
+			t.Fatalf("Heartbeat error : %v", err)
+		}
+	}
+
+	members, err := m1.Members()
+	if err != nil || !reflect.DeepEqual(members, []string{"agent-1", "agent-2"}) {
+		t.Errorf("members = %v (%v), want [agent-1 agent-2]", members, err)
+	}
+
+	if err := m2.Leave(); err != nil {
+		t.Fatalf("Leave error : %v", err)
+	}
+	members, _ = m1.Members()
+	if !reflect.DeepEqual(members, []string{"agent-1"}) {
+		t.Errorf("members after leave = %v, want [agent-1]", members)
+	}
+}
+
+func TestMembershipRemovesStaleMembers(t *testing.T) {
+	store := newFakeStore()
+	store.hashes["shardmembers"] = map[string]string{
+		"agent-1": heartbeatAt(time.Now()),
+		"agent-2": heartbeatAt(time.Now().Add(-2 * time.Minute)),
+		"agent-3": "broken",
+		"agent-4": heartbeatAt(time.Now().Add(-30 * time.Second)),
+	}
+	m := NewMembership(store, "shardmembers", "agent-1", time.Minute)
+
+	members, err := m.Members()
+	if err != nil || !reflect.DeepEqual(members, []string{"agent-1", "agent-4"}) {
+		t.Errorf("members = %v (%v), want [agent-1 agent-4]", members, err)
+	}
+
+	//stale ones are removed from the hash
+	if _, ok := store.hashes["shardmembers"]["agent-2"]; ok {
+		t.Errorf("stale member agent-2 not removed")
+	}
+	if _, ok := store.hashes["shardmembers"]["agent-3"]; ok {
+		t.Errorf("invalid member agent-3 not removed")
+	}
+}
+
+func TestMembershipStoreErrors(t *testing.T) {
+	store := newFakeStore()
+	store.hashes["shardmembers"] = map[string]string{
+		"agent-1": heartbeatAt(time.Now()),
+		"agent-2": heartbeatAt(time.Now().Add(-2 * time.Minute)),
+	}
+	m := NewMembership(store, "shardmembers", "agent-1", time.Minute)
+
+	//removing the stale ones failed, the alive members are still returned
+	store.delErr = errors.New("connection refused")
+	members, err := m.Members()
+	if err != nil || !reflect.DeepEqual(members, []string{"agent-1"}) {
+		t.Errorf("members = %v (%v), want [agent-1]", members, err)
+	}
+
+	store.getErr = errors.New("connection refused")
+	if _, err := m.Members(); err == nil {
+		t.Errorf("members error = nil, want the store error")
+	}
+}
@@ -0,0 +1,64 @@
+package sharding
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+//default virtual nodes per member, spreads the servers evenly across few members
+const defaultVirtualNodes = 64
+
+//Ring consistent hash ring of the members, immutable once built
+type Ring struct {
+	hashes  []uint32
+	members map[uint32]string
+}
+
+//NewRing builds the ring of the members with virtualNodes points each(<= 0 => default)
+func NewRing(members []string, virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	r := &Ring{members: make(map[uint32]string, len(members)*virtualNodes)}
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			h := hash(fmt.Sprintf("%s#%d", m, i))
+			//collision, the lowest member wins(same on every replica)
+			if existing, ok := r.members[h]; ok {
+				if m < existing {
+					r.members[h] = m
+				}
+				continue
+			}
+			r.hashes = append(r.hashes, h)
+			r.members[h] = m
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+//Owner member owning the key, empty when the ring has no members
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.members[r.hashes[i]]
+}
+
+func hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
@@ -0,0 +1,107 @@
+package sharding
+
+import (
+	"strconv"
+	"testing"
+)
+
+//serverIDs sql server ids "1".."n"
+func serverIDs(n int) []string {
+	ids := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	return ids
+}
+
+func owners(r *Ring, keys []string) map[string]string {
+	resp := make(map[string]string, len(keys))
+	for _, key := range keys {
+		resp[key] = r.Owner(key)
+	}
+	return resp
+}
+
+func TestRingOwner(t *testing.T) {
+	if got := NewRing(nil, 0).Owner("1"); got != "" {
+		t.Errorf("empty ring owner = %v, want none", got)
+	}
+
+	single := NewRing([]string{"agent-1"}, 0)
+	for _, key := range serverIDs(100) {
+		if got := single.Owner(key); got != "agent-1" {
+			t.Fatalf("single member ring owner of %v = %v, want agent-1", key, got)
+		}
+	}
+
+	//same ownership on every replica, whatever the order of the members
+	keys := serverIDs(500)
+	a := owners(NewRing([]string{"agent-1", "agent-2", "agent-3"}, 0), keys)
+	b := owners(NewRing([]string{"agent-3", "agent-1", "agent-2"}, 0), keys)
+	for _, key := range keys {
+		if a[key] != b[key] {
+			t.Errorf("owner of %v = %v and %v on differently ordered members", key, a[key], b[key])
+		}
+	}
+}
+
+func TestRingVirtualNodes(t *testing.T) {
+	cases := map[int]int{0: defaultVirtualNodes, -1: defaultVirtualNodes, 8: 8}
+	for virtualNodes, want := range cases {
+		r := NewRing([]string{"agent-1", "agent-2"}, virtualNodes)
+		if got := len(r.hashes); got != 2*want {
+			t.Errorf("virtual nodes %v: ring points = %v, want %v", virtualNodes, got, 2*want)
+		}
+	}
+}
+
+func TestRingEvenSpread(t *testing.T) {
+	keys := serverIDs(3000)
+	for _, members := range [][]string{
+		{"agent-1", "agent-2", "agent-3"},
+		{"agent-1", "agent-2", "agent-3", "agent-4"},
+	} {
+		counts := make(map[string]int)
+		for _, owner := range owners(NewRing(members, 0), keys) {
+			counts[owner]++
+		}
+
+		fairShare := len(keys) / len(members)
+		for _, m := range members {
+			if counts[m] < fairShare/2 || counts[m] > fairShare*3/2 {
+				t.Errorf("%v members: %v owns %v servers, want around %v", len(members), m, counts[m], fairShare)
+			}
+		}
+	}
+}
+
+func TestRingRebalance(t *testing.T) {
+	keys := serverIDs(3000)
+	before := owners(NewRing([]string{"agent-1", "agent-2", "agent-3"}, 0), keys)
+
+	//join: only the servers taken over by the new member move
+	joined := owners(NewRing([]string{"agent-1", "agent-2", "agent-3", "agent-4"}, 0), keys)
+	moved := 0
+	for _, key := range keys {
+		if joined[key] != before[key] {
+			moved++
+			if joined[key] != "agent-4" {
+				t.Errorf("join: %v moved from %v to %v, want agent-4", key, before[key], joined[key])
+			}
+		}
+	}
+	if moved == 0 || moved > len(keys)/2 {
+		t.Errorf("join: %v of %v servers moved", moved, len(keys))
+	}
+
+	//leave: only the servers of the gone member move
+	left := owners(NewRing([]string{"agent-1", "agent-3"}, 0), keys)
+	for _, key := range keys {
+		if before[key] != "agent-2" && left[key] != before[key] {
+			t.Errorf("leave: %v moved from %v to %v", key, before[key], left[key])
+		}
+		if left[key] == "agent-2" {
+			t.Errorf("leave: %v still owned by the gone member", key)
+		}
+	}
+}
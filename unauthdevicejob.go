@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"data-sync-agent/config"
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/entity"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+)
+
+//max. unauthorized devices looked up per cycle
+const unAuthDeviceBatchSize = 500
+
+//unAuthLookupResult lookup response of a single server
+type unAuthLookupResult struct {
+	task    *model.SQLConnectionData
+	devices []model.UnAuthDeviceResponse
+}
+
+//executeUnAuthDeviceJob looks up the reported devices on all the servers, records the owner server and updates mongo
+func executeUnAuthDeviceJob(redisKeyForUnAuthDevices string, mongoCollection string) {
+	deviceIDs, err := config.SMembers(redisKeyForUnAuthDevices)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("UnAuth Device Job (Redis) Error : %v", err.Error()))
+		return
+	}
+	if len(deviceIDs) == 0 {
+		return
+	}
+
+	sort.Strings(deviceIDs)
+	if len(deviceIDs) > unAuthDeviceBatchSize {
+		deviceIDs = deviceIDs[:unAuthDeviceBatchSize]
+	}
+
+	refreshServerCapabilities()
+	lookupResults, failedServers := lookupUnAuthDevices(deviceIDs)
+	resolvedDevices := resolveUnAuthDeviceOwners(deviceIDs, lookupResults)
+
+	//devices stay on the set(retried on the next cycle) when not completed on all the servers
+	pendingDevices := make(map[string]bool)
+
+	//devices not resolved to an active owner may be on the failed/skipped servers
+	if len(failedServers) > 0 {
+		for _, id := range deviceIDs {
+			if unAuthDeviceRank(resolvedDevices[id]) < unAuthRankActive {
+				pendingDevices[id] = true
+			}
+		}
+		logger.Log().Warn(fmt.Sprintf("UnAuth Device Job lookup failed on servers %v, PENDING DEVICE COUNT : %v", failedServers, len(pendingDevices)))
+	}
+
+	//recording the lookup on the servers the device was found, grouped by the resolved owner
+	for _, r := range lookupResults {
+		devicesByOwner := make(map[int][]model.UnAuthDeviceResponse)
+		for _, d := range r.devices {
+			if pendingDevices[d.DeviceID] {
+				continue
+			}
+			owner := resolvedDevices[d.DeviceID].ApplicationServerID
+			devicesByOwner[owner] = append(devicesByOwner[owner], d)
+		}
+
+		for owner, devices := range devicesByOwner {
+			count, err := sourcedriver.For(r.task).UpdUnAuthDeviceDetails(r.task, owner, devices)
+			if err != nil || count == 0 {
+				for _, d := range devices {
+					pendingDevices[d.DeviceID] = true
+				}
+				logger.Log().Error(fmt.Sprintf("UnAuth Device Job Server=%v Owner=%v update failed, PENDING DEVICE COUNT : %v", r.task.ServerID, owner, len(devices)))
+				continue
+			}
+			logger.Log().Info(fmt.Sprintf("UnAuth Device Job Server=%v Owner=%v UPDATED COUNT : %v", r.task.ServerID, owner, count))
+		}
+	}
+
+	completedDeviceIDs := make([]string, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if !pendingDevices[id] {
+			completedDeviceIDs = append(completedDeviceIDs, id)
+		}
+	}
+	if len(completedDeviceIDs) == 0 {
+		return
+	}
+
+	if mongoCollection != "" {
+		mongoDevices := make([]*model.UnAuthDeviceResponse, 0, len(completedDeviceIDs))
+		for _, id := range completedDeviceIDs {
+			mongoDevices = append(mongoDevices, resolvedDevices[id])
+		}
+
+		count, err := entity.BulkWriteUnAuth(mongoCollection, mongoDevices)
+		if err != nil {
+			//devices stay on the set, retried on the next cycle
+			logger.Log().Error(fmt.Sprintf("UnAuth Device Job (Mongo) Error : %v", err.Error()))
+			return
+		}
+		logger.Log().Info(fmt.Sprintf("UnAuth Device Job MONGO COUNT : %v", count))
+	}
+
+	if _, err := config.SRem(redisKeyForUnAuthDevices, completedDeviceIDs); err != nil {
+		logger.Log().Error(fmt.Sprintf("UnAuth Device Job (Redis Remove) Error : %v", err.Error()))
+	}
+}
+
+//lookupUnAuthDevices looks up the devices on all the onboarded servers(unauth lookup enabled), in parallel
+//returns the servers the devices are found on and the servers the lookup failed/skipped(unhealthy) on
+func lookupUnAuthDevices(deviceIDs []string) ([]unAuthLookupResult, []string) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	results := make([]unAuthLookupResult, 0, len(sqlConnectionListData))
+	failedServers := make([]string, 0)
+	for _, task := range sqlConnectionListData {
+		if !task.Capabilities().UnAuthLookup {
+			continue
+		}
+		if !task.Healthy() {
+			failedServers = append(failedServers, task.ServerID)
+			continue
+		}
+		wg.Add(1)
+
+		go func(t *model.SQLConnectionData) {
+			defer wg.Done()
+
+			devices, err := sourcedriver.For(t).GetUnAuthDeviceDetails(t, deviceIDs)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failedServers = append(failedServers, t.ServerID)
+				return
+			}
+			if len(devices) > 0 {
+				results = append(results, unAuthLookupResult{task: t, devices: devices})
+			}
+		}(task)
+	}
+	wg.Wait()
+
+	//deterministic owner resolution
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].task.ServerID < results[j].task.ServerID
+	})
+	sort.Strings(failedServers)
+
+	return results, failedServers
+}
+
+//owner ranks of the unauthorized device
+const (
+	unAuthRankNotFound = iota
+	unAuthRankFound
+	unAuthRankActive
+)
+
+//unAuthDeviceRank active device first, then the one with devices
+func unAuthDeviceRank(d *model.UnAuthDeviceResponse) int {
+	switch {
+	case d.Active == 1 && d.DeviceCount > 0:
+		return unAuthRankActive
+	case d.DeviceCount > 0:
+		return unAuthRankFound
+	default:
+		return unAuthRankNotFound
+	}
+}
+
+//resolveUnAuthDeviceOwners picks the owner server of each device(active device first, then the one with devices),
+//devices not found on any server are returned as inactive without an owner
+func resolveUnAuthDeviceOwners(deviceIDs []string, lookupResults []unAuthLookupResult) map[string]*model.UnAuthDeviceResponse {
+	resolved := make(map[string]*model.UnAuthDeviceResponse, len(deviceIDs))
+	for _, id := range deviceIDs {
+		resolved[id] = &model.UnAuthDeviceResponse{DeviceID: id}
+	}
+
+	for _, r := range lookupResults {
+		serverID, _ := strconv.Atoi(r.task.ServerID)
+
+		for i := range r.devices {
+			candidate := r.devices[i]
+			current, ok := resolved[candidate.DeviceID]
+			if !ok {
+				continue
+			}
+
+			//server the device is found on, unless the procedure reports it
+			if candidate.ApplicationServerID == 0 {
+				candidate.ApplicationServerID = serverID
+			}
+
+			if current.ApplicationServerID == 0 || unAuthDeviceRank(&candidate) > unAuthDeviceRank(current) {
+				resolved[candidate.DeviceID] = &candidate
+			} else if unAuthDeviceRank(&candidate) == unAuthDeviceRank(current) && current.ApplicationServerID != candidate.ApplicationServerID {
+				logger.Log().Warn(fmt.Sprintf("UnAuth Device Job DeviceID: %v found on servers %v and %v", candidate.DeviceID, current.ApplicationServerID, candidate.ApplicationServerID))
+			}
+		}
+	}
+
+	return resolved
+}
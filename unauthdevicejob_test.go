@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"data-sync-agent/dataservice/sourcedriver"
+	"data-sync-agent/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const testUnAuthDialect = "fakeunauth"
+
+//fakeUnAuthDriver source driver answering the unauth lookups by server
+type fakeUnAuthDriver struct {
+	fakeDriver
+
+	found     map[string][]model.UnAuthDeviceResponse
+	lookupErr map[string]error
+	updateErr map[string]error
+
+	mu      sync.Mutex
+	updated map[string][]string
+}
+
+func (f *fakeUnAuthDriver) Dialect() string { return testUnAuthDialect }
+
+func (f *fakeUnAuthDriver) GetUnAuthDeviceDetails(connData *model.SQLConnectionData, deviceList []string) ([]model.UnAuthDeviceResponse, error) {
+	if err := f.lookupErr[connData.ServerID]; err != nil {
+		return nil, err
+	}
+	return f.found[connData.ServerID], nil
+}
+
+func (f *fakeUnAuthDriver) UpdUnAuthDeviceDetails(connData *model.SQLConnectionData, applicationServerID int, deviceList []model.UnAuthDeviceResponse) (int64, error) {
+	if err := f.updateErr[connData.ServerID]; err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range deviceList {
+		f.updated[connData.ServerID] = append(f.updated[connData.ServerID], d.DeviceID)
+	}
+	return int64(len(deviceList)), nil
+}
+
+const (
+	testUnAuthSetKey     = "test:unauthdevices"
+	testUnAuthCollection = "tblunauthdevices"
+)
+
+//setupUnAuthJob servers 1, 2 and 3 with the devices u1, u2 and u3 reported as unauthorized
+func setupUnAuthJob(t *testing.T, driver *fakeUnAuthDriver, unhealthyServers ...string) (func() []string, func() map[string]bson.M) {
+	configStore, store := setupStores(t)
+	configStore.SAdd(testUnAuthSetKey, "u1", "u2", "u3")
+	store.InsertMany(context.Background(), testUnAuthCollection, []interface{}{bson.M{"_id": "u1"}, bson.M{"_id": "u2"}, bson.M{"_id": "u3"}})
+
+	driver.updated = make(map[string][]string)
+	sourcedriver.Register(driver)
+
+	redisKeyForSQLServers = ""
+	sqlConnectionListData = nil
+	for _, serverID := range []string{"1", "2", "3"} {
+		task := &model.SQLConnectionData{ServerID: serverID, Dialect: testUnAuthDialect}
+		task.SetCapabilities(model.DefaultServerCapabilities())
+		sqlConnectionListData = append(sqlConnectionListData, task)
+	}
+	for _, task := range sqlConnectionListData {
+		for _, serverID := range unhealthyServers {
+			if task.ServerID == serverID {
+				task.SetHealthy(false)
+			}
+		}
+	}
+
+	remaining := func() []string {
+		members, _ := configStore.SMembers(testUnAuthSetKey)
+		return members
+	}
+	recorded := func() map[string]bson.M {
+		docs, _ := store.Get(context.Background(), testUnAuthCollection, bson.M{"applicationserverid": bson.M{"$exists": true}}, nil)
+		resp := make(map[string]bson.M, len(docs))
+		for _, d := range docs {
+			resp[d["_id"].(string)] = d
+		}
+		return resp
+	}
+	return remaining, recorded
+}
+
+func TestUnAuthDeviceJobKeepsDevicesOfFailedLookups(t *testing.T) {
+	for name, unhealthy := range map[string]bool{"lookup error": false, "unhealthy server": true} {
+		t.Run(name, func(t *testing.T) {
+			driver := &fakeUnAuthDriver{
+				found: map[string][]model.UnAuthDeviceResponse{
+					"1": {{DeviceID: "u1", Active: 1, DeviceCount: 1}, {DeviceID: "u2", Active: 0, DeviceCount: 1}},
+				},
+				lookupErr: map[string]error{},
+			}
+
+			unhealthyServers := []string{}
+			if unhealthy {
+				unhealthyServers = append(unhealthyServers, "2")
+			} else {
+				driver.lookupErr["2"] = errors.New("connection reset")
+			}
+			remaining, recorded := setupUnAuthJob(t, driver, unhealthyServers...)
+
+			executeUnAuthDeviceJob(testUnAuthSetKey, testUnAuthCollection)
+
+			//u1 is active on server 1, u2(inactive) and u3(not found) may be on server 2
+			if got := remaining(); !reflect.DeepEqual(got, []string{"u2", "u3"}) {
+				t.Errorf("remaining devices = %v, want [u2 u3]", got)
+			}
+			if got := driver.updated["1"]; !reflect.DeepEqual(got, []string{"u1"}) {
+				t.Errorf("updated on server 1 = %v, want [u1]", got)
+			}
+
+			docs := recorded()
+			if len(docs) != 1 || docs["u1"]["applicationserverid"] != int32(1) {
+				t.Errorf("mongo devices = %v, want only u1 owned by server 1", docs)
+			}
+		})
+	}
+}
+
+func TestUnAuthDeviceJobKeepsDevicesOfFailedUpdates(t *testing.T) {
+	driver := &fakeUnAuthDriver{
+		found: map[string][]model.UnAuthDeviceResponse{
+			"1": {{DeviceID: "u1", Active: 1, DeviceCount: 1}},
+			"3": {{DeviceID: "u2", Active: 1, DeviceCount: 1}},
+		},
+		updateErr: map[string]error{"3": errors.New("deadlock victim")},
+	}
+	remaining, recorded := setupUnAuthJob(t, driver)
+
+	executeUnAuthDeviceJob(testUnAuthSetKey, testUnAuthCollection)
+
+	//u3 is not found on any of the servers, so completed as ownerless
+	if got := remaining(); !reflect.DeepEqual(got, []string{"u2"}) {
+		t.Errorf("remaining devices = %v, want [u2]", got)
+	}
+
+	docs := recorded()
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"u1", "u3"}) {
+		t.Errorf("mongo devices = %v, want [u1 u3]", ids)
+	}
+	if docs["u3"]["applicationserverid"] != int32(0) {
+		t.Errorf("u3 owner = %v, want 0", docs["u3"]["applicationserverid"])
+	}
+}
@@ -89,8 +89,6 @@ func stringToLogLevel(level string) zapcore.Level {
 	default:
 		return zap.DebugLevel
 	}
-
-	return zap.DebugLevel
 }
 
 // Singleton setting to hold global log related settings
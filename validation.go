@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"data-sync-agent/config"
+	"data-sync-agent/helper"
+	"data-sync-agent/model"
+	"data-sync-agent/utils/logger"
+	"data-sync-agent/validation"
+)
+
+//deviceValidator validates the fetched devices before they are saved/published
+var deviceValidator = validation.NewEngine(config.SMembers)
+
+//kinds of the quarantined devices, validation ones are released once valid, duplicate ones once a single server is left
+const (
+	quarantineKindValidation = "validation"
+	quarantineKindDuplicate  = "duplicate"
+)
+
+//QuarantinedDevice invalid device saved on the quarantine hash(<serverid>:<deviceid>)
+type QuarantinedDevice struct {
+	Kind          string                      `json:"kind"`
+	ServerID      string                      `json:"serverid"`
+	DeviceID      string                      `json:"deviceid"`
+	TenantUID     string                      `json:"tenantuid"`
+	Reasons       []string                    `json:"reasons"`
+	Device        *model.RegisteredDeviceData `json:"device"`
+	QuarantinedOn time.Time                   `json:"quarantinedon"`
+}
+
+//validateDevices returns the valid devices, the invalid ones are quarantined(released once they are valid again)
+func validateDevices(devices []*model.RegisteredDeviceData) []*model.RegisteredDeviceData {
+	if len(devices) == 0 {
+		return devices
+	}
+
+	//devices are only rejected once the rules are configured
+	redisKeyForValidationRules := helper.GetEnv(helper.RedisKeyForValidationRules)
+	if redisKeyForValidationRules == "" {
+		return devices
+	}
+
+	//rules are re-read on every cycle, so they can be changed without a deploy
+	values, err := config.HGetAll(redisKeyForValidationRules)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("validateDevices Redis Rules Error : %v", err.Error()))
+	} else {
+		for _, err := range deviceValidator.Load(values) {
+			logger.Log().Error(fmt.Sprintf("validateDevices Rules Error : %v", err.Error()))
+		}
+	}
+
+	valid, violations := deviceValidator.Validate(devices)
+	if len(violations) > 0 {
+		logger.Log().Warn(fmt.Sprintf("validateDevices %v / %v devices are invalid", len(violations), len(devices)))
+	}
+
+	quarantineDevices(quarantineKindValidation, violations)
+	releaseQuarantinedDevices(valid)
+
+	return valid
+}
+
+//quarantineDevices saving the invalid devices with the reasons(only logged, when the quarantine hash is not configured)
+func quarantineDevices(kind string, violations []validation.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+
+	redisKeyForQuarantinedDevices := helper.GetEnv(helper.RedisKeyForQuarantinedDevices)
+	if redisKeyForQuarantinedDevices == "" {
+		for _, v := range violations {
+			logger.Log().Warn(fmt.Sprintf("quarantineDevices Server=%v DeviceID=%v rejected : %v", v.Device.ServerID, v.Device.DeviceID, v.Reasons))
+		}
+		return
+	}
+
+	quarantined := make(map[string]interface{}, len(violations))
+	now := time.Now().UTC()
+	for _, v := range violations {
+		jsonData, err := json.Marshal(QuarantinedDevice{
+			Kind:          kind,
+			ServerID:      v.Device.ServerID,
+			DeviceID:      v.Device.DeviceID,
+			TenantUID:     v.Device.TenantUID,
+			Reasons:       v.Reasons,
+			Device:        v.Device,
+			QuarantinedOn: now,
+		})
+		if err != nil {
+			logger.Log().Error(fmt.Sprintf("quarantineDevices Marshal DeviceID=%v Error : %v", v.Device.DeviceID, err.Error()))
+			continue
+		}
+		quarantined[quarantineField(v.Device)] = string(jsonData)
+	}
+	if len(quarantined) > 0 {
+		if err := config.HMSet(redisKeyForQuarantinedDevices, quarantined); err != nil {
+			logger.Log().Error(fmt.Sprintf("quarantineDevices Redis Error : %v", err.Error()))
+		}
+	}
+}
+
+//releaseQuarantinedDevices releasing the devices fixed on the source(the duplicate ones are kept)
+func releaseQuarantinedDevices(devices []*model.RegisteredDeviceData) {
+	redisKeyForQuarantinedDevices := helper.GetEnv(helper.RedisKeyForQuarantinedDevices)
+	if redisKeyForQuarantinedDevices == "" || len(devices) == 0 {
+		return
+	}
+
+	fields := make([]string, 0, len(devices))
+	for _, d := range devices {
+		fields = append(fields, quarantineField(d))
+	}
+
+	//only the quarantined ones are released, instead of a delete of every valid device on every cycle
+	values, err := config.HMGet(redisKeyForQuarantinedDevices, fields)
+	if err != nil {
+		logger.Log().Error(fmt.Sprintf("releaseQuarantinedDevices Redis Error : %v", err.Error()))
+		return
+	}
+	released := make([]string, 0)
+	for i, value := range values {
+		v, ok := value.(string)
+		if !ok {
+			continue
+		}
+		quarantined := QuarantinedDevice{}
+		if err := json.Unmarshal([]byte(v), &quarantined); err == nil && quarantined.Kind == quarantineKindDuplicate {
+			continue
+		}
+		released = append(released, fields[i])
+	}
+	if len(released) == 0 {
+		return
+	}
+
+	if count, err := config.HDel(redisKeyForQuarantinedDevices, released); err != nil {
+		logger.Log().Error(fmt.Sprintf("releaseQuarantinedDevices Redis Error : %v", err.Error()))
+	} else if count > 0 {
+		logger.Log().Info(fmt.Sprintf("releaseQuarantinedDevices %v devices released from the quarantine", count))
+	}
+}
+
+//releaseDuplicateQuarantine releasing the duplicate quarantine of the device on the servers
+func releaseDuplicateQuarantine(deviceID string, serverIDs []string) {
+	redisKeyForQuarantinedDevices := helper.GetEnv(helper.RedisKeyForQuarantinedDevices)
+	if redisKeyForQuarantinedDevices == "" || len(serverIDs) == 0 {
+		return
+	}
+
+	fields := make([]string, 0, len(serverIDs))
+	for _, serverID := range serverIDs {
+		fields = append(fields, quarantineField(&model.RegisteredDeviceData{ServerID: serverID, DeviceID: deviceID}))
+	}
+	if _, err := config.HDel(redisKeyForQuarantinedDevices, fields); err != nil {
+		logger.Log().Error(fmt.Sprintf("releaseDuplicateQuarantine DeviceID=%v Redis Error : %v", deviceID, err.Error()))
+	}
+}
+
+func quarantineField(d *model.RegisteredDeviceData) string {
+	return d.ServerID + ":" + d.DeviceID
+}
@@ -0,0 +1,305 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	model "data-sync-agent/model"
+)
+
+//ErrInvalidRule indicates a rule with an unknown field or invalid values
+var ErrInvalidRule = errors.New("invalid validation rule")
+
+//AllTenants rules applied to the devices of every tenant, unless the tenant has its own rule for the field
+const AllTenants = "*"
+
+//Rule declarative check of a device field(all the set checks are applied)
+type Rule struct {
+	Field    string   `json:"field"`
+	Required bool     `json:"required"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	In       []string `json:"in,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	//RefSet redis set of the known values(referential check), e.g. the device type ids
+	RefSet string `json:"refset,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+//RefLoader returns the members of a reference set
+type RefLoader func(key string) ([]string, error)
+
+//Violation invalid device with the reasons
+type Violation struct {
+	Device  *model.RegisteredDeviceData
+	Reasons []string
+}
+
+//Engine validates the devices with the rules of their tenant
+type Engine struct {
+	refLoader RefLoader
+
+	mu    sync.RWMutex
+	rules map[string]map[string]*Rule
+}
+
+//DefaultRules applied when the configured rules have none for all the tenants
+func DefaultRules() []*Rule {
+	one := float64(1)
+	return []*Rule{
+		{Field: "deviceid", Required: true},
+		{Field: "tenantuid", Required: true},
+		{Field: "parserid", Min: &one},
+		{Field: "devicetypeid", Min: &one},
+	}
+}
+
+//NewEngine ...(refLoader nil => reference set checks are skipped)
+func NewEngine(refLoader RefLoader) *Engine {
+	e := &Engine{refLoader: refLoader, rules: make(map[string]map[string]*Rule)}
+	e.SetRules(AllTenants, DefaultRules())
+	return e
+}
+
+//SetRules replacing the rules of the tenant(AllTenants => the common rules)
+func (e *Engine) SetRules(tenantUID string, rules []*Rule) error {
+	byField := make(map[string]*Rule, len(rules))
+	for _, r := range rules {
+		if err := compile(r); err != nil {
+			return fmt.Errorf("tenant %v %w", tenantUID, err)
+		}
+		byField[r.Field] = r
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules[tenantUID] = byField
+	return nil
+}
+
+//Load rules stored on the redis hash(tenant uid or * => rules json array), tenants not on the hash fall back to the common rules
+func (e *Engine) Load(values map[string]string) []error {
+	errs := make([]error, 0)
+	rules := map[string]map[string]*Rule{AllTenants: nil}
+
+	for tenantUID, v := range values {
+		tenantRules := make([]*Rule, 0)
+		if err := json.Unmarshal([]byte(v), &tenantRules); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %v %w: %v", tenantUID, ErrInvalidRule, err.Error()))
+			continue
+		}
+
+		byField := make(map[string]*Rule, len(tenantRules))
+		valid := true
+		for _, r := range tenantRules {
+			if err := compile(r); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %v %w", tenantUID, err))
+				valid = false
+				break
+			}
+			byField[r.Field] = r
+		}
+		if valid {
+			rules[tenantUID] = byField
+		}
+	}
+
+	if rules[AllTenants] == nil {
+		rules[AllTenants] = make(map[string]*Rule)
+		for _, r := range DefaultRules() {
+			compile(r)
+			rules[AllTenants][r.Field] = r
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	return errs
+}
+
+func compile(r *Rule) error {
+	r.Field = strings.ToLower(strings.TrimSpace(r.Field))
+	if !isKnownField(r.Field) {
+		return fmt.Errorf("%w: unknown field '%v'", ErrInvalidRule, r.Field)
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("%w: %v min > max", ErrInvalidRule, r.Field)
+	}
+	if r.Pattern != "" {
+		p, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: %v pattern %v", ErrInvalidRule, r.Field, err.Error())
+		}
+		r.pattern = p
+	}
+	return nil
+}
+
+//Validate splitting the devices into valid ones and violations,
+//deactivated devices only need the device id(their removal must go through)
+func (e *Engine) Validate(devices []*model.RegisteredDeviceData) ([]*model.RegisteredDeviceData, []Violation) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	valid := make([]*model.RegisteredDeviceData, 0, len(devices))
+	violations := make([]Violation, 0)
+	refSets := make(map[string]map[string]bool)
+
+	for _, d := range devices {
+		var reasons []string
+		if d.Active != 1 {
+			if strings.TrimSpace(d.DeviceID) == "" {
+				reasons = []string{"deviceid is required"}
+			}
+		} else {
+			reasons = e.check(d, refSets)
+		}
+
+		if len(reasons) > 0 {
+			violations = append(violations, Violation{Device: d, Reasons: reasons})
+			continue
+		}
+		valid = append(valid, d)
+	}
+
+	return valid, violations
+}
+
+//check rules of the tenant, over the common rules
+func (e *Engine) check(d *model.RegisteredDeviceData, refSets map[string]map[string]bool) []string {
+	rules := make(map[string]*Rule, len(e.rules[AllTenants]))
+	for field, r := range e.rules[AllTenants] {
+		rules[field] = r
+	}
+	for field, r := range e.rules[d.TenantUID] {
+		rules[field] = r
+	}
+
+	fields := make([]string, 0, len(rules))
+	for field := range rules {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	reasons := make([]string, 0)
+	for _, field := range fields {
+		reasons = append(reasons, e.checkRule(rules[field], d, refSets)...)
+	}
+	return reasons
+}
+
+func (e *Engine) checkRule(r *Rule, d *model.RegisteredDeviceData, refSets map[string]map[string]bool) []string {
+	value, number, isNumber := fieldValue(d, r.Field)
+	empty := strings.TrimSpace(value) == "" || (isNumber && number == 0)
+
+	if empty {
+		if r.Required {
+			return []string{fmt.Sprintf("%v is required", r.Field)}
+		}
+		//optional numbers are still range checked(e.g. parserid 0)
+		if !isNumber {
+			return nil
+		}
+	}
+
+	reasons := make([]string, 0)
+	if isNumber && r.Min != nil && number < *r.Min {
+		reasons = append(reasons, fmt.Sprintf("%v %v is less than %v", r.Field, value, *r.Min))
+	}
+	if isNumber && r.Max != nil && number > *r.Max {
+		reasons = append(reasons, fmt.Sprintf("%v %v is greater than %v", r.Field, value, *r.Max))
+	}
+	if len(r.In) > 0 && !contains(r.In, value) {
+		reasons = append(reasons, fmt.Sprintf("%v %v is not one of %v", r.Field, value, r.In))
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		reasons = append(reasons, fmt.Sprintf("%v %v doesn't match %v", r.Field, value, r.Pattern))
+	}
+	if r.RefSet != "" && e.refLoader != nil {
+		members, ok := refSets[r.RefSet]
+		if !ok {
+			members = e.loadRefSet(r.RefSet)
+			refSets[r.RefSet] = members
+		}
+		//unavailable reference sets are not checked, rather than quarantining every device
+		if members != nil && !members[value] {
+			reasons = append(reasons, fmt.Sprintf("%v %v is unknown(%v)", r.Field, value, r.RefSet))
+		}
+	}
+	return reasons
+}
+
+func (e *Engine) loadRefSet(key string) map[string]bool {
+	values, err := e.refLoader(key)
+	//missing(empty) sets are treated as unavailable, e.g. not seeded yet
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	members := make(map[string]bool, len(values))
+	for _, v := range values {
+		members[v] = true
+	}
+	return members
+}
+
+//knownFields device fields(json names) the rules can refer,
+//communicationgroupid is not one of them, the groups are assigned after the validation
+var knownFields = map[string]bool{
+	"deviceid": true, "vehicleid": true, "tenantgroupuid": true, "tenantuid": true, "providertenantuids": true, "tenantname": true,
+	"batchprocessgroupid": true, "parserid": true, "devicemasterstatusuno": true, "devicetypeid": true, "active": true,
+}
+
+func isKnownField(field string) bool {
+	return knownFields[field]
+}
+
+//fieldValue value of the field(by json name), numbers are also returned as float
+func fieldValue(d *model.RegisteredDeviceData, field string) (string, float64, bool) {
+	switch field {
+	case "deviceid":
+		return d.DeviceID, 0, false
+	case "vehicleid":
+		return d.VehicleID, 0, false
+	case "tenantgroupuid":
+		return d.TenantGroupUID, 0, false
+	case "tenantuid":
+		return d.TenantUID, 0, false
+	case "providertenantuids":
+		return d.ProviderTenantUIDs, 0, false
+	case "tenantname":
+		return d.TenantName, 0, false
+	case "batchprocessgroupid":
+		return number(d.BatchProcessGroupID)
+	case "parserid":
+		return number(d.ParserID)
+	case "devicemasterstatusuno":
+		return number(d.DeviceMasterStatusUno)
+	case "devicetypeid":
+		return number(d.DeviceTypeID)
+	case "active":
+		return number(d.Active)
+	default:
+		return "", 0, false
+	}
+}
+
+func number(v int) (string, float64, bool) {
+	return strconv.Itoa(v), float64(v), true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
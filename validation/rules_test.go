@@ -0,0 +1,220 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	model "data-sync-agent/model"
+)
+
+func device(deviceID, tenantUID string) *model.RegisteredDeviceData {
+	return &model.RegisteredDeviceData{DeviceID: deviceID, TenantUID: tenantUID, ParserID: 1, DeviceTypeID: 1, Active: 1}
+}
+
+//reasonsOf reasons of the violations by device id
+func reasonsOf(violations []Violation) map[string][]string {
+	resp := make(map[string][]string, len(violations))
+	for _, v := range violations {
+		resp[v.Device.DeviceID] = v.Reasons
+	}
+	return resp
+}
+
+func TestDefaultRules(t *testing.T) {
+	e := NewEngine(nil)
+
+	noTenant := device("d2", "")
+	noParser := device("d3", "t1")
+	noParser.ParserID = 0
+	//deactivated devices only need the device id
+	deactivated := &model.RegisteredDeviceData{DeviceID: "d4"}
+	noID := &model.RegisteredDeviceData{}
+
+	valid, violations := e.Validate([]*model.RegisteredDeviceData{device("d1", "t1"), noTenant, noParser, deactivated, noID})
+
+	if len(valid) != 2 || valid[0].DeviceID != "d1" || valid[1].DeviceID != "d4" {
+		t.Errorf("valid = %v, want d1 d4", valid)
+	}
+	want := map[string][]string{
+		"d2": {"tenantuid is required"},
+		"d3": {"parserid 0 is less than 1"},
+		"":   {"deviceid is required"},
+	}
+	if got := reasonsOf(violations); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+}
+
+func TestLoadTenantRulesOverrideCommonRules(t *testing.T) {
+	e := NewEngine(nil)
+	errs := e.Load(map[string]string{
+		AllTenants: `[{"field":"deviceid","required":true},{"field":"devicetypeid","min":1}]`,
+		"t2":       `[{"field":"DeviceTypeID","min":0},{"field":"vehicleid","required":true}]`,
+	})
+	if len(errs) > 0 {
+		t.Fatalf("Load errors : %v", errs)
+	}
+
+	d1 := device("d1", "t1")
+	d1.DeviceTypeID = 0
+	d2 := device("d2", "t2")
+	d2.DeviceTypeID = 0
+	d2.VehicleID = "v2"
+	d3 := device("d3", "t2")
+
+	valid, violations := e.Validate([]*model.RegisteredDeviceData{d1, d2, d3})
+	if len(valid) != 1 || valid[0].DeviceID != "d2" {
+		t.Errorf("valid = %v, want d2", valid)
+	}
+	want := map[string][]string{
+		"d1": {"devicetypeid 0 is less than 1"},
+		"d3": {"vehicleid is required"},
+	}
+	if got := reasonsOf(violations); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+}
+
+func TestLoadWithoutCommonRulesUsesDefaults(t *testing.T) {
+	e := NewEngine(nil)
+	e.Load(map[string]string{"t2": `[{"field":"vehicleid","required":true}]`})
+
+	_, violations := e.Validate([]*model.RegisteredDeviceData{device("", "t1")})
+	if got := reasonsOf(violations)[""]; !reflect.DeepEqual(got, []string{"deviceid is required"}) {
+		t.Errorf("reasons = %v, want the default deviceid rule", got)
+	}
+}
+
+func TestLoadInvalidTenantRules(t *testing.T) {
+	e := NewEngine(nil)
+	errs := e.Load(map[string]string{
+		AllTenants: `[{"field":"deviceid","required":true}]`,
+		"t1":       `[{"field":"vehicleid","required":true}`,
+		"t2":       `[{"field":"vehicleid","required":true},{"field":"color","required":true}]`,
+		"t3":       `[{"field":"parserid","min":5,"max":1}]`,
+		"t4":       `[{"field":"vehicleid","pattern":"("}]`,
+		"t5":       `[{"field":"communicationgroupid","min":1}]`,
+	})
+
+	if len(errs) != 5 {
+		t.Fatalf("errors = %v, want one per invalid tenant", errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("error = %v, want %v", err, ErrInvalidRule)
+		}
+	}
+
+	//invalid tenants fall back to the common rules(none of their rules is applied)
+	devices := make([]*model.RegisteredDeviceData, 0)
+	for _, tenantUID := range []string{"t1", "t2", "t3", "t4", "t5"} {
+		devices = append(devices, device("d-"+tenantUID, tenantUID))
+	}
+	valid, violations := e.Validate(devices)
+	if len(valid) != len(devices) {
+		t.Errorf("violations = %v, want none", reasonsOf(violations))
+	}
+}
+
+func TestRuleChecks(t *testing.T) {
+	one, ten := float64(1), float64(10)
+	cases := map[string]struct {
+		rule   *Rule
+		device func(d *model.RegisteredDeviceData)
+		want   []string
+	}{
+		"min": {
+			&Rule{Field: "batchprocessgroupid", Min: &one},
+			func(d *model.RegisteredDeviceData) { d.BatchProcessGroupID = 0 },
+			[]string{"batchprocessgroupid 0 is less than 1"},
+		},
+		"max": {
+			&Rule{Field: "parserid", Max: &ten},
+			func(d *model.RegisteredDeviceData) { d.ParserID = 11 },
+			[]string{"parserid 11 is greater than 10"},
+		},
+		"min max in range": {
+			&Rule{Field: "parserid", Min: &one, Max: &ten},
+			func(d *model.RegisteredDeviceData) { d.ParserID = 10 },
+			nil,
+		},
+		"in": {
+			&Rule{Field: "devicetypeid", In: []string{"1", "2"}},
+			func(d *model.RegisteredDeviceData) { d.DeviceTypeID = 3 },
+			[]string{"devicetypeid 3 is not one of [1 2]"},
+		},
+		"pattern": {
+			&Rule{Field: "vehicleid", Pattern: "^V[0-9]+$"},
+			func(d *model.RegisteredDeviceData) { d.VehicleID = "X1" },
+			[]string{"vehicleid X1 doesn't match ^V[0-9]+$"},
+		},
+		"pattern of an optional empty value": {
+			&Rule{Field: "vehicleid", Pattern: "^V[0-9]+$"},
+			func(d *model.RegisteredDeviceData) { d.VehicleID = "" },
+			nil,
+		},
+		"required": {
+			&Rule{Field: "vehicleid", Required: true, Pattern: "^V[0-9]+$"},
+			func(d *model.RegisteredDeviceData) { d.VehicleID = " " },
+			[]string{"vehicleid is required"},
+		},
+	}
+	for name, c := range cases {
+		e := NewEngine(nil)
+		if err := e.SetRules(AllTenants, []*Rule{c.rule}); err != nil {
+			t.Fatalf("%v SetRules error : %v", name, err)
+		}
+
+		d := device("d1", "t1")
+		c.device(d)
+		_, violations := e.Validate([]*model.RegisteredDeviceData{d})
+		if got := reasonsOf(violations)["d1"]; !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v reasons = %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+func TestRefSetRule(t *testing.T) {
+	refSets := map[string][]string{"devicetypes": {"1", "2"}, "emptyset": {}}
+	loads := 0
+	refLoader := func(key string) ([]string, error) {
+		loads++
+		if members, ok := refSets[key]; ok {
+			return members, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	e := NewEngine(refLoader)
+	e.SetRules(AllTenants, []*Rule{{Field: "devicetypeid", RefSet: "devicetypes"}})
+
+	known, unknown := device("d1", "t1"), device("d2", "t1")
+	unknown.DeviceTypeID = 3
+	_, violations := e.Validate([]*model.RegisteredDeviceData{known, unknown, device("d3", "t1")})
+
+	want := map[string][]string{"d2": {"devicetypeid 3 is unknown(devicetypes)"}}
+	if got := reasonsOf(violations); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+	//loaded once per validation
+	if loads != 1 {
+		t.Errorf("reference set loads = %v, want 1", loads)
+	}
+
+	//missing/unavailable sets are not checked
+	for _, refSet := range []string{"emptyset", "unavailable"} {
+		e.SetRules(AllTenants, []*Rule{{Field: "devicetypeid", RefSet: refSet}})
+		if _, violations := e.Validate([]*model.RegisteredDeviceData{unknown}); len(violations) > 0 {
+			t.Errorf("%v violations = %v, want none", refSet, reasonsOf(violations))
+		}
+	}
+}
+
+func TestSetRulesRejectsCommunicationGroup(t *testing.T) {
+	err := NewEngine(nil).SetRules("t1", []*Rule{{Field: "communicationgroupid", Required: true}})
+	if !errors.Is(err, ErrInvalidRule) || !strings.Contains(err.Error(), "unknown field 'communicationgroupid'") {
+		t.Errorf("error = %v, want the unknown field error", err)
+	}
+}
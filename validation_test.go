@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"data-sync-agent/config"
+	cm "data-sync-agent/config/conman"
+	"data-sync-agent/helper"
+)
+
+const (
+	testValidationRulesKey = "test:validationrules"
+	testQuarantineKey      = "test:quarantineddevices"
+)
+
+//recordingConfigStore memory store recording the deleted hash fields
+type recordingConfigStore struct {
+	*cm.MemoryProvider
+	deleted []string
+}
+
+func (rs *recordingConfigStore) HDel(key string, fields []string) (int64, error) {
+	rs.deleted = append(rs.deleted, fields...)
+	return rs.MemoryProvider.HDel(key, fields)
+}
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestValidateDevicesWithoutRulesKeepsAllDevices(t *testing.T) {
+	setupStores(t)
+	setEnv(t, helper.RedisKeyForValidationRules, "")
+
+	devices := testDevices()
+	devices[1].ParserID = 0
+	devices[2].DeviceTypeID = 0
+
+	if valid := validateDevices(devices); len(valid) != len(devices) {
+		t.Errorf("valid devices = %v, want all %v", len(valid), len(devices))
+	}
+}
+
+func TestValidateDevicesReleasesOnlyQuarantinedDevices(t *testing.T) {
+	configStore, _ := setupStores(t)
+	recorder := &recordingConfigStore{MemoryProvider: configStore}
+	config.SetConfigProvider(recorder)
+	setEnv(t, helper.RedisKeyForValidationRules, testValidationRulesKey)
+	setEnv(t, helper.RedisKeyForQuarantinedDevices, testQuarantineKey)
+
+	//d3 was invalid on the previous cycle
+	configStore.HSet(testQuarantineKey, "2:d3", "{}")
+
+	devices := testDevices()
+	devices[1].ParserID = 0
+
+	valid := validateDevices(devices)
+	if len(valid) != 4 {
+		t.Errorf("valid devices = %v, want 4", len(valid))
+	}
+	if !reflect.DeepEqual(recorder.deleted, []string{"2:d3"}) {
+		t.Errorf("released devices = %v, want [2:d3]", recorder.deleted)
+	}
+
+	quarantined, _ := configStore.HGetAll(testQuarantineKey)
+	if _, ok := quarantined["1:d2"]; !ok || len(quarantined) != 1 {
+		t.Errorf("quarantined devices = %v, want only 1:d2", quarantined)
+	}
+}